@@ -0,0 +1,598 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Algo selects how in-memory store counts requests of every visitor.
+type Algo int
+
+const (
+	// AlgoTokenBucket refills bucket with limit tokens per second, up to burst tokens. Used by default.
+	AlgoTokenBucket Algo = iota
+	// AlgoSlidingWindow allows at most burst requests in any window of burst / limit seconds, keeping time of every request in the window.
+	AlgoSlidingWindow
+	// AlgoFixedWindow allows at most burst requests in every window of burst / limit seconds, keeping only a counter. Up to twice as many requests may pass around window boundary.
+	AlgoFixedWindow
+	// AlgoGCRA spaces requests evenly by 1 / limit seconds, letting up to burst of them arrive earlier. Only theoretical arrival time is kept per visitor.
+	AlgoGCRA
+	// AlgoSlidingWindowCounter allows at most burst requests in window of burst / limit seconds, estimating count in the window from counters
+	// of the current and the previous fixed windows weighted by their overlap with it. Keeps two counters, but has no boundary burst of AlgoFixedWindow.
+	AlgoSlidingWindowCounter
+)
+
+// Algorithm sets algorithm used by in-memory store. Has no effect when store is set with WithStore.
+func Algorithm(a Algo) option {
+	return func(opts *limiterOptions) {
+		opts.algo = a
+	}
+}
+
+type (
+	// bucket decides if visitor is allowed at given time, implementations are safe for concurrent use.
+	bucket interface {
+		// allow takes n tokens at once, if there are not enough of them none are taken.
+		allow(now time.Time, n int) bool
+		// penalize takes n tokens regardless of how many are left, so visitor has to wait longer.
+		penalize(now time.Time, n int)
+		// refund gives back n tokens taken by allow, bucket never gets more than burst of them.
+		refund(now time.Time, n int)
+		// delay returns how long visitor has to wait from now, reports false if it will never be allowed.
+		delay(now time.Time) (time.Duration, bool)
+		// tokens returns how many requests visitor can make at now, it is negative for penalized visitors and infinite for unlimited ones.
+		tokens(now time.Time) float64
+		setLimit(limit rate.Limit, burst int)
+	}
+
+	tokenBucket struct {
+		*rate.Limiter
+	}
+
+	slidingWindow struct {
+		sync.Mutex
+		events []time.Time
+		window time.Duration
+		max    int
+		inf    bool
+	}
+
+	fixedWindow struct {
+		sync.Mutex
+		start  time.Time
+		count  int
+		window time.Duration
+		max    int
+		inf    bool
+	}
+
+	slidingWindowCounter struct {
+		sync.Mutex
+		start  time.Time
+		prev   int
+		curr   int
+		window time.Duration
+		max    int
+		inf    bool
+	}
+
+	gcra struct {
+		sync.Mutex
+		tat      time.Time
+		interval time.Duration
+		burst    int
+		inf      bool
+	}
+)
+
+func newBucket(a Algo, limit rate.Limit, burst int) bucket {
+	switch a {
+	case AlgoSlidingWindow:
+		return &slidingWindow{
+			window: window(limit, burst),
+			max:    burst,
+			inf:    limit == rate.Inf,
+		}
+	case AlgoFixedWindow:
+		return &fixedWindow{
+			window: window(limit, burst),
+			max:    burst,
+			inf:    limit == rate.Inf,
+		}
+	case AlgoSlidingWindowCounter:
+		return &slidingWindowCounter{
+			window: window(limit, burst),
+			max:    burst,
+			inf:    limit == rate.Inf,
+		}
+	case AlgoGCRA:
+		b := &gcra{}
+		b.setLimit(limit, burst)
+
+		return b
+	default:
+		return tokenBucket{rate.NewLimiter(limit, burst)}
+	}
+}
+
+// window returns time needed to refill burst tokens with limit.
+func window(limit rate.Limit, burst int) time.Duration {
+	if limit <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Duration(math.Ceil(float64(burst) / float64(limit) * float64(time.Second)))
+}
+
+func (b tokenBucket) allow(now time.Time, n int) bool {
+	return b.AllowN(now, n)
+}
+
+// penalize reserves tokens without using them, taking bucket below zero. Reservations larger than burst are not allowed, so they are split.
+func (b tokenBucket) penalize(now time.Time, n int) {
+	for burst := b.Burst(); n > 0 && burst > 0; n -= burst {
+		b.ReserveN(now, min(n, burst))
+	}
+}
+
+// refund takes negative number of tokens, rate.Limiter caps them at burst on its next use. With zero limit burst is what is left,
+// so it grows back.
+func (b tokenBucket) refund(now time.Time, n int) {
+	b.AllowN(now, -n)
+}
+
+// delay reserves a token to see when it becomes available and gives it back right away.
+func (b tokenBucket) delay(now time.Time) (time.Duration, bool) {
+	r := b.ReserveN(now, 1)
+	if !r.OK() {
+		return 0, false
+	}
+
+	defer r.CancelAt(now)
+
+	return r.DelayFrom(now), true
+}
+
+func (b tokenBucket) tokens(now time.Time) float64 {
+	return b.TokensAt(now)
+}
+
+func (b tokenBucket) setLimit(limit rate.Limit, burst int) {
+	b.SetLimit(limit)
+	b.SetBurst(burst)
+}
+
+func (b *slidingWindow) allow(now time.Time, n int) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return true
+	}
+
+	b.trim(now)
+
+	if len(b.events)+n > b.max {
+		return false
+	}
+
+	for i := 0; i < n; i++ {
+		b.events = append(b.events, now)
+	}
+
+	return true
+}
+
+// penalize records n more requests at now, keeping window full until they leave it.
+func (b *slidingWindow) penalize(now time.Time, n int) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return
+	}
+
+	b.trim(now)
+
+	for i := 0; i < n; i++ {
+		b.events = append(b.events, now)
+	}
+}
+
+// refund forgets n latest requests in the window.
+func (b *slidingWindow) refund(now time.Time, n int) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return
+	}
+
+	b.trim(now)
+	b.events = b.events[:max(0, len(b.events)-n)]
+}
+
+// delay returns time left until the oldest request leaves the window.
+func (b *slidingWindow) delay(now time.Time) (time.Duration, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return 0, true
+	}
+
+	if b.max <= 0 {
+		return 0, false
+	}
+
+	b.trim(now)
+
+	if len(b.events) < b.max {
+		return 0, true
+	}
+
+	return b.events[0].Add(b.window).Sub(now), true
+}
+
+func (b *slidingWindow) tokens(now time.Time) float64 {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return math.Inf(1)
+	}
+
+	b.trim(now)
+
+	return float64(b.max - len(b.events))
+}
+
+func (b *slidingWindow) setLimit(limit rate.Limit, burst int) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.window, b.max, b.inf = window(limit, burst), burst, limit == rate.Inf
+}
+
+// trim drops requests that left the window.
+func (b *slidingWindow) trim(now time.Time) {
+	i := 0
+	for i < len(b.events) && now.Sub(b.events[i]) >= b.window {
+		i++
+	}
+
+	n := copy(b.events, b.events[i:])
+	b.events = b.events[:n]
+}
+
+func (b *fixedWindow) allow(now time.Time, n int) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return true
+	}
+
+	b.roll(now)
+
+	if b.count+n > b.max {
+		return false
+	}
+
+	b.count += n
+
+	return true
+}
+
+// penalize counts n more requests in the current window. Counter is reset with the next window, so penalty does not outlast it.
+func (b *fixedWindow) penalize(now time.Time, n int) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return
+	}
+
+	b.roll(now)
+	b.count += n
+}
+
+// refund uncounts n requests of the current window, the ones counted in a window that already ended are gone anyway.
+func (b *fixedWindow) refund(now time.Time, n int) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return
+	}
+
+	b.roll(now)
+	b.count = max(0, b.count-n)
+}
+
+// delay returns time left until the current window ends.
+func (b *fixedWindow) delay(now time.Time) (time.Duration, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return 0, true
+	}
+
+	if b.max <= 0 {
+		return 0, false
+	}
+
+	b.roll(now)
+
+	if b.count < b.max {
+		return 0, true
+	}
+
+	return b.start.Add(b.window).Sub(now), true
+}
+
+func (b *fixedWindow) tokens(now time.Time) float64 {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return math.Inf(1)
+	}
+
+	b.roll(now)
+
+	return float64(b.max - b.count)
+}
+
+func (b *fixedWindow) setLimit(limit rate.Limit, burst int) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.window, b.max, b.inf = window(limit, burst), burst, limit == rate.Inf
+}
+
+// roll starts a new window and resets counter if now is past the current one.
+func (b *fixedWindow) roll(now time.Time) {
+	if now.Sub(b.start) >= b.window {
+		b.start = now.Truncate(b.window)
+		b.count = 0
+	}
+}
+
+func (b *slidingWindowCounter) allow(now time.Time, n int) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return true
+	}
+
+	b.roll(now)
+
+	if b.estimate(now)+float64(n) > float64(b.max) {
+		return false
+	}
+
+	b.curr += n
+
+	return true
+}
+
+// penalize counts n more requests in the current window, they also weigh on the next one.
+func (b *slidingWindowCounter) penalize(now time.Time, n int) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return
+	}
+
+	b.roll(now)
+	b.curr += n
+}
+
+// refund uncounts n requests of the current window.
+func (b *slidingWindowCounter) refund(now time.Time, n int) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return
+	}
+
+	b.roll(now)
+	b.curr = max(0, b.curr-n)
+}
+
+// delay returns time left until estimate drops low enough for one more request, either within the current window
+// as previous window's weight decreases, or in the next one, where current counter becomes the previous one.
+func (b *slidingWindowCounter) delay(now time.Time) (time.Duration, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return 0, true
+	}
+
+	if b.max <= 0 {
+		return 0, false
+	}
+
+	b.roll(now)
+
+	over := b.estimate(now) + 1 - float64(b.max)
+	if over <= 0 {
+		return 0, true
+	}
+
+	left := b.start.Add(b.window).Sub(now)
+	if b.prev > 0 {
+		if d := time.Duration(math.Ceil(over / float64(b.prev) * float64(b.window))); d < left {
+			return d, true
+		}
+	}
+
+	if b.curr < b.max {
+		return left, true
+	}
+
+	return left + time.Duration(math.Ceil((1-float64(b.max-1)/float64(b.curr))*float64(b.window))), true
+}
+
+func (b *slidingWindowCounter) tokens(now time.Time) float64 {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return math.Inf(1)
+	}
+
+	b.roll(now)
+
+	return float64(b.max) - b.estimate(now)
+}
+
+func (b *slidingWindowCounter) setLimit(limit rate.Limit, burst int) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.window, b.max, b.inf = window(limit, burst), burst, limit == rate.Inf
+}
+
+// roll starts a new window if now is past the current one, current counter becomes the previous one if windows are adjacent.
+func (b *slidingWindowCounter) roll(now time.Time) {
+	elapsed := now.Sub(b.start)
+	if elapsed < b.window {
+		return
+	}
+
+	b.prev = 0
+	if elapsed < 2*b.window {
+		b.prev = b.curr
+	}
+
+	b.curr = 0
+	b.start = now.Truncate(b.window)
+}
+
+// estimate returns number of requests in the window ending at now, counting previous window by the part of it the window covers.
+func (b *slidingWindowCounter) estimate(now time.Time) float64 {
+	weight := 1 - float64(now.Sub(b.start))/float64(b.window)
+
+	return float64(b.prev)*weight + float64(b.curr)
+}
+
+func (b *gcra) allow(now time.Time, n int) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return true
+	}
+
+	tat, wait, ok := b.next(now, n)
+	if !ok || wait > 0 {
+		return false
+	}
+
+	b.tat = tat
+
+	return true
+}
+
+// penalize moves theoretical arrival time by n intervals.
+func (b *gcra) penalize(now time.Time, n int) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return
+	}
+
+	if now.After(b.tat) {
+		b.tat = now
+	}
+
+	b.tat = b.tat.Add(b.interval * time.Duration(n))
+}
+
+// refund moves theoretical arrival time back by n intervals, but not before now, when bucket is full.
+func (b *gcra) refund(now time.Time, n int) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return
+	}
+
+	b.tat = b.tat.Add(-b.interval * time.Duration(n))
+	if b.tat.Before(now) {
+		b.tat = now
+	}
+}
+
+func (b *gcra) delay(now time.Time) (time.Duration, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return 0, true
+	}
+
+	_, wait, ok := b.next(now, 1)
+
+	return wait, ok
+}
+
+// tokens returns number of intervals left until theoretical arrival time reaches burst of them.
+func (b *gcra) tokens(now time.Time) float64 {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.inf {
+		return math.Inf(1)
+	}
+
+	if b.interval <= 0 {
+		return 0
+	}
+
+	tat := b.tat
+	if now.After(tat) {
+		tat = now
+	}
+
+	return float64(b.burst) - float64(tat.Sub(now))/float64(b.interval)
+}
+
+func (b *gcra) setLimit(limit rate.Limit, burst int) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.burst, b.inf, b.interval = burst, limit == rate.Inf, 0
+	if limit > 0 && !b.inf {
+		b.interval = time.Duration(float64(time.Second) / float64(limit))
+	}
+}
+
+// next returns theoretical arrival time after request of n tokens at now and how long request has to wait to conform to it.
+// Reports false if request will never conform, which is the case for zero limit or burst smaller than n.
+func (b *gcra) next(now time.Time, n int) (time.Time, time.Duration, bool) {
+	if b.interval <= 0 || b.burst < n || b.burst <= 0 {
+		return time.Time{}, 0, false
+	}
+
+	tat := b.tat
+	if now.After(tat) {
+		tat = now
+	}
+
+	tat = tat.Add(b.interval * time.Duration(n))
+	allowAt := tat.Add(-b.interval * time.Duration(b.burst))
+
+	return tat, max(0, allowAt.Sub(now)), true
+}
@@ -0,0 +1,341 @@
+package limiter
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestSlidingWindowBoundary(t *testing.T) {
+	start := time.Now()
+
+	tests := []struct {
+		name     string
+		algo     Algo
+		expected []bool
+	}{
+		{
+			name:     "token_bucket",
+			algo:     AlgoTokenBucket,
+			expected: []bool{true, true, true, false, true, false, false},
+		},
+		{
+			name:     "sliding_window",
+			algo:     AlgoSlidingWindow,
+			expected: []bool{true, true, false, false, true, false, true},
+		},
+	}
+
+	offsets := []time.Duration{
+		0,
+		100 * time.Millisecond,
+		600 * time.Millisecond,
+		900 * time.Millisecond,
+		time.Second,
+		1050 * time.Millisecond,
+		1100 * time.Millisecond,
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBucket(tt.algo, 2, 2)
+
+			got := make([]bool, 0, len(offsets))
+			for _, o := range offsets {
+				got = append(got, b.allow(start.Add(o), 1))
+			}
+
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestSlidingWindowDelay(t *testing.T) {
+	start := time.Now()
+	b := newBucket(AlgoSlidingWindow, 1, 2)
+
+	d, ok := b.delay(start)
+	assert.True(t, ok)
+	assert.Zero(t, d)
+
+	assert.True(t, b.allow(start, 1))
+	assert.True(t, b.allow(start.Add(500*time.Millisecond), 1))
+	assert.False(t, b.allow(start.Add(time.Second), 1))
+
+	d, ok = b.delay(start.Add(time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, d)
+
+	_, ok = newBucket(AlgoSlidingWindow, 1, 0).delay(start)
+	assert.False(t, ok)
+}
+
+func TestSlidingWindowLimits(t *testing.T) {
+	now := time.Now()
+
+	inf := newBucket(AlgoSlidingWindow, rate.Inf, 0)
+	for i := 0; i < 10; i++ {
+		assert.True(t, inf.allow(now, 1))
+	}
+
+	assert.False(t, newBucket(AlgoSlidingWindow, 10, 0).allow(now, 1))
+
+	never := newBucket(AlgoSlidingWindow, 0, 1)
+	assert.True(t, never.allow(now, 1))
+	assert.False(t, never.allow(now.Add(time.Hour), 1))
+}
+
+func TestAlgorithmOption(t *testing.T) {
+	l := New(Algorithm(AlgoSlidingWindow), RpsWithBurst(1, 1))
+	defer l.Stop()
+
+	assert.True(t, l.allow("1.1.1.1"))
+	assert.False(t, l.allow("1.1.1.1"))
+
+	_, ok := l.(*limiter).store.(*memoryStore).get("1.1.1.1").bucket.(*slidingWindow)
+	assert.True(t, ok)
+}
+
+func TestFixedWindow(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+	b := newBucket(AlgoFixedWindow, 2, 2)
+
+	fw, ok := b.(*fixedWindow)
+	assert.True(t, ok)
+	assert.Less(t, unsafe.Sizeof(*fw), unsafe.Sizeof(rate.Limiter{}))
+
+	assert.True(t, b.allow(start, 1))
+	assert.True(t, b.allow(start.Add(100*time.Millisecond), 1))
+	assert.False(t, b.allow(start.Add(900*time.Millisecond), 1))
+	assert.Equal(t, 2, fw.count)
+
+	d, ok := b.delay(start.Add(900 * time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, 100*time.Millisecond, d)
+
+	assert.True(t, b.allow(start.Add(time.Second), 1))
+	assert.Equal(t, 1, fw.count)
+	assert.Equal(t, start.Add(time.Second), fw.start)
+	assert.True(t, b.allow(start.Add(1999*time.Millisecond), 1))
+	assert.False(t, b.allow(start.Add(1999*time.Millisecond), 1))
+
+	assert.True(t, b.allow(start.Add(5*time.Second), 1))
+	assert.Equal(t, 1, fw.count)
+
+	_, ok = newBucket(AlgoFixedWindow, 1, 0).delay(start)
+	assert.False(t, ok)
+}
+
+func TestGCRA(t *testing.T) {
+	start := time.Now()
+
+	t.Run("steady_spacing", func(t *testing.T) {
+		b := newBucket(AlgoGCRA, 10, 1)
+
+		for i := 0; i < 5; i++ {
+			now := start.Add(time.Duration(i) * 100 * time.Millisecond)
+			assert.True(t, b.allow(now, 1))
+			assert.False(t, b.allow(now.Add(50*time.Millisecond), 1))
+		}
+	})
+
+	t.Run("burst_then_reject", func(t *testing.T) {
+		b := newBucket(AlgoGCRA, 10, 3)
+
+		for i := 0; i < 3; i++ {
+			assert.True(t, b.allow(start, 1))
+		}
+		assert.False(t, b.allow(start, 1))
+		assert.False(t, b.allow(start.Add(99*time.Millisecond), 1))
+
+		d, ok := b.delay(start)
+		assert.True(t, ok)
+		assert.Equal(t, 100*time.Millisecond, d)
+
+		assert.True(t, b.allow(start.Add(100*time.Millisecond), 1))
+		assert.False(t, b.allow(start.Add(100*time.Millisecond), 1))
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		assert.True(t, newBucket(AlgoGCRA, rate.Inf, 0).allow(start, 1))
+		assert.False(t, newBucket(AlgoGCRA, 10, 0).allow(start, 1))
+
+		_, ok := newBucket(AlgoGCRA, 0, 1).delay(start)
+		assert.False(t, ok)
+	})
+}
+
+func TestBucketAllowN(t *testing.T) {
+	now := time.Now()
+
+	for _, algo := range []Algo{AlgoTokenBucket, AlgoSlidingWindow, AlgoFixedWindow, AlgoGCRA} {
+		b := newBucket(algo, 1, 10)
+
+		assert.False(t, b.allow(now, 11), algo)
+		assert.True(t, b.allow(now, 5), algo)
+		assert.False(t, b.allow(now, 6), algo)
+		assert.True(t, b.allow(now, 5), algo)
+		assert.False(t, b.allow(now, 1), algo)
+	}
+}
+
+func TestSlidingWindowCounterBoundary(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+
+	tests := []struct {
+		name     string
+		algo     Algo
+		expected int
+	}{
+		{
+			name:     "fixed_window",
+			algo:     AlgoFixedWindow,
+			expected: 20,
+		},
+		{
+			name:     "sliding_window_counter",
+			algo:     AlgoSlidingWindowCounter,
+			expected: 11,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBucket(tt.algo, 10, 10)
+
+			allowed := 0
+			for _, o := range []time.Duration{900 * time.Millisecond, 1100 * time.Millisecond} {
+				for i := 0; i < 10; i++ {
+					if b.allow(start.Add(o), 1) {
+						allowed++
+					}
+				}
+			}
+
+			assert.Equal(t, tt.expected, allowed, "requests allowed within 200ms around window boundary")
+		})
+	}
+}
+
+func TestSlidingWindowCounterDelay(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+	b := newBucket(AlgoSlidingWindowCounter, 2, 2)
+
+	d, ok := b.delay(start)
+	assert.True(t, ok)
+	assert.Zero(t, d)
+
+	assert.True(t, b.allow(start, 1))
+	assert.True(t, b.allow(start.Add(500*time.Millisecond), 1))
+	assert.False(t, b.allow(start.Add(500*time.Millisecond), 1))
+
+	d, ok = b.delay(start.Add(500 * time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, 500*time.Millisecond+time.Second/2, d, "previous window has to lose half of its weight")
+
+	assert.False(t, b.allow(start.Add(time.Second), 1))
+	assert.True(t, b.allow(start.Add(1500*time.Millisecond), 1))
+	assert.False(t, b.allow(start.Add(1500*time.Millisecond), 1))
+
+	d, ok = b.delay(start.Add(1500 * time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, 500*time.Millisecond, d)
+
+	assert.True(t, b.allow(start.Add(5*time.Second), 1), "counters are dropped after idle windows")
+	assert.True(t, b.allow(start.Add(5*time.Second), 1))
+
+	_, ok = newBucket(AlgoSlidingWindowCounter, 1, 0).delay(start)
+	assert.False(t, ok)
+
+	inf := newBucket(AlgoSlidingWindowCounter, rate.Inf, 0)
+	assert.True(t, inf.allow(start, 1))
+}
+
+func TestPenalize(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+
+	tests := []struct {
+		algo      Algo
+		recovery  time.Duration
+		penalized time.Duration
+	}{
+		{algo: AlgoTokenBucket, recovery: time.Second, penalized: 3 * time.Second},
+		{algo: AlgoSlidingWindow, recovery: time.Second, penalized: 1500 * time.Millisecond},
+		{algo: AlgoFixedWindow, recovery: time.Second, penalized: time.Second},
+		{algo: AlgoSlidingWindowCounter, recovery: 2 * time.Second, penalized: 2 * time.Second},
+		{algo: AlgoGCRA, recovery: time.Second, penalized: 3 * time.Second},
+	}
+
+	// recovery returns how long after start b allows the next request, checking every 100ms after rejection at 500ms.
+	recovery := func(b bucket) time.Duration {
+		for d := 600 * time.Millisecond; d < time.Minute; d += 100 * time.Millisecond {
+			if b.allow(start.Add(d), 1) {
+				return d
+			}
+		}
+
+		return time.Minute
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.algo), func(t *testing.T) {
+			b := newBucket(tt.algo, 1, 1)
+			assert.True(t, b.allow(start, 1))
+			assert.Equal(t, tt.recovery, recovery(b))
+
+			b = newBucket(tt.algo, 1, 1)
+			assert.True(t, b.allow(start, 1))
+			b.penalize(start.Add(500*time.Millisecond), 2)
+			assert.Equal(t, tt.penalized, recovery(b))
+		})
+	}
+}
+
+func TestTokens(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+
+	for _, algo := range []Algo{AlgoTokenBucket, AlgoSlidingWindow, AlgoFixedWindow, AlgoSlidingWindowCounter, AlgoGCRA} {
+		t.Run(fmt.Sprint(algo), func(t *testing.T) {
+			b := newBucket(algo, 1, 4)
+			assert.InDelta(t, 4, b.tokens(start), 0.001)
+
+			assert.True(t, b.allow(start, 3))
+			assert.InDelta(t, 1, b.tokens(start), 0.001)
+
+			b.penalize(start, 2)
+			assert.InDelta(t, -1, b.tokens(start), 0.001)
+		})
+	}
+
+	assert.True(t, math.IsInf(newBucket(AlgoSlidingWindow, rate.Inf, 0).tokens(start), 1))
+}
+
+func TestRefund(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+
+	for _, algo := range []Algo{AlgoTokenBucket, AlgoSlidingWindow, AlgoFixedWindow, AlgoSlidingWindowCounter, AlgoGCRA} {
+		t.Run(fmt.Sprint(algo), func(t *testing.T) {
+			b := newBucket(algo, 1, 4)
+			assert.True(t, b.allow(start, 3))
+			assert.InDelta(t, 1, b.tokens(start), 0.001)
+
+			b.refund(start, 2)
+			assert.InDelta(t, 3, b.tokens(start), 0.001)
+
+			b.refund(start, 5)
+			assert.InDelta(t, 4, b.tokens(start), 0.001)
+			assert.True(t, b.allow(start, 4))
+			assert.False(t, b.allow(start, 1))
+		})
+	}
+
+	never := newBucket(AlgoTokenBucket, 0, 1)
+	assert.True(t, never.allow(start, 1))
+	never.refund(start, 1)
+	assert.True(t, never.allow(start, 1))
+}
@@ -0,0 +1,27 @@
+package limiter
+
+import "net/http"
+
+// RpsBurst is rps and burst of a key, see NewAPIKeyLimiter.
+type RpsBurst struct {
+	Rps   int
+	Burst int
+}
+
+// NewAPIKeyLimiter returns limiter keyed by value of header, for example X-API-Key. Keys found in overrides are limited by their values,
+// other keys by defaults. Requests without the header are limited by ip, pass RejectMissingKey in opts to reject them instead.
+// Opts are applied after key function and defaults, overrides can be changed later with Override and RemoveOverride.
+func NewAPIKeyLimiter(header string, defaults RpsBurst, overrides map[string]RpsBurst, opts ...option) Limiter {
+	l := New(append([]option{
+		RpsWithBurst(defaults.Rps, defaults.Burst),
+		KeyFunc(func(r *http.Request) string {
+			return r.Header.Get(header)
+		}),
+	}, opts...)...)
+
+	for k, o := range overrides {
+		l.Override(k, o.Rps, o.Burst)
+	}
+
+	return l
+}
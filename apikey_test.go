@@ -0,0 +1,66 @@
+package limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAPIKeyLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []option
+		key     string
+		allowed int
+	}{
+		{
+			name:    "default",
+			key:     "free",
+			allowed: 2,
+		},
+		{
+			name:    "overridden",
+			key:     "partner",
+			allowed: 5,
+		},
+		{
+			name:    "missing_key_limited_by_ip",
+			allowed: 2,
+		},
+		{
+			name:    "missing_key_rejected",
+			opts:    []option{RejectMissingKey()},
+			allowed: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewAPIKeyLimiter("X-API-Key", RpsBurst{Rps: 1, Burst: 2}, map[string]RpsBurst{
+				"partner": {Rps: 1, Burst: 5},
+			}, tt.opts...)
+			defer l.Stop()
+
+			h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			allowed := 0
+			for i := 0; i < 10; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				if tt.key != "" {
+					req.Header.Set("X-API-Key", tt.key)
+				}
+
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				if rec.Code == http.StatusOK {
+					allowed++
+				}
+			}
+
+			assert.Equal(t, tt.allowed, allowed)
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package limiter
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// cachedStore remembers locally until when visitors rejected by store are blocked, so their requests are rejected without asking it.
+type cachedStore struct {
+	store    Store
+	maxBlock time.Duration
+	clock    Clock
+	mu       sync.Mutex
+	blocked  map[string]time.Time
+}
+
+// NewCachedStore returns Store that keeps rejections of s in local memory, for example in front of redis store, so rejected visitors
+// dont cost a round trip to it until they are allowed again. Visitor is blocked locally until the time s reports with Delay, or for
+// one token interval of its limit if s cant tell, but never longer than maxBlock, after which s is asked again. Allowed requests always
+// go to s. Blocks are local, so requests rejected by another instance are still checked with s. Limiter clock is used for block times.
+func NewCachedStore(s Store, maxBlock time.Duration) Store {
+	return &cachedStore{
+		store:    s,
+		maxBlock: maxBlock,
+		clock:    realClock{},
+		blocked:  make(map[string]time.Time),
+	}
+}
+
+func (s *cachedStore) Allow(ip string, limit rate.Limit, burst int) (bool, error) {
+	return s.AllowN(ip, limit, burst, 1)
+}
+
+// AllowN rejects ip blocked locally, otherwise asks store and blocks ip if store rejects it.
+func (s *cachedStore) AllowN(ip string, limit rate.Limit, burst, n int) (bool, error) {
+	now := s.clock.Now()
+	if _, ok := s.blockedUntil(ip, now); ok {
+		return false, nil
+	}
+
+	var (
+		ok  bool
+		err error
+	)
+
+	if m, is := s.store.(multiAllower); is && n != 1 {
+		ok, err = m.AllowN(ip, limit, burst, n)
+	} else {
+		ok, err = s.store.Allow(ip, limit, burst)
+	}
+
+	if ok || err != nil {
+		return ok, err
+	}
+
+	block := s.maxBlock
+	if d, is := s.store.(delayer); is {
+		if delay, can := d.Delay(ip, limit, burst); can {
+			block = min(block, delay)
+		}
+	} else if limit > 0 {
+		block = min(block, time.Duration(float64(time.Second)/float64(limit)))
+	}
+
+	if block > 0 {
+		s.mu.Lock()
+		s.blocked[ip] = now.Add(block)
+		s.mu.Unlock()
+	}
+
+	return false, nil
+}
+
+// blockedUntil returns time ip is blocked until, reporting whether it is still blocked at now.
+func (s *cachedStore) blockedUntil(ip string, now time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.blocked[ip]
+	if ok && !now.Before(until) {
+		delete(s.blocked, ip)
+		return time.Time{}, false
+	}
+
+	return until, ok
+}
+
+// Delay returns time left of local block, or asks store if ip is not blocked locally.
+func (s *cachedStore) Delay(ip string, limit rate.Limit, burst int) (time.Duration, bool) {
+	now := s.clock.Now()
+	if until, ok := s.blockedUntil(ip, now); ok {
+		return until.Sub(now), true
+	}
+
+	if d, ok := s.store.(delayer); ok {
+		return d.Delay(ip, limit, burst)
+	}
+
+	return 0, false
+}
+
+// Tokens asks store how many tokens ip has left.
+func (s *cachedStore) Tokens(ip string) (float64, bool) {
+	if t, ok := s.store.(tokener); ok {
+		return t.Tokens(ip)
+	}
+
+	return 0, false
+}
+
+// Reset forgets local block of ip and resets it in store.
+func (s *cachedStore) Reset(ip string) {
+	s.mu.Lock()
+	delete(s.blocked, ip)
+	s.mu.Unlock()
+
+	if r, ok := s.store.(resetter); ok {
+		r.Reset(ip)
+	}
+}
+
+// ResetAll forgets every local block and resets store.
+func (s *cachedStore) ResetAll() {
+	s.mu.Lock()
+	clear(s.blocked)
+	s.mu.Unlock()
+
+	if r, ok := s.store.(resetter); ok {
+		r.ResetAll()
+	}
+}
+
+// Cleanup forgets expired local blocks and cleans up store.
+func (s *cachedStore) Cleanup() {
+	s.forgetExpired(s.clock.Now())
+	s.store.Cleanup()
+}
+
+// DeleteExpired forgets expired local blocks and deletes expired visitors of store, returning how many of them store removed.
+// Stores that cant tell are cleaned up and zero is returned.
+func (s *cachedStore) DeleteExpired(ttl time.Duration, now time.Time) int {
+	s.forgetExpired(now)
+
+	if e, ok := s.store.(expirer); ok {
+		return e.DeleteExpired(ttl, now)
+	}
+
+	s.store.Cleanup()
+
+	return 0
+}
+
+// forgetExpired forgets local blocks that ended by now.
+func (s *cachedStore) forgetExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ip, until := range s.blocked {
+		if !now.Before(until) {
+			delete(s.blocked, ip)
+		}
+	}
+}
+
+// Flush flushes store if it buffers state.
+func (s *cachedStore) Flush() error {
+	if f, ok := s.store.(flusher); ok {
+		return f.Flush()
+	}
+
+	return nil
+}
+
+// Close closes store if it is io.Closer.
+func (s *cachedStore) Close() error {
+	if c, ok := s.store.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+// delayStore is stubStore that reports delay of rejected visitors.
+type delayStore struct {
+	stubStore
+	delay time.Duration
+}
+
+func (s *delayStore) Delay(ip string, limit rate.Limit, burst int) (time.Duration, bool) {
+	return s.delay, true
+}
+
+func TestCachedStore(t *testing.T) {
+	tests := []struct {
+		name     string
+		store    func() (Store, map[string]int)
+		maxBlock time.Duration
+		blocked  time.Duration
+	}{
+		{
+			name: "store delay",
+			store: func() (Store, map[string]int) {
+				s := &delayStore{stubStore: stubStore{calls: map[string]int{}}, delay: 3 * time.Second}
+				return s, s.calls
+			},
+			maxBlock: time.Minute,
+			blocked:  3 * time.Second,
+		},
+		{
+			name: "capped by max block",
+			store: func() (Store, map[string]int) {
+				s := &delayStore{stubStore: stubStore{calls: map[string]int{}}, delay: time.Minute}
+				return s, s.calls
+			},
+			maxBlock: 2 * time.Second,
+			blocked:  2 * time.Second,
+		},
+		{
+			name: "token interval",
+			store: func() (Store, map[string]int) {
+				s := &stubStore{calls: map[string]int{}}
+				return s, s.calls
+			},
+			maxBlock: time.Minute,
+			blocked:  500 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner, calls := tt.store()
+			s := NewCachedStore(inner, tt.maxBlock).(*cachedStore)
+			c := &manualClock{now: time.Unix(0, 0)}
+			s.clock = c
+
+			for i := 0; i < 10; i++ {
+				ok, err := s.Allow("1.1.1.1", 2, 1)
+				assert.NoError(t, err)
+				assert.False(t, ok)
+			}
+
+			assert.Equal(t, 1, calls["1.1.1.1"], "blocked visitor is rejected locally")
+
+			d, ok := s.Delay("1.1.1.1", 2, 1)
+			assert.True(t, ok)
+			assert.Equal(t, tt.blocked, d)
+
+			c.now = c.now.Add(tt.blocked - time.Millisecond)
+			_, _ = s.Allow("1.1.1.1", 2, 1)
+			assert.Equal(t, 1, calls["1.1.1.1"])
+
+			c.now = c.now.Add(time.Millisecond)
+			_, _ = s.Allow("1.1.1.1", 2, 1)
+			assert.Equal(t, 2, calls["1.1.1.1"], "store is asked again after block expires")
+
+			_, _ = s.Allow("2.2.2.2", 2, 1)
+			assert.Equal(t, 1, calls["2.2.2.2"])
+		})
+	}
+}
+
+func TestCachedStoreAllowed(t *testing.T) {
+	inner := &stubStore{allowed: true, calls: map[string]int{}}
+	s := NewCachedStore(inner, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		ok, _ := s.Allow("1.1.1.1", 1, 1)
+		assert.True(t, ok)
+	}
+
+	assert.Equal(t, 3, inner.calls["1.1.1.1"])
+}
+
+func TestCachedStoreResetAndCleanup(t *testing.T) {
+	inner := &stubStore{calls: map[string]int{}}
+	s := NewCachedStore(inner, time.Minute).(*cachedStore)
+	c := &manualClock{now: time.Unix(0, 0)}
+	s.clock = c
+
+	_, _ = s.Allow("1.1.1.1", 1, 1)
+	_, _ = s.Allow("2.2.2.2", 1, 1)
+
+	s.Reset("1.1.1.1")
+	_, _ = s.Allow("1.1.1.1", 1, 1)
+	assert.Equal(t, 2, inner.calls["1.1.1.1"])
+
+	c.now = c.now.Add(time.Second)
+	s.Cleanup()
+	assert.Empty(t, s.blocked)
+}
+
+func TestCachedStoreDeleteExpired(t *testing.T) {
+	c := &manualClock{now: time.Unix(0, 0)}
+	ms := newMemoryStore(time.Minute, AlgoTokenBucket)
+	ms.clock = c
+
+	s := NewCachedStore(ms, time.Second).(*cachedStore)
+	s.clock = c
+
+	_, _ = s.Allow("1.1.1.1", 1, 1)
+	assert.Equal(t, 1, s.DeleteExpired(0, c.now.Add(time.Second)))
+	assert.Equal(t, 0, s.DeleteExpired(0, c.now.Add(time.Second)))
+
+	assert.Equal(t, 0, NewCachedStore(&stubStore{calls: map[string]int{}}, time.Second).(*cachedStore).DeleteExpired(0, c.now))
+}
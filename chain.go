@@ -0,0 +1,17 @@
+package limiter
+
+import "net/http"
+
+// Chain returns middleware that limits requests with every limiter of ls in order, like Limit of each of them wrapped around the
+// next one. Request rejected by a limiter is not checked by the following ones and is answered with its RejectStatus, Retry-After,
+// OnReject and logger, so rejection is attributed to that layer, for example by giving each limiter a logger with its name.
+// Tokens taken by limiters checked before the rejecting one are not given back. Gin and echo can pass several GinLimit or echolimit.Limit to Use instead.
+func Chain(ls ...Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		for i := len(ls) - 1; i >= 0; i-- {
+			next = Limit(ls[i])(next)
+		}
+
+		return next
+	}
+}
@@ -0,0 +1,56 @@
+package limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChain(t *testing.T) {
+	c := &manualClock{now: time.Unix(0, 0)}
+	var rejectedBy []string
+
+	global := New(RpsWithBurst(1, 3), WithClock(c), RejectStatus(http.StatusServiceUnavailable),
+		KeyFunc(func(*http.Request) string { return "global" }),
+		OnReject(func(string, *http.Request) { rejectedBy = append(rejectedBy, "global") }))
+	defer global.Stop()
+
+	perIP := New(RpsWithBurst(1, 2), WithClock(c),
+		OnReject(func(string, *http.Request) { rejectedBy = append(rejectedBy, "ip") }))
+	defer perIP.Stop()
+
+	h := Chain(global, perIP)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set(XOFF, ip)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	// per ip layer rejects the third request of an ip before the global layer runs out.
+	assert.Equal(t, http.StatusOK, serve("1.1.1.1"))
+	assert.Equal(t, http.StatusOK, serve("1.1.1.1"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("1.1.1.1"))
+	assert.Equal(t, []string{"ip"}, rejectedBy)
+
+	// global layer rejects even though per ip layer would allow, which is then not charged.
+	assert.Equal(t, http.StatusServiceUnavailable, serve("2.2.2.2"))
+	assert.Equal(t, []string{"ip", "global"}, rejectedBy)
+
+	_, ok := perIP.(*limiter).store.(tokener).Tokens("2.2.2.2")
+	assert.False(t, ok)
+}
+
+func TestChainEmpty(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Chain()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+}
@@ -0,0 +1,84 @@
+package limiter
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// classSep separates class from key it is charged for, it can not appear in header values or ips.
+const classSep = "\x00"
+
+// ClassifierFunc sets function returning class of request, for example "user" for requests with valid session and "" for anonymous ones.
+// Requests of a class are limited by its ClassLimits, every key has separate budget in every class. Requests of empty class, or class
+// without limits, use limiter defaults. Overrides and WithLimitFunc take precedence over class limits. Used by Limit, GinLimit and echolimit.Limit.
+func ClassifierFunc(f func(r *http.Request) string) option {
+	return func(opts *limiterOptions) {
+		opts.classifier = f
+	}
+}
+
+// ClassLimits sets rps and burst of request classes returned by ClassifierFunc.
+func ClassLimits(limits map[string]RpsBurst) option {
+	var errs []error
+
+	for class, l := range limits {
+		if l.Rps < 0 || l.Burst < 0 {
+			errs = append(errs, invalidOption("negative limits %v of class %q", l, class))
+		}
+	}
+
+	return func(opts *limiterOptions) {
+		if len(errs) > 0 {
+			opts.errs = append(opts.errs, errs...)
+			return
+		}
+
+		if opts.classLimits == nil {
+			opts.classLimits = make(map[string]rateLimit, len(limits))
+		}
+
+		for class, l := range limits {
+			opts.classLimits[class] = rateLimit{rate.Limit(l.Rps), l.Burst}
+		}
+	}
+}
+
+// requestKey refreshes WithLimitFunc result of key and returns key request r is charged to, which includes class of request,
+// or its region if it has no class.
+func (lim *limiter) requestKey(key string, r *http.Request) string {
+	lim.refreshLimit(key, r)
+
+	var class string
+	if lim.opts.classifier != nil {
+		class = lim.opts.classifier(r)
+	}
+
+	if class == "" {
+		class = lim.regionClass(r)
+	}
+
+	if class == "" {
+		return key
+	}
+
+	return class + classSep + key
+}
+
+// splitClass returns class and key of key returned by requestKey.
+func splitClass(key string) (string, string) {
+	class, k, ok := strings.Cut(key, classSep)
+	if !ok {
+		return "", key
+	}
+
+	return class, k
+}
+
+// classRate returns limit and burst of class, reports false if class has no limits.
+func (lim *limiter) classRate(class string) (rate.Limit, int, bool) {
+	l, ok := lim.opts.classLimits[class]
+
+	return l.limit, l.burst, ok
+}
@@ -0,0 +1,63 @@
+package limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifierFunc(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			l := New(RpsWithBurst(1, 1),
+				ClassifierFunc(func(r *http.Request) string {
+					if r.Header.Get("Authorization") != "" {
+						return "user"
+					}
+
+					return ""
+				}),
+				ClassLimits(map[string]RpsBurst{"user": {Rps: 1, Burst: 3}}),
+			)
+			defer l.Stop()
+
+			h := handler(l)
+			allowed := func(auth string) int {
+				n := 0
+				for i := 0; i < 5; i++ {
+					req := httptest.NewRequest(http.MethodGet, "/test", nil)
+					req.Header.Set(XOFF, "1.1.1.1")
+					if auth != "" {
+						req.Header.Set("Authorization", auth)
+					}
+
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, req)
+					if rec.Code == http.StatusOK {
+						n++
+					}
+				}
+
+				return n
+			}
+
+			assert.Equal(t, 1, allowed(""), "anonymous")
+			assert.Equal(t, 3, allowed("Bearer token"), "authenticated class has own budget and limit")
+
+			l.Reset("1.1.1.1")
+			assert.Equal(t, 3, allowed("Bearer token"), "reset forgets class budgets")
+		})
+	}
+}
+
+func TestClassLimitsInvalid(t *testing.T) {
+	_, err := NewWithError(ClassLimits(map[string]RpsBurst{"user": {Rps: -1}}))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
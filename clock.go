@@ -0,0 +1,47 @@
+package limiter
+
+import "time"
+
+type (
+	// Clock tells time to limiter and in-memory store, so tests can control it instead of sleeping. See limitertest.FakeClock.
+	Clock interface {
+		Now() time.Time
+		NewTicker(d time.Duration) Ticker
+	}
+
+	// Ticker delivers ticks on channel returned by C until it is stopped, like time.Ticker.
+	Ticker interface {
+		C() <-chan time.Time
+		Stop()
+	}
+
+	realClock struct{}
+
+	realTicker struct {
+		*time.Ticker
+	}
+)
+
+// WithClock sets clock used for visitor times and cleanup ticks, real time by default. Redis store keeps using real time.
+func WithClock(c Clock) option {
+	return func(opts *limiterOptions) {
+		if c == nil {
+			opts.errs = append(opts.errs, invalidOption("nil clock"))
+			return
+		}
+
+		opts.clock = c
+	}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -1,9 +1,18 @@
 package limiter
 
 import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/eldarthepro/limiter/internal/hook"
+	"github.com/gin-gonic/gin"
+
 	"golang.org/x/time/rate"
 )
 
@@ -13,47 +22,388 @@ const (
 	defaultTTL              = time.Minute * 5
 	defaultCleanupFrequency = time.Minute * 5
 	defaultPeriod           = time.Second
+	defaultShards           = 256
+	minShardVisitors        = 64
+	defaultWhitelistCache   = 1024
+	cleanupChunk            = 256
+	// growStep is fraction of size a store has to grow by after requesting cleanup before it requests another one.
+	growStep = 4
 )
 
 const (
 	localhost     = "::1"
-	notAllowedFmt = "ratelimited for ip: %s, FwdFor: %s, XOrigFwdFor: %s, clientIP: %s"
 	XFF           = "x-forwarded-for"
 	XOFF          = "x-original-forwarded-for"
 	tooManyReqMsg = "Too many requests"
+	retryAfter    = hook.RetryAfter
+	problemJSON   = hook.ProblemJSON
 )
 
+// FailPolicy decides what to do with a request when limit can not be checked, or its key is unknown.
+type FailPolicy int
+
+const (
+	// FailOpen lets request through.
+	FailOpen FailPolicy = iota
+	// FailClosed rejects request.
+	FailClosed
+	// SharedBucket limits all requests without key with one bucket, used only by WhenNoKey.
+	SharedBucket
+)
+
+// contextKey is type of context keys set by limiter, so they dont collide with keys of other packages.
+type contextKey string
+
+// ClientIPKey is request context key client ip resolved by middleware is stored under, see ClientIPFromContext.
+const ClientIPKey contextKey = "limiter.clientIP"
+
+// GinClientIPKey is gin context key client ip resolved by GinLimit is set under.
+const GinClientIPKey = "limiter.clientIP"
+
+// ErrInvalidOption is returned by NewWithError when option has invalid value.
+var ErrInvalidOption = errors.New("limiter: invalid option")
+
+// ErrBlocked is returned by Wait for keys blocked by BlockedIPs or BlockedPrefixes.
+var ErrBlocked = errors.New("limiter: key is blocked")
+
+// ErrRateLimited is wrapped by RateLimitError that GinLimit adds to gin context errors when DelegateErrors is set, and returned by Wait
+// for keys that will never be allowed.
+var ErrRateLimited = errors.New("limiter: rate limited")
+
 type (
 	Limiter interface {
+		Allow(key string) bool
+		Wait(ctx context.Context, key string) error
+		RetryAfter(key string) time.Duration
+		Close() error
 		Stop()
-		visitor(string) *rate.Limiter
+		StopContext(context.Context) error
+		StopAndDrain() error
+		Stats() Stats
+		Cleanup()
+		Range(f func(key string, lastSeen time.Time, tokens float64) bool)
+		Export() []VisitorState
+		Import(states []VisitorState)
+		Limit() (rate.Limit, int)
+		SetLimit(rps, burst int)
+		Override(key string, rps, burst int)
+		RemoveOverride(key string)
+		Reset(key string)
+		ResetAll()
+		allow(string) bool
+		allowN(key, path string, n int) bool
+		allowIdempotent(key, path string, n int, header func(string) string) (allowed, taken bool)
+		shed() (time.Duration, bool)
+		admit(key, charged, path string, n int, allowed, taken bool) (func(), bool, bool)
+		penalizesStatuses() bool
+		penalizeStatus(key, path string, status int)
+		refundCanceled(ctx context.Context, key, path string, n int)
+		globalStatus() int
+		cost(*http.Request) int
+		requestKey(key string, r *http.Request) string
+		retryAfter(key, path string) time.Duration
 		whiteListed(string) bool
-		ipHeader() string
+		skipped(path string) bool
+		requestWhitelisted(*http.Request) bool
+		bypassed(header func(string) string) bool
+		ginWhitelisted(*gin.Context) bool
+		blocked(string) bool
+		blockStatus() int
+		missingIPHeader(get func(string) string) bool
+		ipHeaderStatus() int
+		rejectStatus() int
+		dryRun() bool
+		rejectMessage() string
+		ipHeaderValue(func(string) string) string
+		clientIP(string, func() string) string
+		key(*http.Request, func() string) string
+		requestIP(*http.Request, func() string) string
+		ginKey(*gin.Context) string
+		route(method, path string) Limiter
+		webSocket(header func(string) string) (Limiter, bool)
+		rejected(string, *http.Request)
+		decided(key, path string, r *http.Request, allowed bool)
+		setRateLimitHeaders(h http.Header, key, path string, r *http.Request, allowed bool) bool
+		setRateLimitTrailer(h http.Header, key, path string)
+		ginRejected(string, *gin.Context)
+		reject(http.ResponseWriter, *http.Request, time.Duration)
+		ginReject(*gin.Context, time.Duration)
+		hint(time.Duration) *backoffHint
+		problem(time.Duration) *problemDetails
+		rejectionHandler() http.Handler
+		drain() error
+		halt()
+	}
+
+	// Store counts requests for every visitor. Allow reports whether one more request identified by ip fits into limit and burst.
+	// Cleanup is called periodically to remove expired entries, stores that expire them natively can leave it empty.
+	Store interface {
+		Allow(ip string, limit rate.Limit, burst int) (bool, error)
+		Cleanup()
+	}
+
+	// multiAllower is implemented by stores that can take several tokens of one request at once.
+	multiAllower interface {
+		AllowN(ip string, limit rate.Limit, burst, n int) (bool, error)
+	}
+
+	// penalizer is implemented by stores that can take tokens from visitor even if it has none left, see PenalizeRejections.
+	penalizer interface {
+		Penalize(ip string, limit rate.Limit, burst, n int)
+	}
+
+	// refunder is implemented by stores that can give back tokens taken by requests that didnt count, see RefundOnClientCancel.
+	refunder interface {
+		Refund(ip string, limit rate.Limit, burst, n int)
+	}
+
+	// delayer is implemented by stores that know when rejected visitor will be allowed again.
+	delayer interface {
+		Delay(ip string, limit rate.Limit, burst int) (time.Duration, bool)
+	}
+
+	// Stats describes limiter state. Visitors and OldestLastSeen are reported only by stores that track visitors, such as default in-memory one.
+	Stats struct {
+		Visitors       int
+		OldestLastSeen time.Time
+		Allowed        uint64
+		Rejected       uint64
+	}
+
+	// statser is implemented by stores that can describe visitors they track.
+	statser interface {
+		Stats() Stats
+	}
+
+	// tokener is implemented by stores that can tell how many tokens visitor has left.
+	tokener interface {
+		Tokens(ip string) (float64, bool)
+	}
+
+	// ranger is implemented by stores that can list visitors they track.
+	ranger interface {
+		Range(f func(key string, lastSeen time.Time, tokens float64) bool)
+	}
+
+	// importer is implemented by stores that can restore visitors, see Import.
+	importer interface {
+		Import(ip string, lastSeen time.Time, tokens float64, limit rate.Limit, burst int)
+	}
+
+	// reconfigurer is implemented by stores that keep limit of every visitor and can change it in place.
+	reconfigurer interface {
+		SetLimit(limit rate.Limit, burst int)
+	}
+
+	// resetter is implemented by stores that can forget visitors, so their next request starts with full burst.
+	resetter interface {
+		Reset(ip string)
+		ResetAll()
+	}
+
+	// flusher is implemented by stores that buffer state and have to write it out before limiter is stopped.
+	flusher interface {
+		Flush() error
+	}
+
+	// expirer is implemented by stores that remove visitors not seen for longer than ttl at now and can tell how many they removed,
+	// limiter cleanup calls it instead of Cleanup. Stores that expire visitors natively, such as redis with EXPIRE, can return zero.
+	expirer interface {
+		DeleteExpired(ttl time.Duration, now time.Time) int
+	}
+
+	// sizer is implemented by stores that know how many visitors they track.
+	sizer interface {
+		Len() int
 	}
 
 	limiter struct {
+		store     Store
+		opts      *limiterOptions
+		stop      chan struct{}
+		stopOnce  sync.Once
+		closeOnce sync.Once
+		done      chan struct{}
+		grown     chan struct{}
+		// registry cleans limiter created with NewWithRegistry, instead of its own routine.
+		registry      *Registry
+		limit         rate.Limit
+		burst         int
+		overrides     map[string]rateLimit
+		global        *rate.Limiter
+		metrics       *metrics
+		allowedTotal  atomic.Uint64
+		rejectedTotal atomic.Uint64
+		// whitelisted caches ips matched by whitelisted prefixes or networks, up to whitelistCache of them.
+		whitelisted     sync.Map
+		whitelistedSize atomic.Int64
+		// inflight counts requests of every key being served, for MaxConcurrent.
+		inflight   map[string]int
+		inflightMu sync.Mutex
+		// regions caches regions of ips returned by RegionFunc, up to regionCacheSize of them.
+		regions     sync.Map
+		regionsSize atomic.Int64
+		// dynamic caches dynamicLimit of keys returned by LimitFunc.
+		dynamic sync.Map
+		// idempotency holds idempotencyKeys of every key, for IdempotencyKey.
+		idempotency sync.Map
+		sync.RWMutex
+	}
+
+	memoryStore struct {
+		shards []*shard
+		ttl    time.Duration
+		algo   Algo
+		clock  Clock
+		// refreshAllowed makes only allowed requests update lastSeen of known visitors.
+		refreshAllowed bool
+		// warmUp is time new visitors take to get full burst, see WarmUp.
+		warmUp time.Duration
+		// tiers are limits enforced for every visitor in addition to its own.
+		tiers []rateLimit
+		// shardMax is max number of visitors in shard, zero means unlimited.
+		shardMax int
+		// size approximates number of visitors, onGrow is called when a visitor added makes it larger than nextGrow. nextGrow starts
+		// at growLimit and moves a growStep fraction above size on every call, so stores full of fresh visitors dont ask for cleanup
+		// on every new one. Cleanup lowers it back.
+		size      atomic.Int64
+		growLimit int64
+		nextGrow  atomic.Int64
+		onGrow    func()
+		// pool keeps records removed by cleanup for new visitors, nil disables reuse. Requests read records only under shard lock,
+		// so a removed record is referenced by nobody.
+		pool *sync.Pool
+	}
+
+	// shard holds part of memoryStore visitors, so requests from unrelated ips dont contend for the same lock.
+	shard struct {
 		storage map[string]*record
-		opts    *limiterOptions
-		stop    chan struct{}
-		limit   rate.Limit
+		// keys lists keys of storage, so cleanup can go through them in chunks. Record's idx is index of its key.
+		keys []string
 		sync.RWMutex
 	}
 
+	// record is visitor entry of memoryStore. lastSeen holds unix nanoseconds and is updated atomically, so known visitors need only read lock.
 	record struct {
-		lastSeen time.Time
-		limiter  *rate.Limiter
+		lastSeen atomic.Int64
+		bucket   bucket
+		limit    rate.Limit
+		burst    int
+		idx      int
+	}
+
+	// RateLimitError describes rejected request for error handling middleware, it matches ErrRateLimited with errors.Is.
+	RateLimitError struct {
+		IP         string
+		Status     int
+		RetryAfter time.Duration
+	}
+
+	// backoffHint is JSON body of rejection response set by WithBackoffHint.
+	backoffHint struct {
+		Error        string `json:"error"`
+		RetryAfterMs int64  `json:"retry_after_ms"`
+	}
+
+	// problemDetails is RFC 7807 body of rejection response set by WithProblemDetails, RetryAfter is in seconds like Retry-After header.
+	problemDetails struct {
+		Type       string `json:"type"`
+		Title      string `json:"title"`
+		Status     int    `json:"status"`
+		Detail     string `json:"detail,omitempty"`
+		RetryAfter int    `json:"retryAfter"`
+	}
+
+	rateLimit struct {
+		limit rate.Limit
+		burst int
 	}
 
 	limiterOptions struct {
-		ttl           time.Duration
-		customPeriod  bool
-		period        time.Duration
-		burst         int
-		requests      int
-		cleanupFreq   time.Duration
-		ipHeader      string
-		allowedPrefix []string
-		allowedIPs    map[string]struct{}
+		ttl                 time.Duration
+		customPeriod        bool
+		period              time.Duration
+		burst               int
+		requests            int
+		refill              *rate.Limit
+		cleanupFreq         time.Duration
+		ipHeaders           []string
+		allowedPrefix       []string
+		strictPrefix        bool
+		allowedIPs          map[string]struct{}
+		allowedTenants      map[string]struct{}
+		tenantHeader        string
+		allowedNets         []*net.IPNet
+		whitelistFunc       func(*http.Request) bool
+		skipPaths           map[string]struct{}
+		skipPrefixes        []string
+		bypassHeaders       [][2]string
+		ginWhitelistFunc    func(*gin.Context) bool
+		trustedProxies      []*net.IPNet
+		ipStrategy          IPStrategy
+		ipStrategySet       bool
+		blockedIPs          map[string]struct{}
+		blockedPrefix       []string
+		blockStatus         int
+		requireIPHeader     bool
+		ipHeaderStatus      int
+		rejectStatus        int
+		rejectMessage       string
+		backoffHint         bool
+		backoffJitter       time.Duration
+		problemDetails      bool
+		rateLimitHeaders    bool
+		rateLimitTrailer    bool
+		store               Store
+		keyFunc             func(*http.Request) string
+		rejectMissingKey    bool
+		costFunc            func(*http.Request) int
+		penalty             int
+		statusPenalty       int
+		penalizedStatuses   []int
+		refundCanceled      bool
+		idempotencyHeader   string
+		idempotencyTTL      time.Duration
+		limitFunc           func(string, *http.Request) (int, int, bool)
+		classifier          func(*http.Request) string
+		classLimits         map[string]rateLimit
+		tenantLimits        map[string]rateLimit
+		regionFunc          func(string) string
+		pathScope           bool
+		subnet              bool
+		hashKeys            bool
+		hashSalt            []byte
+		subnetV4            int
+		subnetV6            int
+		noKey               FailPolicy
+		maxVisitors         int
+		refreshAllowed      bool
+		warmUp              time.Duration
+		cleanupSize         int
+		noAutoCleanup       bool
+		clock               Clock
+		whitelistCache      int
+		tiers               []TierConfig
+		global              *rateLimit
+		maxConcurrent       int
+		globalStatus        int
+		dryRun              bool
+		delegateErrors      bool
+		ginKeyFunc          func(*gin.Context) string
+		routes              map[string]Limiter
+		methods             map[string]Limiter
+		webSocket           Limiter
+		webSocketSet        bool
+		algo                Algo
+		onReject            func(string, *http.Request)
+		onDecision          func(*http.Request, bool, float64)
+		logger              *slog.Logger
+		ginLogger           *slog.Logger
+		ginOnReject         func(string, *gin.Context)
+		rejectionHandler    http.Handler
+		ginRejectionHandler gin.HandlerFunc
+		observer            Observer
+		errs                []error
 	}
 
 	option func(*limiterOptions)
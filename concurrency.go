@@ -0,0 +1,64 @@
+package limiter
+
+// MaxConcurrent caps number of requests of one key served at the same time, in addition to rate, for example for HTTP/2 clients
+// that send many requests over one connection. Requests over it are rejected like requests over rate, with OnReject, RejectionHandler
+// and the rest, and get tokens they took back if store can refund them. Applied by http, gin, echo and fiber middlewares, zero means no cap.
+func MaxConcurrent(n int) option {
+	var errs []error
+
+	if n < 0 {
+		errs = append(errs, invalidOption("negative max concurrent %d", n))
+		n = 0
+	}
+
+	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.maxConcurrent = n
+	}
+}
+
+// admit applies MaxConcurrent to request of key charged to charged key for path with n tokens, once rate decided whether it is
+// allowed and taken. Request over cap gets tokens back and is reported as neither allowed nor taken. Returned release must be
+// called once request is served.
+func (lim *limiter) admit(key, charged, path string, n int, allowed, taken bool) (func(), bool, bool) {
+	if !allowed {
+		return func() {}, false, false
+	}
+
+	release, ok := lim.acquire(key)
+	if ok {
+		return release, true, taken
+	}
+
+	if taken {
+		lim.refund(charged, path, n)
+	}
+
+	return func() {}, false, false
+}
+
+// acquire counts request of key as in flight, reporting false if key already has MaxConcurrent of them. Returned release must be
+// called once request is served.
+func (lim *limiter) acquire(key string) (func(), bool) {
+	if lim.opts.maxConcurrent == 0 {
+		return func() {}, true
+	}
+
+	lim.inflightMu.Lock()
+	defer lim.inflightMu.Unlock()
+
+	if lim.inflight[key] >= lim.opts.maxConcurrent {
+		return nil, false
+	}
+
+	lim.inflight[key]++
+
+	return func() {
+		lim.inflightMu.Lock()
+		defer lim.inflightMu.Unlock()
+
+		if lim.inflight[key]--; lim.inflight[key] <= 0 {
+			delete(lim.inflight, key)
+		}
+	}, true
+}
@@ -0,0 +1,127 @@
+package limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxConcurrent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for name := range middlewares(nil) {
+		t.Run(name, func(t *testing.T) {
+			l := New(RpsWithBurst(100, 100), MaxConcurrent(2))
+			defer l.Stop()
+
+			entered, unblock := make(chan struct{}), make(chan struct{})
+			h := middlewares(func(w http.ResponseWriter, r *http.Request) {
+				entered <- struct{}{}
+				<-unblock
+			})[name](l)
+
+			do := func(ip string) int {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set(XOFF, ip)
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				return rec.Code
+			}
+
+			var wg sync.WaitGroup
+			codes := make(chan int, 3)
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func(ip string) {
+					defer wg.Done()
+					codes <- do(ip)
+				}(map[int]string{0: "1.1.1.1", 1: "1.1.1.1", 2: "2.2.2.2"}[i])
+			}
+
+			for i := 0; i < 3; i++ {
+				<-entered
+			}
+
+			// 1.1.1.1 has two requests in flight, the third one is rejected right away, other ips are not affected.
+			for i := 0; i < 5; i++ {
+				assert.Equal(t, http.StatusTooManyRequests, do("1.1.1.1"))
+			}
+
+			close(unblock)
+			wg.Wait()
+			close(codes)
+
+			for code := range codes {
+				assert.Equal(t, http.StatusOK, code)
+			}
+
+			go func() { <-entered }()
+			assert.Equal(t, http.StatusOK, do("1.1.1.1"), "released requests free their slots")
+			assert.Empty(t, l.(*limiter).inflight)
+		})
+	}
+}
+
+func TestMaxConcurrentRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for name := range middlewares(nil) {
+		t.Run(name, func(t *testing.T) {
+			var rejected []string
+			var decisions []bool
+			l := New(RpsWithBurst(1, 2), MaxConcurrent(1), WithClock(&manualClock{now: time.Unix(0, 0)}),
+				OnReject(func(key string, r *http.Request) { rejected = append(rejected, key) }),
+				OnDecision(func(r *http.Request, allowed bool, remaining float64) { decisions = append(decisions, allowed) }),
+				RejectionHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					http.Error(w, "busy", http.StatusServiceUnavailable)
+				})))
+			defer l.Stop()
+
+			entered, unblock := make(chan struct{}), make(chan struct{})
+			h := middlewares(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/slow" {
+					entered <- struct{}{}
+					<-unblock
+				}
+			})[name](l)
+
+			do := func(path string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				return rec
+			}
+
+			done := make(chan int)
+			go func() { done <- do("/slow").Code }()
+			<-entered
+
+			rec := do("/")
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			assert.Equal(t, "busy\n", rec.Body.String())
+			assert.Equal(t, []string{"1.1.1.1"}, rejected)
+			assert.Equal(t, []bool{true, false}, decisions)
+
+			close(unblock)
+			assert.Equal(t, http.StatusOK, <-done)
+
+			// rejected request got its token back, so the last one is still there.
+			assert.Equal(t, http.StatusOK, do("/").Code)
+			assert.Equal(t, http.StatusServiceUnavailable, do("/").Code)
+			assert.Equal(t, []bool{true, false, true, false}, decisions)
+		})
+	}
+}
+
+func TestMaxConcurrentInvalid(t *testing.T) {
+	_, err := NewWithError(MaxConcurrent(-1))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
@@ -0,0 +1,189 @@
+package limiter
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Config describes limiter declaratively, see NewFromConfig. Zero values mean defaults of New.
+	Config struct {
+		// Rps is allowed number of requests per second, or per Period when it is set.
+		Rps int
+		// Burst is allowed burst, Rps when zero.
+		Burst int
+		// Period replaces second as the time Rps requests are allowed in.
+		Period time.Duration
+		// RecordTTL is how long visitor is kept after its last request.
+		RecordTTL time.Duration
+		// CleanupFrequency is how often storage is cleaned up.
+		CleanupFrequency time.Duration
+		// IPHeader is header client ip is looked up in.
+		IPHeader string
+		// Routes are limits for paths, matched like LimitPath patterns.
+		Routes []RouteConfig
+	}
+
+	// RouteConfig is limit for requests to Path. Path is matched exactly, or as a prefix when it ends with "/", like in LimitPath.
+	// Burst is Rps when zero, Period replaces second as the time Rps requests are allowed in.
+	RouteConfig struct {
+		Path   string
+		Rps    int
+		Burst  int
+		Period time.Duration
+	}
+)
+
+// NewFromConfig returns limiter built from cfg, with one LimitPath limiter for every route that shares RecordTTL, CleanupFrequency and IPHeader of cfg.
+// Every problem is returned joined in error wrapping ErrInvalidOption: negative values, paths that are empty or do not start with "/",
+// and paths given more than once. Nested prefixes are not an error, the longest one matches.
+func NewFromConfig(cfg Config) (Limiter, error) {
+	var errs []error
+
+	common := cfg.options()
+
+	seen := make(map[string]bool, len(cfg.Routes))
+	for _, r := range cfg.Routes {
+		switch {
+		case !strings.HasPrefix(r.Path, "/"):
+			errs = append(errs, invalidOption("route path %q does not start with /", r.Path))
+		case seen[r.Path]:
+			errs = append(errs, invalidOption("route path %q is set more than once", r.Path))
+		}
+
+		seen[r.Path] = true
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	routes := make([]Limiter, 0, len(cfg.Routes))
+	stop := func() {
+		for _, l := range routes {
+			l.Stop()
+		}
+	}
+
+	opts := append(rateOptions(cfg.Rps, cfg.Burst, cfg.Period), common...)
+	for _, r := range cfg.Routes {
+		l, err := NewWithError(append(rateOptions(r.Rps, r.Burst, r.Period), common...)...)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+
+		routes = append(routes, l)
+		opts = append(opts, LimitPath(r.Path, l))
+	}
+
+	l, err := NewWithError(opts...)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// options returns options shared by default limiter and route limiters.
+func (cfg Config) options() []option {
+	var opts []option
+
+	if cfg.RecordTTL != 0 {
+		opts = append(opts, RecordTTL(cfg.RecordTTL))
+	}
+
+	if cfg.CleanupFrequency != 0 {
+		opts = append(opts, CleanupFrequency(cfg.CleanupFrequency))
+	}
+
+	if cfg.IPHeader != "" {
+		opts = append(opts, IPHeader(cfg.IPHeader))
+	}
+
+	return opts
+}
+
+// rateOptions returns options for rps, burst and period, leaving defaults for zero values. Burst is rps when it is zero.
+func rateOptions(rps, burst int, period time.Duration) []option {
+	if rps == 0 && burst == 0 && period == 0 {
+		return nil
+	}
+
+	if rps == 0 {
+		rps = defaultRps
+	}
+
+	if burst == 0 {
+		burst = rps
+	}
+
+	if period != 0 {
+		return []option{Period(rps, period), Burst(burst)}
+	}
+
+	return []option{RpsWithBurst(rps, burst)}
+}
+
+// NewFromEnv returns limiter configured by environment variables prefix_RPS, prefix_BURST, prefix_PERIOD, prefix_TTL, prefix_CLEANUP
+// and prefix_IP_HEADER, for example LIMITER_RPS with prefix LIMITER. Variables are named without prefix if it is empty.
+// Durations are in time.ParseDuration format. Missing variables keep defaults, the rest is applied like fields of Config.
+// Every malformed variable is returned joined in error wrapping ErrInvalidOption.
+func NewFromEnv(prefix string) (Limiter, error) {
+	var errs []error
+
+	name := func(v string) string {
+		if prefix == "" {
+			return v
+		}
+
+		return prefix + "_" + v
+	}
+
+	intVar := func(v string) int {
+		s, ok := os.LookupEnv(name(v))
+		if !ok {
+			return 0
+		}
+
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			errs = append(errs, invalidOption("%s: %v", name(v), err))
+		}
+
+		return n
+	}
+
+	durationVar := func(v string) time.Duration {
+		s, ok := os.LookupEnv(name(v))
+		if !ok {
+			return 0
+		}
+
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			errs = append(errs, invalidOption("%s: %v", name(v), err))
+		}
+
+		return d
+	}
+
+	cfg := Config{
+		Rps:              intVar("RPS"),
+		Burst:            intVar("BURST"),
+		Period:           durationVar("PERIOD"),
+		RecordTTL:        durationVar("TTL"),
+		CleanupFrequency: durationVar("CLEANUP"),
+		IPHeader:         os.Getenv(name("IP_HEADER")),
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return NewFromConfig(cfg)
+}
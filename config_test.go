@@ -0,0 +1,163 @@
+package limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFromConfig(t *testing.T) {
+	l, err := NewFromConfig(Config{
+		Rps:   1,
+		Burst: 3,
+		Routes: []RouteConfig{
+			{Path: "/login", Rps: 1, Burst: 1, Period: time.Minute},
+			{Path: "/api/", Rps: 1, Burst: 2},
+		},
+	})
+	assert.NoError(t, err)
+	defer l.Stop()
+
+	handler := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name    string
+		path    string
+		allowed int
+	}{
+		{
+			name:    "exact",
+			path:    "/login",
+			allowed: 1,
+		},
+		{
+			name:    "prefix",
+			path:    "/api/users",
+			allowed: 2,
+		},
+		{
+			name:    "default",
+			path:    "/other",
+			allowed: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < tt.allowed; i++ {
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+				assert.Equal(t, http.StatusOK, rr.Code)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		})
+	}
+}
+
+func TestNewFromConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{
+			name: "empty path",
+			cfg:  Config{Routes: []RouteConfig{{Rps: 1}}},
+		},
+		{
+			name: "relative path",
+			cfg:  Config{Routes: []RouteConfig{{Path: "api/", Rps: 1}}},
+		},
+		{
+			name: "duplicate path",
+			cfg:  Config{Routes: []RouteConfig{{Path: "/a", Rps: 1}, {Path: "/a", Rps: 2}}},
+		},
+		{
+			name: "negative route rps",
+			cfg:  Config{Routes: []RouteConfig{{Path: "/a", Rps: -1}}},
+		},
+		{
+			name: "negative rps",
+			cfg:  Config{Rps: -1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := NewFromConfig(tt.cfg)
+			assert.ErrorIs(t, err, ErrInvalidOption)
+			assert.Nil(t, l)
+		})
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	t.Setenv("LIMITER_RPS", "5")
+	t.Setenv("LIMITER_BURST", "7")
+	t.Setenv("LIMITER_PERIOD", "1m")
+	t.Setenv("LIMITER_TTL", "10m")
+	t.Setenv("LIMITER_CLEANUP", "30s")
+	t.Setenv("LIMITER_IP_HEADER", "X-Real-IP")
+
+	l, err := NewFromEnv("LIMITER")
+	assert.NoError(t, err)
+	defer l.Stop()
+
+	opts := l.(*limiter).opts
+	assert.Equal(t, 5, opts.requests)
+	assert.Equal(t, 7, opts.burst)
+	assert.Equal(t, time.Minute, opts.period)
+	assert.Equal(t, 10*time.Minute, opts.ttl)
+	assert.Equal(t, 30*time.Second, opts.cleanupFreq)
+	assert.Equal(t, []string{"X-Real-IP"}, opts.ipHeaders)
+}
+
+func TestNewFromEnvDefaults(t *testing.T) {
+	t.Setenv("APP_RPS", "3")
+
+	l, err := NewFromEnv("APP")
+	assert.NoError(t, err)
+	defer l.Stop()
+
+	opts := l.(*limiter).opts
+	assert.Equal(t, 3, opts.requests)
+	assert.Equal(t, 3, opts.burst, "burst is rps when not set")
+	assert.Equal(t, defaultPeriod, opts.period)
+	assert.Equal(t, defaultTTL, opts.ttl)
+	assert.Equal(t, defaultCleanupFrequency, opts.cleanupFreq)
+	assert.Equal(t, []string{XOFF}, opts.ipHeaders)
+}
+
+func TestNewFromEnvInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		malformed bool
+	}{
+		{name: "LIMITER_RPS", value: "ten", malformed: true},
+		{name: "LIMITER_BURST", value: "-1"},
+		{name: "LIMITER_PERIOD", value: "60", malformed: true},
+		{name: "LIMITER_TTL", value: "5 minutes", malformed: true},
+		{name: "LIMITER_CLEANUP", value: "-1s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.name, tt.value)
+
+			l, err := NewFromEnv("LIMITER")
+			assert.ErrorIs(t, err, ErrInvalidOption)
+			if tt.malformed {
+				assert.ErrorContains(t, err, tt.name)
+			}
+			assert.Nil(t, l)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package limiter
+
+import (
+	"math"
+	"net/http"
+)
+
+// OnDecision sets callback called with every request checked against limit, whether it was allowed and how many tokens its key has
+// left after it, for example to annotate tracing span of request context. Remaining tokens are negative for penalized keys, infinite
+// for unlimited ones and NaN if store cant tell, such as redis one. Used by Limit, GinLimit and echolimit.Limit, requests rejected by
+// GlobalLimit are reported as allowed, since their key had tokens.
+func OnDecision(f func(r *http.Request, allowed bool, remaining float64)) option {
+	return func(opts *limiterOptions) {
+		opts.onDecision = f
+	}
+}
+
+// decided calls OnDecision callback with tokens left to key that requested path.
+func (lim *limiter) decided(key, path string, r *http.Request, allowed bool) {
+	if lim.opts.onDecision == nil {
+		return
+	}
+
+	lim.opts.onDecision(r, allowed, lim.remaining(key, path))
+}
+
+// remaining returns tokens key requesting path has left, NaN if store cant tell. Keys that are not tracked have full burst.
+func (lim *limiter) remaining(key, path string) float64 {
+	t, ok := lim.store.(tokener)
+	if !ok {
+		return math.NaN()
+	}
+
+	if tokens, ok := t.Tokens(lim.storeKey(key, path)); ok {
+		return tokens
+	}
+
+	_, burst := lim.rateFor(key)
+
+	return float64(burst)
+}
@@ -0,0 +1,62 @@
+package limiter
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnDecision(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	type decision struct {
+		path      string
+		allowed   bool
+		remaining float64
+	}
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			var got []decision
+			l := New(RpsWithBurst(1, 2), WithClock(&manualClock{now: time.Unix(0, 0)}),
+				OnDecision(func(r *http.Request, allowed bool, remaining float64) {
+					got = append(got, decision{r.URL.Path, allowed, remaining})
+				}))
+			defer l.Stop()
+
+			h := handler(l)
+			for i := 0; i < 3; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/api", nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				h.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			assert.Equal(t, []decision{
+				{"/api", true, 1},
+				{"/api", true, 0},
+				{"/api", false, 0},
+			}, got)
+		})
+	}
+}
+
+func TestOnDecisionUnknownTokens(t *testing.T) {
+	var remaining float64
+	l := New(WithStore(&stubStore{allowed: true, calls: map[string]int{}}), OnDecision(func(_ *http.Request, _ bool, left float64) {
+		remaining = left
+	}))
+	defer l.Stop()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(XOFF, "1.1.1.1")
+	Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.True(t, math.IsNaN(remaining))
+}
@@ -0,0 +1,83 @@
+package limiter
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// dynamicLimit is cached result of LimitFunc for a key, ok is false when function left key with defaults.
+type dynamicLimit struct {
+	rateLimit
+	ok      bool
+	expires time.Time
+}
+
+// WithLimitFunc sets function deciding rps and burst of key at request time, for example from tenant's plan stored in database.
+// When it reports ok, key is limited by returned values instead of the ones limiter was created with, negative values are replaced with defaults.
+// Result is cached per key for RecordTTL, so function is not called on every request. Overrides take precedence over it.
+// Used by Limit, GinLimit and echolimit.Limit, Allow and other middlewares use cached result if there is one.
+func WithLimitFunc(f func(key string, r *http.Request) (rps, burst int, ok bool)) option {
+	return func(opts *limiterOptions) {
+		opts.limitFunc = f
+	}
+}
+
+// refreshLimit calls LimitFunc for key of request r unless cached result is still fresh.
+func (lim *limiter) refreshLimit(key string, r *http.Request) {
+	if lim.opts.limitFunc == nil {
+		return
+	}
+
+	now := lim.opts.clock.Now()
+	if v, ok := lim.dynamic.Load(key); ok && now.Before(v.(dynamicLimit).expires) {
+		return
+	}
+
+	rps, burst, ok := lim.opts.limitFunc(key, r)
+
+	d := dynamicLimit{ok: ok, expires: now.Add(lim.opts.ttl)}
+	if ok {
+		limit, b := lim.rate()
+		d.limit, d.burst = rate.Limit(rps), burst
+		if rps < 0 {
+			d.limit = limit
+		}
+		if burst < 0 {
+			d.burst = b
+		}
+	}
+
+	lim.dynamic.Store(key, d)
+}
+
+// dynamicRate returns limit and burst LimitFunc set for key, reports false if there are none.
+func (lim *limiter) dynamicRate(key string) (rate.Limit, int, bool) {
+	if lim.opts.limitFunc == nil {
+		return 0, 0, false
+	}
+
+	v, ok := lim.dynamic.Load(key)
+	if !ok || !v.(dynamicLimit).ok {
+		return 0, 0, false
+	}
+
+	return v.(dynamicLimit).limit, v.(dynamicLimit).burst, true
+}
+
+// cleanupDynamic forgets expired LimitFunc results.
+func (lim *limiter) cleanupDynamic() {
+	if lim.opts.limitFunc == nil {
+		return
+	}
+
+	now := lim.opts.clock.Now()
+	lim.dynamic.Range(func(k, v any) bool {
+		if !now.Before(v.(dynamicLimit).expires) {
+			lim.dynamic.Delete(k)
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,86 @@
+package limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithLimitFunc(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			var calls atomic.Int32
+
+			l := New(RpsWithBurst(1, 1), WithLimitFunc(func(key string, r *http.Request) (int, int, bool) {
+				calls.Add(1)
+
+				switch key {
+				case "1.1.1.1":
+					return 1, 3, true
+				case "2.2.2.2":
+					return 1, 2, true
+				}
+
+				return 0, 0, false
+			}))
+			defer l.Stop()
+
+			h := handler(l)
+			allowed := func(ip string) int {
+				n := 0
+				for i := 0; i < 5; i++ {
+					req := httptest.NewRequest(http.MethodGet, "/test", nil)
+					req.Header.Set(XOFF, ip)
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, req)
+
+					if rec.Code == http.StatusOK {
+						n++
+					}
+				}
+
+				return n
+			}
+
+			assert.Equal(t, 3, allowed("1.1.1.1"))
+			assert.Equal(t, 2, allowed("2.2.2.2"))
+			assert.Equal(t, 1, allowed("3.3.3.3"), "defaults when func is not ok")
+			assert.Equal(t, int32(3), calls.Load(), "result is cached per key")
+		})
+	}
+}
+
+func TestWithLimitFuncExpiry(t *testing.T) {
+	var calls atomic.Int32
+
+	l := New(RecordTTL(20*time.Millisecond), WithLimitFunc(func(key string, r *http.Request) (int, int, bool) {
+		calls.Add(1)
+		return 1, 1, true
+	}))
+	defer l.Stop()
+
+	lim := l.(*limiter)
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+
+	lim.refreshLimit("1.1.1.1", req)
+	lim.refreshLimit("1.1.1.1", req)
+	assert.Equal(t, int32(1), calls.Load())
+
+	time.Sleep(30 * time.Millisecond)
+	lim.refreshLimit("1.1.1.1", req)
+	assert.Equal(t, int32(2), calls.Load(), "expired result is refreshed")
+
+	time.Sleep(30 * time.Millisecond)
+	lim.cleanupDynamic()
+	_, ok := lim.dynamic.Load("1.1.1.1")
+	assert.False(t, ok, "cleanup drops expired results")
+}
@@ -0,0 +1,116 @@
+// Package echolimit provides limiter middleware for echo.
+package echolimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/eldarthepro/limiter"
+	"github.com/eldarthepro/limiter/internal/hook"
+	"github.com/labstack/echo/v4"
+)
+
+// Limit attempts to extract ip using header from options,
+// if fails, uses echo RealIP(). KeyFunc from options
+// takes precedence over ip when set, CostFunc sets tokens request takes. Blocked ips get echo http error 403,
+// or status from options. If limit is reached,
+// calls OnReject from options and returns echo http error with RejectStatus and RejectMessage, 429 and "Too many requests" by default,
+// or responds with RejectionHandler from options if it is set,
+// Retry-After header tells client when to come back. Requests over GlobalLimit get echo http error 503, or status from options
+func Limit(lim limiter.Limiter) echo.MiddlewareFunc {
+	l := hook.From(lim)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if l.Skipped(c.Request().URL.Path) {
+				return next(c)
+			}
+
+			l, limited := l.Route(c.Request().Method, c.Request().URL.Path).WebSocket(c.Request().Header.Get)
+			if l.MissingIPHeader(c.Request().Header.Get) {
+				return echo.NewHTTPError(l.IPHeaderStatus())
+			}
+
+			realIP := hook.OrRemoteAddr(c.RealIP, c.Request())
+			key, ip := l.Key(c.Request(), realIP), l.RequestIP(c.Request(), realIP)
+			c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), limiter.ClientIPKey, ip)))
+
+			if l.Blocked(ip) {
+				return echo.NewHTTPError(l.BlockStatus())
+			}
+
+			if !limited || l.WhiteListed(key) || l.RequestWhitelisted(c.Request()) {
+				return next(c)
+			}
+
+			charged, cost := l.RequestKey(key, c.Request()), l.Cost(c.Request())
+			allowed, taken := l.AllowIdempotent(charged, c.Request().URL.Path, cost, c.Request().Header.Get)
+			release, allowed, taken := l.Admit(key, charged, c.Request().URL.Path, cost, allowed, taken)
+			defer release()
+
+			l.Decided(charged, c.Request().URL.Path, c.Request(), allowed)
+			trailer := l.SetRateLimitHeaders(c.Response().Header(), charged, c.Request().URL.Path, c.Request(), allowed)
+			if !allowed {
+				l.Rejected(key, c.Request())
+				if l.DryRun() {
+					return next(c)
+				}
+
+				retry := l.RetryAfter(charged, c.Request().URL.Path)
+				c.Response().Header().Set(hook.RetryAfter, hook.RetryAfterSeconds(retry))
+
+				if h := l.RejectionHandler(); h != nil {
+					h.ServeHTTP(c.Response(), c.Request())
+					return nil
+				}
+
+				if p := l.Problem(retry); p != nil {
+					c.Response().Header().Set(echo.HeaderContentType, hook.ProblemJSON)
+					c.Response().WriteHeader(l.RejectStatus())
+					return json.NewEncoder(c.Response()).Encode(p)
+				}
+
+				if h := l.Hint(retry); h != nil {
+					return c.JSON(l.RejectStatus(), h)
+				}
+
+				return echo.NewHTTPError(l.RejectStatus(), l.RejectMessage())
+			}
+
+			if retry, shed := l.Shed(); shed && !l.DryRun() {
+				c.Response().Header().Set(hook.RetryAfter, hook.RetryAfterSeconds(retry))
+				return echo.NewHTTPError(l.GlobalStatus())
+			}
+
+			err := next(c)
+			if taken {
+				if l.PenalizesStatuses() {
+					l.PenalizeStatus(charged, c.Request().URL.Path, echoStatus(c, err))
+				}
+				l.RefundCanceled(c.Request().Context(), charged, c.Request().URL.Path, cost)
+			}
+
+			if trailer {
+				l.SetRateLimitTrailer(c.Response().Header(), charged, c.Request().URL.Path)
+			}
+
+			return err
+		}
+	}
+}
+
+// echoStatus returns status of response to c, or the one error handler will respond with for err returned by handler.
+func echoStatus(c echo.Context, err error) int {
+	if err == nil || c.Response().Committed {
+		return c.Response().Status
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return he.Code
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,393 @@
+package echolimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eldarthepro/limiter"
+	"github.com/eldarthepro/limiter/limitertest"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// newEcho returns echo limited by l that answers every request with h, or with 200 if h is nil.
+func newEcho(l limiter.Limiter, h echo.HandlerFunc) *echo.Echo {
+	if h == nil {
+		h = func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	}
+
+	e := echo.New()
+	e.Use(Limit(l))
+	e.Any("/*", h)
+
+	return e
+}
+
+// send makes GET request to / of h from ip.
+func send(h http.Handler, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(limiter.XOFF, ip)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestLimit(t *testing.T) {
+	var (
+		someIP = "1.1.1.1"
+	)
+
+	tests := []struct {
+		name           string
+		limiter        func() limiter.Limiter
+		ipHeaderValue  string
+		numReq         int
+		expectedStatus int
+		remoteAddr     string
+	}{
+		{
+			name:           "allow_whitelisted_ip",
+			limiter:        func() limiter.Limiter { return limiter.New(limiter.RpsWithBurst(1, 1), limiter.AllowedIPs(someIP)) },
+			ipHeaderValue:  someIP,
+			numReq:         3,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "allow_prefix",
+			limiter:        func() limiter.Limiter { return limiter.New(limiter.RpsWithBurst(1, 1), limiter.AllowedPrefixes("1.")) },
+			ipHeaderValue:  someIP,
+			numReq:         3,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "allow_under_limit",
+			limiter:        func() limiter.Limiter { return limiter.New(limiter.RpsWithBurst(1, 1)) },
+			ipHeaderValue:  someIP,
+			numReq:         1,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "reject_over_limit",
+			limiter:        func() limiter.Limiter { return limiter.New(limiter.RpsWithBurst(1, 1)) },
+			ipHeaderValue:  someIP,
+			numReq:         2,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:           "fallback_to_real_ip",
+			limiter:        func() limiter.Limiter { return limiter.New(limiter.RpsWithBurst(1, 1)) },
+			numReq:         2,
+			expectedStatus: http.StatusTooManyRequests,
+			remoteAddr:     "4.4.4.4:12345",
+		},
+		{
+			name:           "csv_in_header",
+			limiter:        func() limiter.Limiter { return limiter.New(limiter.RpsWithBurst(1, 1)) },
+			ipHeaderValue:  "5.5.5.5, 6.6.6.6, 7.7.7.7",
+			numReq:         1,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := tt.limiter()
+			defer l.Stop()
+
+			e := echo.New()
+			e.Use(Limit(l))
+			e.GET("/test", func(c echo.Context) error {
+				return c.String(http.StatusOK, "OK")
+			})
+
+			var rec *httptest.ResponseRecorder
+			for i := 0; i < tt.numReq; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				rec = httptest.NewRecorder()
+
+				if tt.ipHeaderValue != "" {
+					req.Header.Set(limiter.XOFF, tt.ipHeaderValue)
+				}
+
+				if tt.remoteAddr != "" {
+					req.RemoteAddr = tt.remoteAddr
+				}
+
+				e.ServeHTTP(rec, req)
+			}
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			if tt.expectedStatus == http.StatusTooManyRequests {
+				assert.Contains(t, rec.Body.String(), "Too many requests")
+				assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestClientIPFromContext(t *testing.T) {
+	l := limiter.New()
+	defer l.Stop()
+
+	var got string
+	e := newEcho(l, func(c echo.Context) error {
+		got, _ = limiter.ClientIPFromContext(c.Request().Context())
+		return c.NoContent(http.StatusOK)
+	})
+
+	send(e, "1.1.1.1")
+
+	assert.Equal(t, "1.1.1.1", got)
+}
+
+func TestProblemDetails(t *testing.T) {
+	l := limiter.New(limiter.Period(1, 5*time.Second), limiter.Burst(1), limiter.WithProblemDetails())
+	defer l.Stop()
+
+	e := newEcho(l, nil)
+	send(e, "1.1.1.1")
+	rec := send(e, "1.1.1.1")
+
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+
+	var p map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	assert.Equal(t, map[string]any{"type": "about:blank", "title": "Too Many Requests", "status": float64(http.StatusTooManyRequests),
+		"detail": "Too many requests", "retryAfter": float64(5)}, p)
+}
+
+func TestBlocked(t *testing.T) {
+	l := limiter.New(limiter.BlockedIPs("1.1.1.1"), limiter.KeyFunc(func(r *http.Request) string { return r.Header.Get("Authorization") }))
+	defer l.Stop()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(limiter.XOFF, "1.1.1.1")
+	req.Header.Set("Authorization", "token")
+	rec := httptest.NewRecorder()
+	newEcho(l, nil).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusForbidden, rec.Code, "blocked ip cant escape with another key")
+	assert.Equal(t, http.StatusOK, send(newEcho(l, nil), "2.2.2.2").Code)
+}
+
+func TestOnDecision(t *testing.T) {
+	type decision struct {
+		path      string
+		allowed   bool
+		remaining float64
+	}
+
+	var got []decision
+	l := limiter.New(limiter.RpsWithBurst(1, 2), limiter.WithClock(limitertest.NewFakeClock(time.Unix(0, 0))),
+		limiter.OnDecision(func(r *http.Request, allowed bool, remaining float64) {
+			got = append(got, decision{r.URL.Path, allowed, remaining})
+		}))
+	defer l.Stop()
+
+	e := newEcho(l, nil)
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api", nil)
+		req.Header.Set(limiter.XOFF, "1.1.1.1")
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(t, []decision{
+		{"/api", true, 1},
+		{"/api", true, 0},
+		{"/api", false, 0},
+	}, got)
+}
+
+func TestGlobalLimit(t *testing.T) {
+	c := limitertest.NewFakeClock(time.Unix(0, 0))
+	l := limiter.New(limiter.WithClock(c), limiter.GlobalLimit(1, 5))
+	defer l.Stop()
+
+	e := newEcho(l, nil)
+
+	// every ip stays far below its own limit, but together they drain the global bucket.
+	for i := range 5 {
+		assert.Equal(t, http.StatusOK, send(e, fmt.Sprintf("10.0.0.%d", i)).Code)
+	}
+
+	rec := send(e, "10.0.0.5")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	c.Advance(time.Second)
+	assert.Equal(t, http.StatusOK, send(e, "10.0.1.1").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, send(e, "10.0.1.2").Code)
+}
+
+func TestWithRateLimitHeaders(t *testing.T) {
+	l := limiter.New(limiter.RpsWithBurst(1, 2), limiter.WithRateLimitHeaders(), limiter.WithClock(limitertest.NewFakeClock(time.Unix(0, 0))))
+	defer l.Stop()
+
+	e := newEcho(l, nil)
+	for _, tt := range []struct {
+		code      int
+		remaining string
+	}{
+		{http.StatusOK, "1"},
+		{http.StatusOK, "0"},
+		{http.StatusTooManyRequests, "0"},
+	} {
+		rec := send(e, "1.1.1.1")
+
+		assert.Equal(t, tt.code, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, tt.remaining, rec.Header().Get("X-RateLimit-Remaining"))
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	l := limiter.New(limiter.RpsWithBurst(1, 2), limiter.IdempotencyKey("Idempotency-Key", time.Minute),
+		limiter.WithClock(limitertest.NewFakeClock(time.Unix(0, 0))))
+	defer l.Stop()
+
+	e := newEcho(l, nil)
+	do := func(id string) int {
+		req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+		req.Header.Set(limiter.XOFF, "1.1.1.1")
+		if id != "" {
+			req.Header.Set("Idempotency-Key", id)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	// retries of the same request cost one token.
+	assert.Equal(t, http.StatusOK, do("a"))
+	assert.Equal(t, http.StatusOK, do("a"))
+	assert.Equal(t, http.StatusOK, do(""))
+	assert.Equal(t, http.StatusTooManyRequests, do("b"))
+	assert.Equal(t, http.StatusOK, do("a"))
+}
+
+func TestRequireIPHeader(t *testing.T) {
+	l := limiter.New(limiter.RequireIPHeader(), limiter.IPHeaderStatus(http.StatusForbidden))
+	defer l.Stop()
+
+	e := newEcho(l, nil)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	assert.Equal(t, http.StatusOK, send(e, "1.1.1.1").Code)
+}
+
+func TestRemoteAddrIPv6Forms(t *testing.T) {
+	l := limiter.New(limiter.RpsWithBurst(1, 2), limiter.IPHeaders("X-Unused"))
+	defer l.Stop()
+
+	e := newEcho(l, nil)
+	var codes []int
+	for _, form := range []string{"[::1]:8080", "::1", "[::1]"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = form
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+
+	assert.Equal(t, []int{200, 200, 429}, codes, "all forms share a bucket")
+}
+
+func TestRefundOnClientCancel(t *testing.T) {
+	l := limiter.New(limiter.RpsWithBurst(1, 3), limiter.RefundOnClientCancel(), limiter.WithClock(limitertest.NewFakeClock(time.Unix(0, 0))))
+	defer l.Stop()
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		// handler cancels request context, as if client disconnected while it runs.
+		ctx, cancel := context.WithCancel(context.Background())
+		e := newEcho(l, func(c echo.Context) error {
+			cancel()
+			return c.NoContent(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		req.Header.Set(limiter.XOFF, "1.1.1.1")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		cancel()
+
+		if rec.Code != http.StatusTooManyRequests {
+			allowed++
+		}
+	}
+
+	assert.Equal(t, 10, allowed)
+}
+
+func TestPenalizeStatuses(t *testing.T) {
+	l := limiter.New(limiter.RpsWithBurst(1, 5), limiter.PenalizeStatuses(2, http.StatusUnauthorized),
+		limiter.WithClock(limitertest.NewFakeClock(time.Unix(0, 0))))
+	defer l.Stop()
+
+	e := newEcho(l, func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	})
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if send(e, "1.1.1.1").Code != http.StatusTooManyRequests {
+			allowed++
+		}
+	}
+
+	assert.Equal(t, 2, allowed)
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	var rejected int
+	l := limiter.New(limiter.RpsWithBurst(1, 2), limiter.MaxConcurrent(1), limiter.WithProblemDetails(),
+		limiter.WithClock(limitertest.NewFakeClock(time.Unix(0, 0))),
+		limiter.OnReject(func(key string, r *http.Request) { rejected++ }))
+	defer l.Stop()
+
+	entered, unblock := make(chan struct{}), make(chan struct{})
+	e := newEcho(l, func(c echo.Context) error {
+		if c.Request().Header.Get("X-Slow") != "" {
+			entered <- struct{}{}
+			<-unblock
+		}
+
+		return c.NoContent(http.StatusOK)
+	})
+
+	done := make(chan int)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(limiter.XOFF, "1.1.1.1")
+		req.Header.Set("X-Slow", "1")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		done <- rec.Code
+	}()
+	<-entered
+
+	rec := send(e, "1.1.1.1")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, 1, rejected)
+
+	close(unblock)
+	assert.Equal(t, http.StatusOK, <-done)
+
+	// rejected request got its token back.
+	assert.Equal(t, http.StatusOK, send(e, "1.1.1.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, send(e, "1.1.1.1").Code)
+	assert.Equal(t, 2, rejected)
+}
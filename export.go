@@ -0,0 +1,67 @@
+package limiter
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// VisitorState is state of visitor returned by Export, Key is the one visitor is stored by, like in Range.
+type VisitorState struct {
+	Key      string    `json:"key"`
+	LastSeen time.Time `json:"lastSeen"`
+	Tokens   float64   `json:"tokens"`
+}
+
+// Export returns state of every visitor tracked by store, for example to restore it with Import in a new instance after redeploy, so
+// clients dont get a fresh budget. Stores that cant list visitors, such as redis one, return none. Limiters set by LimitPath and
+// LimitMethod are not included.
+func (lim *limiter) Export() []VisitorState {
+	var states []VisitorState
+	lim.Range(func(key string, lastSeen time.Time, tokens float64) bool {
+		states = append(states, VisitorState{Key: key, LastSeen: lastSeen, Tokens: tokens})
+		return true
+	})
+
+	return states
+}
+
+// Import replaces visitors of states with buckets holding their tokens, rounded down, and keeps their last seen time, so they expire
+// like in limiter they were exported from. Tokens dont refill for time between export and import. Buckets get limit of key, or defaults
+// for keys that are hashed or include path. Stores that cant import visitors, such as redis one, ignore it.
+func (lim *limiter) Import(states []VisitorState) {
+	im, ok := lim.store.(importer)
+	if !ok {
+		return
+	}
+
+	for _, st := range states {
+		limit, burst := lim.rateFor(st.Key)
+		im.Import(st.Key, st.LastSeen, st.Tokens, limit, burst)
+	}
+}
+
+// Import stores visitor ip seen at lastSeen with bucket of limit and burst that has tokens left, replacing existing one.
+func (s *memoryStore) Import(ip string, lastSeen time.Time, tokens float64, limit rate.Limit, burst int) {
+	r := s.newRecord(limit, burst)
+	r.touch(lastSeen)
+
+	if taken := math.Ceil(float64(burst) - tokens); taken > 0 && !math.IsNaN(tokens) {
+		r.bucket.penalize(s.clock.Now(), int(min(taken, math.MaxInt32)))
+	}
+
+	sh := s.shard(ip)
+	sh.Lock()
+	defer sh.Unlock()
+
+	if _, ok := sh.storage[ip]; !ok {
+		if s.shardMax > 0 && len(sh.storage) >= s.shardMax && sh.evictOldest() {
+			s.size.Add(-1)
+		}
+
+		s.size.Add(1)
+	}
+
+	sh.add(ip, r)
+}
@@ -0,0 +1,73 @@
+package limiter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	c := &manualClock{now: time.Unix(1000, 0)}
+	old := New(RpsWithBurst(1, 5), WithClock(c))
+	defer old.Stop()
+
+	for i, ip := range []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"} {
+		for j := 0; j <= i; j++ {
+			old.allow(ip)
+		}
+	}
+
+	b, err := json.Marshal(old.Export())
+	require.NoError(t, err)
+
+	var states []VisitorState
+	require.NoError(t, json.Unmarshal(b, &states))
+	assert.Len(t, states, 3)
+
+	l := New(RpsWithBurst(1, 5), WithClock(c))
+	defer l.Stop()
+
+	l.allow("1.1.1.1")
+	l.Import(states)
+
+	assert.Equal(t, 3, len(l.Export()))
+	assert.ElementsMatch(t, old.Export(), l.Export())
+
+	// remaining budget is preserved, instead of a fresh burst of 5.
+	for ip, left := range map[string]int{"1.1.1.1": 4, "2.2.2.2": 3, "3.3.3.3": 2} {
+		for i := 0; i < left; i++ {
+			assert.True(t, l.allow(ip), ip)
+		}
+		assert.False(t, l.allow(ip), ip)
+	}
+}
+
+func TestImportRoundsDown(t *testing.T) {
+	c := &manualClock{now: time.Unix(1000, 0)}
+	l := New(RpsWithBurst(1, 5), WithClock(c))
+	defer l.Stop()
+
+	l.Import([]VisitorState{{Key: "1.1.1.1", LastSeen: c.now, Tokens: 1.5}, {Key: "2.2.2.2", LastSeen: c.now, Tokens: 0}})
+
+	assert.True(t, l.allow("1.1.1.1"))
+	assert.False(t, l.allow("1.1.1.1"))
+	assert.False(t, l.allow("2.2.2.2"))
+}
+
+func TestImportKeepsLastSeen(t *testing.T) {
+	c := &manualClock{now: time.Unix(1000, 0)}
+	l := New(WithClock(c), RecordTTL(time.Minute))
+	defer l.Stop()
+
+	l.Import([]VisitorState{
+		{Key: "1.1.1.1", LastSeen: c.now.Add(-2 * time.Minute), Tokens: 1},
+		{Key: "2.2.2.2", LastSeen: c.now, Tokens: 1},
+	})
+
+	l.(*limiter).cleanup()
+
+	assert.Equal(t, 1, len(l.Export()))
+}
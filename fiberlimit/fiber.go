@@ -0,0 +1,92 @@
+// Package fiberlimit provides limiter middleware for fiber.
+package fiberlimit
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/eldarthepro/limiter"
+	"github.com/eldarthepro/limiter/internal/hook"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Limit attempts to extract ip using header from options,
+// if fails, uses fiber IP(). BypassHeader is checked like in limiter.Limit. KeyFunc, WhitelistFunc, OnReject and their gin variants
+// are not used, since fiber has no http.Request. Blocked ips get http 403,
+// or status from options. If limit is reached,
+// will respond with RejectStatus and RejectMessage, http 429 and "Too many requests" by default,
+// Retry-After header tells client when to come back. Requests over GlobalLimit get http 503, or status from options
+func Limit(lim limiter.Limiter) fiber.Handler {
+	l := hook.From(lim)
+
+	return func(c *fiber.Ctx) error {
+		if l.Skipped(c.Path()) {
+			return c.Next()
+		}
+
+		header := func(h string) string { return c.Get(h) }
+		l, limited := l.Route(c.Method(), c.Path()).WebSocket(header)
+		if l.MissingIPHeader(header) {
+			return c.Status(l.IPHeaderStatus()).SendString(http.StatusText(l.IPHeaderStatus()))
+		}
+
+		key := l.ClientIP(l.IPHeaderValue(header), c.IP)
+
+		if l.Blocked(key) {
+			return c.Status(l.BlockStatus()).SendString(http.StatusText(l.BlockStatus()))
+		}
+
+		if !limited || l.WhiteListed(key) || l.Bypassed(header) {
+			return c.Next()
+		}
+
+		allowed, taken := l.AllowIdempotent(key, c.Path(), 1, header)
+		release, allowed, taken := l.Admit(key, key, c.Path(), 1, allowed, taken)
+		defer release()
+
+		if !allowed {
+			if l.DryRun() {
+				return c.Next()
+			}
+
+			retry := l.RetryAfter(key, c.Path())
+			c.Set(hook.RetryAfter, hook.RetryAfterSeconds(retry))
+
+			if p := l.Problem(retry); p != nil {
+				return c.Status(l.RejectStatus()).JSON(p, hook.ProblemJSON)
+			}
+
+			if h := l.Hint(retry); h != nil {
+				return c.Status(l.RejectStatus()).JSON(h)
+			}
+
+			return c.Status(l.RejectStatus()).SendString(l.RejectMessage())
+		}
+
+		if retry, shed := l.Shed(); shed && !l.DryRun() {
+			c.Set(hook.RetryAfter, hook.RetryAfterSeconds(retry))
+			return c.Status(l.GlobalStatus()).SendString(http.StatusText(l.GlobalStatus()))
+		}
+
+		err := c.Next()
+		if l.PenalizesStatuses() && taken {
+			l.PenalizeStatus(key, c.Path(), fiberStatus(c, err))
+		}
+
+		return err
+	}
+}
+
+// fiberStatus returns status of response to c, or the one error handler will respond with for err returned by handler.
+func fiberStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return fe.Code
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,221 @@
+package fiberlimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eldarthepro/limiter"
+	"github.com/eldarthepro/limiter/limitertest"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// newApp returns fiber app limited by l that answers every request with h, or with 200 if h is nil.
+func newApp(l limiter.Limiter, h fiber.Handler) *fiber.App {
+	if h == nil {
+		h = func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) }
+	}
+
+	app := fiber.New()
+	app.Use(Limit(l))
+	app.All("/*", h)
+
+	return app
+}
+
+// send makes request with method to / of app from ip, setting headers given as name and value pairs.
+func send(t *testing.T, app *fiber.App, method, ip string, headers ...string) *http.Response {
+	req := httptest.NewRequest(method, "/", nil)
+	if ip != "" {
+		req.Header.Set(limiter.XOFF, ip)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+
+	return resp
+}
+
+func TestLimit(t *testing.T) {
+	var (
+		someIP = "1.1.1.1"
+	)
+
+	tests := []struct {
+		name           string
+		limiter        func() limiter.Limiter
+		ipHeaderValue  string
+		numReq         int
+		expectedStatus int
+	}{
+		{
+			name:           "allow_whitelisted_ip",
+			limiter:        func() limiter.Limiter { return limiter.New(limiter.RpsWithBurst(1, 1), limiter.AllowedIPs(someIP)) },
+			ipHeaderValue:  someIP,
+			numReq:         3,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "allow_prefix",
+			limiter:        func() limiter.Limiter { return limiter.New(limiter.RpsWithBurst(1, 1), limiter.AllowedPrefixes("1.")) },
+			ipHeaderValue:  someIP,
+			numReq:         3,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "allow_under_limit",
+			limiter:        func() limiter.Limiter { return limiter.New(limiter.RpsWithBurst(1, 1)) },
+			ipHeaderValue:  someIP,
+			numReq:         1,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "reject_over_limit",
+			limiter:        func() limiter.Limiter { return limiter.New(limiter.RpsWithBurst(1, 1)) },
+			ipHeaderValue:  someIP,
+			numReq:         2,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:           "fallback_to_fiber_ip",
+			limiter:        func() limiter.Limiter { return limiter.New(limiter.RpsWithBurst(1, 1)) },
+			numReq:         2,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:           "csv_in_header",
+			limiter:        func() limiter.Limiter { return limiter.New(limiter.RpsWithBurst(1, 1)) },
+			ipHeaderValue:  "5.5.5.5, 6.6.6.6, 7.7.7.7",
+			numReq:         1,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "blocked",
+			limiter:        func() limiter.Limiter { return limiter.New(limiter.BlockedIPs(someIP)) },
+			ipHeaderValue:  someIP,
+			numReq:         1,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := tt.limiter()
+			defer l.Stop()
+
+			app := fiber.New()
+			app.Use(Limit(l))
+			app.Get("/test", func(c *fiber.Ctx) error {
+				return c.SendString("OK")
+			})
+
+			var resp *http.Response
+			for i := 0; i < tt.numReq; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+
+				if tt.ipHeaderValue != "" {
+					req.Header.Set(limiter.XOFF, tt.ipHeaderValue)
+				}
+
+				var err error
+				resp, err = app.Test(req)
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			if tt.expectedStatus == http.StatusTooManyRequests {
+				body, _ := io.ReadAll(resp.Body)
+				assert.Equal(t, "Too many requests", string(body))
+				assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	l := limiter.New(limiter.Period(1, 5*time.Second), limiter.Burst(1), limiter.WithProblemDetails())
+	defer l.Stop()
+
+	app := newApp(l, nil)
+	send(t, app, http.MethodGet, "1.1.1.1")
+	resp := send(t, app, http.MethodGet, "1.1.1.1")
+
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+
+	var p map[string]any
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&p))
+	assert.Equal(t, map[string]any{"type": "about:blank", "title": "Too Many Requests", "status": float64(http.StatusTooManyRequests),
+		"detail": "Too many requests", "retryAfter": float64(5)}, p)
+}
+
+func TestGlobalLimit(t *testing.T) {
+	l := limiter.New(limiter.WithClock(limitertest.NewFakeClock(time.Unix(0, 0))), limiter.GlobalLimit(1, 2))
+	defer l.Stop()
+
+	app := newApp(l, nil)
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusServiceUnavailable, http.StatusServiceUnavailable} {
+		resp := send(t, app, http.MethodGet, fmt.Sprintf("10.0.0.%d", i))
+		assert.Equal(t, want, resp.StatusCode)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	l := limiter.New(limiter.RpsWithBurst(1, 2), limiter.IdempotencyKey("Idempotency-Key", time.Minute),
+		limiter.WithClock(limitertest.NewFakeClock(time.Unix(0, 0))))
+	defer l.Stop()
+
+	app := newApp(l, nil)
+	do := func(id string) int {
+		if id == "" {
+			return send(t, app, http.MethodPost, "1.1.1.1").StatusCode
+		}
+
+		return send(t, app, http.MethodPost, "1.1.1.1", "Idempotency-Key", id).StatusCode
+	}
+
+	// retries of the same request cost one token.
+	assert.Equal(t, http.StatusOK, do("a"))
+	assert.Equal(t, http.StatusOK, do("a"))
+	assert.Equal(t, http.StatusOK, do(""))
+	assert.Equal(t, http.StatusTooManyRequests, do("b"))
+	assert.Equal(t, http.StatusOK, do("a"))
+}
+
+func TestRequireIPHeader(t *testing.T) {
+	l := limiter.New(limiter.RequireIPHeader(), limiter.IPHeaderStatus(http.StatusForbidden))
+	defer l.Stop()
+
+	app := newApp(l, nil)
+
+	assert.Equal(t, http.StatusForbidden, send(t, app, http.MethodGet, "").StatusCode)
+	assert.Equal(t, http.StatusOK, send(t, app, http.MethodGet, "1.1.1.1").StatusCode)
+}
+
+func TestPenalizeStatuses(t *testing.T) {
+	l := limiter.New(limiter.RpsWithBurst(1, 5), limiter.PenalizeStatuses(2, http.StatusUnauthorized),
+		limiter.WithClock(limitertest.NewFakeClock(time.Unix(0, 0))))
+	defer l.Stop()
+
+	app := newApp(l, func(c *fiber.Ctx) error {
+		return fiber.ErrUnauthorized
+	})
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if send(t, app, http.MethodGet, "1.1.1.1").StatusCode != http.StatusTooManyRequests {
+			allowed++
+		}
+	}
+
+	assert.Equal(t, 2, allowed)
+}
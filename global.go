@@ -0,0 +1,65 @@
+package limiter
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// GlobalLimit sets limit shared by all visitors, checked after visitor's own limit. It protects backend from traffic spread
+// across many ips, each of them staying under its own limit. Requests over it get http 503, or status set by GlobalLimitStatus,
+// with Retry-After telling when the shared bucket has a token again. Limiters set by LimitPath and LimitMethod have their own global limit.
+func GlobalLimit(rps, burst int) option {
+	var errs []error
+
+	if rps < 0 || burst < 0 {
+		errs = append(errs, invalidOption("negative global rps %d or burst %d", rps, burst))
+		return func(opts *limiterOptions) {
+			opts.errs = append(opts.errs, errs...)
+		}
+	}
+
+	return func(opts *limiterOptions) {
+		opts.global = &rateLimit{rate.Limit(rps), burst}
+	}
+}
+
+// GlobalLimitStatus sets http status returned to requests over GlobalLimit, 503 by default.
+func GlobalLimitStatus(code int) option {
+	var errs []error
+
+	if !validStatus(code) {
+		errs = append(errs, invalidOption("invalid global limit status %d", code))
+		code = http.StatusServiceUnavailable
+	}
+
+	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.globalStatus = code
+	}
+}
+
+// shed takes token from global bucket. If it is empty, reports true and returns how long until it has one.
+func (lim *limiter) shed() (time.Duration, bool) {
+	if lim.global == nil {
+		return 0, false
+	}
+
+	now := lim.opts.clock.Now()
+	if lim.global.AllowN(now, 1) {
+		return 0, false
+	}
+
+	if lim.global.Limit() <= 0 {
+		return lim.opts.period, true
+	}
+
+	missing := 1 - lim.global.TokensAt(now)
+
+	return time.Duration(missing / float64(lim.global.Limit()) * float64(time.Second)), true
+}
+
+func (lim *limiter) globalStatus() int {
+	return lim.opts.globalStatus
+}
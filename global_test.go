@@ -0,0 +1,119 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobalLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	tests := []struct {
+		name   string
+		opts   []option
+		status int
+	}{
+		{name: "default status", opts: []option{GlobalLimit(1, 5)}, status: http.StatusServiceUnavailable},
+		{name: "custom status", opts: []option{GlobalLimit(1, 5), GlobalLimitStatus(http.StatusTooManyRequests)}, status: http.StatusTooManyRequests},
+	}
+
+	for name, handler := range handlers {
+		for _, tt := range tests {
+			t.Run(name+"_"+tt.name, func(t *testing.T) {
+				c := &manualClock{now: time.Unix(0, 0)}
+				l := New(append([]option{WithClock(c)}, tt.opts...)...)
+				defer l.Stop()
+
+				h := handler(l)
+				do := func(ip string) *httptest.ResponseRecorder {
+					req := httptest.NewRequest(http.MethodGet, "/", nil)
+					req.Header.Set(XOFF, ip)
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, req)
+
+					return rec
+				}
+
+				// every ip stays far below its own limit, but together they drain the global bucket.
+				for i := range 5 {
+					assert.Equal(t, http.StatusOK, do(fmt.Sprintf("10.0.0.%d", i)).Code)
+				}
+
+				for i := 5; i < 50; i++ {
+					rec := do(fmt.Sprintf("10.0.0.%d", i))
+					assert.Equal(t, tt.status, rec.Code)
+					assert.Equal(t, "1", rec.Header().Get(retryAfter))
+				}
+
+				c.now = c.now.Add(time.Second)
+				assert.Equal(t, http.StatusOK, do("10.0.1.1").Code)
+				assert.Equal(t, tt.status, do("10.0.1.2").Code)
+			})
+		}
+	}
+}
+
+func TestGlobalLimitPerIPFirst(t *testing.T) {
+	c := &manualClock{now: time.Unix(0, 0)}
+	l := New(WithClock(c), RpsWithBurst(1, 1), GlobalLimit(1, 2))
+	defer l.Stop()
+
+	// requests rejected by their own limit dont take global tokens.
+	assert.True(t, l.Allow("1.1.1.1"))
+	assert.False(t, l.Allow("1.1.1.1"))
+	assert.False(t, l.Allow("1.1.1.1"))
+	assert.True(t, l.Allow("2.2.2.2"))
+	assert.False(t, l.Allow("3.3.3.3"))
+}
+
+func TestGlobalLimitDryRun(t *testing.T) {
+	l := New(WithClock(&manualClock{now: time.Unix(0, 0)}), GlobalLimit(1, 1), DryRun(true))
+	defer l.Stop()
+
+	h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := range 3 {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(XOFF, fmt.Sprintf("10.0.0.%d", i))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+}
+
+func TestGlobalLimitWait(t *testing.T) {
+	l := New(GlobalLimit(1, 1))
+	defer l.Stop()
+
+	assert.NoError(t, l.Wait(context.Background(), "1.1.1.1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, l.Wait(ctx, "2.2.2.2"))
+}
+
+func TestGlobalLimitInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  option
+	}{
+		{name: "negative rps", opt: GlobalLimit(-1, 1)},
+		{name: "negative burst", opt: GlobalLimit(1, -1)},
+		{name: "status", opt: GlobalLimitStatus(42)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWithError(tt.opt)
+			assert.ErrorIs(t, err, ErrInvalidOption)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// Package grpclimit provides limiter interceptors for grpc servers.
+package grpclimit
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/eldarthepro/limiter"
+	"github.com/eldarthepro/limiter/internal/hook"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor attempts to extract ip from metadata headers set in options,
+// if fails, uses peer address. Full method name is used as path for LimitPath,
+// method is always POST. Blocked ips get PermissionDenied, calls without ip header required by RequireIPHeader get InvalidArgument. If limit is reached,
+// returns ResourceExhausted error with RejectMessage, "Too many requests" by default,
+// retry-after header tells client when to come back. Calls over GlobalLimit get Unavailable.
+// KeyFunc, CostFunc, WhitelistFunc, OnReject and rejection handlers are not used, since grpc has no http.Request.
+func UnaryServerInterceptor(lim limiter.Limiter) grpc.UnaryServerInterceptor {
+	l := hook.From(lim)
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := grpcLimit(ctx, l, info.FullMethod, func(md metadata.MD) error {
+			return grpc.SetHeader(ctx, md)
+		}); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is a stream counterpart of UnaryServerInterceptor, limit is checked once when stream is opened.
+func StreamServerInterceptor(lim limiter.Limiter) grpc.StreamServerInterceptor {
+	l := hook.From(lim)
+
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := grpcLimit(ss.Context(), l, info.FullMethod, ss.SetHeader); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// grpcLimit checks limit for call to method, setHeader is used to send retry-after to rejected client.
+func grpcLimit(ctx context.Context, l hook.Limiter, method string, setHeader func(metadata.MD) error) error {
+	l = l.Route(http.MethodPost, method)
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	get := func(h string) string {
+		return strings.Join(md.Get(h), ",")
+	}
+
+	if l.MissingIPHeader(get) {
+		return status.Error(codes.InvalidArgument, http.StatusText(http.StatusBadRequest))
+	}
+
+	header := l.IPHeaderValue(get)
+
+	key := l.ClientIP(header, peerIP(ctx))
+
+	if l.Blocked(key) {
+		return status.Error(codes.PermissionDenied, http.StatusText(http.StatusForbidden))
+	}
+
+	if l.WhiteListed(key) {
+		return nil
+	}
+
+	if !l.AllowN(key, method, 1) {
+		if l.DryRun() {
+			return nil
+		}
+
+		_ = setHeader(metadata.Pairs(hook.RetryAfter, hook.RetryAfterSeconds(l.RetryAfter(key, method))))
+
+		return status.Error(codes.ResourceExhausted, l.RejectMessage())
+	}
+
+	if retry, shed := l.Shed(); shed && !l.DryRun() {
+		_ = setHeader(metadata.Pairs(hook.RetryAfter, hook.RetryAfterSeconds(retry)))
+
+		return status.Error(codes.Unavailable, http.StatusText(http.StatusServiceUnavailable))
+	}
+
+	return nil
+}
+
+// peerIP returns host part of peer address, or whole address if it has no port.
+func peerIP(ctx context.Context) func() string {
+	return func() string {
+		p, ok := peer.FromContext(ctx)
+		if !ok || p.Addr == nil {
+			return ""
+		}
+
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			return p.Addr.String()
+		}
+
+		return host
+	}
+}
@@ -0,0 +1,141 @@
+package grpclimit
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/eldarthepro/limiter"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestGRPC(t *testing.T, l limiter.Limiter) healthpb.HealthClient {
+	lis := bufconn.Listen(1 << 20)
+
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(l)),
+		grpc.StreamInterceptor(StreamServerInterceptor(l)),
+	)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	l := limiter.New(limiter.RpsWithBurst(1, 2), limiter.AllowedIPs("9.9.9.9"), limiter.BlockedIPs("6.6.6.6"))
+	defer l.Stop()
+
+	client := newTestGRPC(t, l)
+
+	tests := []struct {
+		name     string
+		ip       string
+		expected []codes.Code
+	}{
+		{
+			name:     "limited",
+			ip:       "1.1.1.1",
+			expected: []codes.Code{codes.OK, codes.OK, codes.ResourceExhausted},
+		},
+		{
+			name:     "whitelisted",
+			ip:       "9.9.9.9",
+			expected: []codes.Code{codes.OK, codes.OK, codes.OK},
+		},
+		{
+			name:     "blocked",
+			ip:       "6.6.6.6",
+			expected: []codes.Code{codes.PermissionDenied},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.AppendToOutgoingContext(context.Background(), limiter.XOFF, tt.ip)
+
+			for _, code := range tt.expected {
+				var header metadata.MD
+				_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+				assert.Equal(t, code, status.Code(err))
+
+				if code == codes.ResourceExhausted {
+					assert.Equal(t, "Too many requests", status.Convert(err).Message())
+					assert.Equal(t, []string{"1"}, header.Get("Retry-After"))
+				}
+			}
+		})
+	}
+}
+
+func TestUnaryServerInterceptorPeer(t *testing.T) {
+	l := limiter.New(limiter.RpsWithBurst(1, 1))
+	defer l.Stop()
+
+	client := newTestGRPC(t, l)
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	l := limiter.New(limiter.RpsWithBurst(1, 1))
+	defer l.Stop()
+
+	client := newTestGRPC(t, l)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), limiter.XOFF, "1.1.1.1")
+
+	watch := func() error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			return err
+		}
+
+		_, err = stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+
+		return err
+	}
+
+	assert.NoError(t, watch())
+	assert.Equal(t, codes.ResourceExhausted, status.Code(watch()))
+}
+
+func TestRequireIPHeader(t *testing.T) {
+	l := limiter.New(limiter.RequireIPHeader())
+	defer l.Stop()
+
+	client := newTestGRPC(t, l)
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Check(metadata.AppendToOutgoingContext(context.Background(), limiter.XOFF, "1.1.1.1"), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,60 @@
+package limiter
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+const (
+	rateLimitLimit     = "X-RateLimit-Limit"
+	rateLimitRemaining = "X-RateLimit-Remaining"
+)
+
+// WithRateLimitHeaders makes middlewares set X-RateLimit-Limit header to burst of key and X-RateLimit-Remaining to whole tokens it has
+// left after request, on allowed and rejected responses. Remaining is left out for unlimited keys and stores that cant tell it, such as
+// redis one. Remaining of streaming requests is sent as trailer, see RateLimitTrailer. Used by Limit, GinLimit and echolimit.Limit.
+func WithRateLimitHeaders() option {
+	return func(opts *limiterOptions) {
+		opts.rateLimitHeaders = true
+	}
+}
+
+// RateLimitTrailer makes WithRateLimitHeaders send X-RateLimit-Remaining of allowed requests as trailer, once handler is done, for
+// streaming responses that send headers before final accounting. Requests with chunked body are treated as streaming without it.
+func RateLimitTrailer() option {
+	return func(opts *limiterOptions) {
+		opts.rateLimitTrailer = true
+	}
+}
+
+// setRateLimitHeaders sets WithRateLimitHeaders headers for key that requested path. For allowed streaming requests remaining tokens
+// are declared as trailer instead, reports whether they were, so setRateLimitTrailer has to be called after handler.
+func (lim *limiter) setRateLimitHeaders(h http.Header, key, path string, r *http.Request, allowed bool) bool {
+	if !lim.opts.rateLimitHeaders {
+		return false
+	}
+
+	_, burst := lim.rateFor(key)
+	h.Set(rateLimitLimit, strconv.Itoa(burst))
+
+	if allowed && (lim.opts.rateLimitTrailer || slices.Contains(r.TransferEncoding, "chunked")) {
+		h.Add("Trailer", rateLimitRemaining)
+		return true
+	}
+
+	lim.setRateLimitTrailer(h, key, path)
+
+	return false
+}
+
+// setRateLimitTrailer sets X-RateLimit-Remaining to tokens key that requested path has left, unless they are unknown or infinite.
+func (lim *limiter) setRateLimitTrailer(h http.Header, key, path string) {
+	remaining := lim.remaining(key, path)
+	if math.IsNaN(remaining) || math.IsInf(remaining, 1) {
+		return
+	}
+
+	h.Set(rateLimitRemaining, strconv.Itoa(int(max(0, math.Floor(remaining)))))
+}
@@ -0,0 +1,121 @@
+package limiter
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithRateLimitHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			l := New(RpsWithBurst(1, 2), WithRateLimitHeaders(), WithClock(&manualClock{now: time.Unix(0, 0)}))
+			defer l.Stop()
+
+			h := handler(l)
+			for _, tt := range []struct {
+				code      int
+				remaining string
+			}{
+				{http.StatusOK, "1"},
+				{http.StatusOK, "0"},
+				{http.StatusTooManyRequests, "0"},
+			} {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				assert.Equal(t, tt.code, rec.Code)
+				assert.Equal(t, "2", rec.Header().Get(rateLimitLimit))
+				assert.Equal(t, tt.remaining, rec.Header().Get(rateLimitRemaining))
+			}
+		})
+	}
+}
+
+func TestWithRateLimitHeadersUnknownRemaining(t *testing.T) {
+	l := New(WithStore(&stubStore{allowed: true, calls: map[string]int{}}), WithRateLimitHeaders())
+	defer l.Stop()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(XOFF, "1.1.1.1")
+	rec := httptest.NewRecorder()
+	Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+
+	assert.NotEmpty(t, rec.Header().Get(rateLimitLimit))
+	assert.Empty(t, rec.Header().Values(rateLimitRemaining))
+}
+
+// unsized hides length of body, so client sends it chunked.
+type unsized struct {
+	io.Reader
+}
+
+func TestRateLimitTrailer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	stream := func(w http.ResponseWriter) {
+		for i := 0; i < 3; i++ {
+			_, _ = w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+		}
+	}
+
+	handlers := middlewares(func(w http.ResponseWriter, r *http.Request) { stream(w) })
+
+	tests := []struct {
+		name    string
+		opts    []option
+		chunked bool
+	}{
+		{name: "option", opts: []option{RateLimitTrailer()}},
+		{name: "chunked request", chunked: true},
+	}
+
+	for name, handler := range handlers {
+		for _, tt := range tests {
+			t.Run(name+"_"+tt.name, func(t *testing.T) {
+				l := New(append([]option{RpsWithBurst(1, 3), WithRateLimitHeaders(), WithClock(&manualClock{now: time.Unix(0, 0)})}, tt.opts...)...)
+				defer l.Stop()
+
+				srv := httptest.NewServer(handler(l))
+				defer srv.Close()
+
+				var body io.Reader
+				if tt.chunked {
+					body = unsized{strings.NewReader("upload")}
+				}
+
+				req, err := http.NewRequest(http.MethodPost, srv.URL, body)
+				require.NoError(t, err)
+				req.Header.Set(XOFF, "1.1.1.1")
+
+				resp, err := http.DefaultClient.Do(req)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+				assert.Equal(t, "3", resp.Header.Get(rateLimitLimit))
+				assert.Empty(t, resp.Header.Get(rateLimitRemaining))
+
+				b, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, "chunkchunkchunk", string(b))
+				assert.Equal(t, "2", resp.Trailer.Get(rateLimitRemaining))
+			})
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package limiter
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/eldarthepro/limiter/internal/hook"
+)
+
+func init() {
+	hook.From = func(l any) hook.Limiter { return hooked{l.(Limiter)} }
+	hook.RetryAfterSeconds = retryAfterSeconds
+	hook.OrRemoteAddr = orRemoteAddr
+}
+
+// hooked gives middlewares in subpackages access to unexported methods of Limiter.
+type hooked struct {
+	l Limiter
+}
+
+func (h hooked) Skipped(path string) bool {
+	return h.l.skipped(path)
+}
+
+func (h hooked) Route(method, path string) hook.Limiter {
+	return hooked{h.l.route(method, path)}
+}
+
+func (h hooked) WebSocket(header func(string) string) (hook.Limiter, bool) {
+	l, limited := h.l.webSocket(header)
+
+	return hooked{l}, limited
+}
+
+func (h hooked) MissingIPHeader(header func(string) string) bool {
+	return h.l.missingIPHeader(header)
+}
+
+func (h hooked) IPHeaderStatus() int {
+	return h.l.ipHeaderStatus()
+}
+
+func (h hooked) Key(r *http.Request, remoteIP func() string) string {
+	return h.l.key(r, remoteIP)
+}
+
+func (h hooked) RequestIP(r *http.Request, remoteIP func() string) string {
+	return h.l.requestIP(r, remoteIP)
+}
+
+func (h hooked) IPHeaderValue(header func(string) string) string {
+	return h.l.ipHeaderValue(header)
+}
+
+func (h hooked) ClientIP(header string, remoteIP func() string) string {
+	return h.l.clientIP(header, remoteIP)
+}
+
+func (h hooked) Blocked(ip string) bool {
+	return h.l.blocked(ip)
+}
+
+func (h hooked) BlockStatus() int {
+	return h.l.blockStatus()
+}
+
+func (h hooked) WhiteListed(key string) bool {
+	return h.l.whiteListed(key)
+}
+
+func (h hooked) RequestWhitelisted(r *http.Request) bool {
+	return h.l.requestWhitelisted(r)
+}
+
+func (h hooked) Bypassed(header func(string) string) bool {
+	return h.l.bypassed(header)
+}
+
+func (h hooked) RequestKey(key string, r *http.Request) string {
+	return h.l.requestKey(key, r)
+}
+
+func (h hooked) Cost(r *http.Request) int {
+	return h.l.cost(r)
+}
+
+func (h hooked) AllowN(key, path string, n int) bool {
+	return h.l.allowN(key, path, n)
+}
+
+func (h hooked) AllowIdempotent(key, path string, n int, header func(string) string) (allowed, taken bool) {
+	return h.l.allowIdempotent(key, path, n, header)
+}
+
+func (h hooked) Decided(key, path string, r *http.Request, allowed bool) {
+	h.l.decided(key, path, r, allowed)
+}
+
+func (h hooked) SetRateLimitHeaders(header http.Header, key, path string, r *http.Request, allowed bool) bool {
+	return h.l.setRateLimitHeaders(header, key, path, r, allowed)
+}
+
+func (h hooked) SetRateLimitTrailer(header http.Header, key, path string) {
+	h.l.setRateLimitTrailer(header, key, path)
+}
+
+func (h hooked) Rejected(key string, r *http.Request) {
+	h.l.rejected(key, r)
+}
+
+func (h hooked) DryRun() bool {
+	return h.l.dryRun()
+}
+
+func (h hooked) RetryAfter(key, path string) time.Duration {
+	return h.l.retryAfter(key, path)
+}
+
+func (h hooked) RejectionHandler() http.Handler {
+	return h.l.rejectionHandler()
+}
+
+// Problem returns untyped nil without WithProblemDetails, so callers can compare result with nil.
+func (h hooked) Problem(retry time.Duration) any {
+	if p := h.l.problem(retry); p != nil {
+		return p
+	}
+
+	return nil
+}
+
+// Hint returns untyped nil without WithBackoffHint, so callers can compare result with nil.
+func (h hooked) Hint(retry time.Duration) any {
+	if b := h.l.hint(retry); b != nil {
+		return b
+	}
+
+	return nil
+}
+
+func (h hooked) RejectStatus() int {
+	return h.l.rejectStatus()
+}
+
+func (h hooked) RejectMessage() string {
+	return h.l.rejectMessage()
+}
+
+func (h hooked) Shed() (time.Duration, bool) {
+	return h.l.shed()
+}
+
+func (h hooked) GlobalStatus() int {
+	return h.l.globalStatus()
+}
+
+func (h hooked) Admit(key, charged, path string, n int, allowed, taken bool) (func(), bool, bool) {
+	return h.l.admit(key, charged, path, n, allowed, taken)
+}
+
+func (h hooked) PenalizesStatuses() bool {
+	return h.l.penalizesStatuses()
+}
+
+func (h hooked) PenalizeStatus(key, path string, status int) {
+	h.l.penalizeStatus(key, path, status)
+}
+
+func (h hooked) RefundCanceled(ctx context.Context, key, path string, n int) {
+	h.l.refundCanceled(ctx, key, path, n)
+}
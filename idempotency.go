@@ -0,0 +1,130 @@
+package limiter
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxIdempotencyKeys is number of idempotency keys remembered per key, see IdempotencyKey.
+const maxIdempotencyKeys = 16
+
+// idempotencyKeys holds values of idempotency header of allowed requests of a key, with times they expire at.
+type idempotencyKeys struct {
+	keys map[string]time.Time
+	sync.Mutex
+}
+
+// IdempotencyKey makes retries of allowed request, carrying the same value of header, such as Idempotency-Key, allowed without taking
+// tokens again for ttl after it. Only allowed requests are remembered, rejected ones took no tokens. Up to 16 values are kept per key,
+// the one expiring first is dropped for a new one. Retries are not counted in Stats. Used by Limit, GinLimit, echolimit.Limit and fiberlimit.Limit.
+func IdempotencyKey(header string, ttl time.Duration) option {
+	var errs []error
+
+	if header == "" {
+		errs = append(errs, invalidOption("empty idempotency header"))
+	}
+	if ttl <= 0 {
+		errs = append(errs, invalidOption("idempotency ttl %s is not positive", ttl))
+	}
+
+	if len(errs) > 0 {
+		return func(opts *limiterOptions) {
+			opts.errs = append(opts.errs, errs...)
+		}
+	}
+
+	return func(opts *limiterOptions) {
+		opts.idempotencyHeader = http.CanonicalHeaderKey(header)
+		opts.idempotencyTTL = ttl
+	}
+}
+
+// allowIdempotent is allowN that lets retry of allowed request with the same IdempotencyKey header value through without taking tokens.
+// Reports whether request is allowed and whether it took tokens, so retries are not refunded or penalized. Key and header value are
+// copied before they are remembered, fiber reuses memory of its strings after request.
+func (lim *limiter) allowIdempotent(key, path string, n int, header func(string) string) (allowed, taken bool) {
+	if lim.opts.idempotencyHeader == "" || key == "" {
+		ok := lim.allowN(key, path, n)
+		return ok, ok
+	}
+
+	id := header(lim.opts.idempotencyHeader)
+	if id == "" {
+		ok := lim.allowN(key, path, n)
+		return ok, ok
+	}
+
+	now := lim.opts.clock.Now()
+	v, ok := lim.idempotency.Load(key)
+	if !ok {
+		v, _ = lim.idempotency.LoadOrStore(strings.Clone(key), &idempotencyKeys{keys: make(map[string]time.Time)})
+	}
+	ks := v.(*idempotencyKeys)
+
+	ks.Lock()
+	expires, ok := ks.keys[id]
+	ks.Unlock()
+
+	if ok && now.Before(expires) {
+		return true, false
+	}
+
+	if !lim.allowN(key, path, n) {
+		return false, false
+	}
+
+	ks.Lock()
+	ks.add(strings.Clone(id), now.Add(lim.opts.idempotencyTTL))
+	ks.Unlock()
+
+	return true, true
+}
+
+// add remembers id until expires, dropping the one expiring first if there are too many of them. Must be called with lock held.
+func (ks *idempotencyKeys) add(id string, expires time.Time) {
+	if _, ok := ks.keys[id]; !ok && len(ks.keys) >= maxIdempotencyKeys {
+		var (
+			first string
+			at    time.Time
+		)
+
+		for k, e := range ks.keys {
+			if first == "" || e.Before(at) {
+				first, at = k, e
+			}
+		}
+
+		delete(ks.keys, first)
+	}
+
+	ks.keys[id] = expires
+}
+
+// cleanupIdempotency forgets expired idempotency keys and keys that have none left.
+func (lim *limiter) cleanupIdempotency() {
+	if lim.opts.idempotencyHeader == "" {
+		return
+	}
+
+	now := lim.opts.clock.Now()
+	lim.idempotency.Range(func(k, v any) bool {
+		ks := v.(*idempotencyKeys)
+
+		ks.Lock()
+		for id, expires := range ks.keys {
+			if !now.Before(expires) {
+				delete(ks.keys, id)
+			}
+		}
+		empty := len(ks.keys) == 0
+		ks.Unlock()
+
+		if empty {
+			lim.idempotency.CompareAndDelete(k, v)
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,98 @@
+package limiter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			c := &manualClock{now: time.Unix(0, 0)}
+			l := New(RpsWithBurst(1, 2), IdempotencyKey("Idempotency-Key", time.Minute), WithClock(c))
+			defer l.Stop()
+
+			h := handler(l)
+			do := func(id string) int {
+				req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				if id != "" {
+					req.Header.Set("Idempotency-Key", id)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				return rec.Code
+			}
+
+			// retries of the same request cost one token.
+			assert.Equal(t, http.StatusOK, do("a"))
+			assert.Equal(t, http.StatusOK, do("a"))
+			assert.Equal(t, http.StatusOK, do("a"))
+
+			assert.Equal(t, http.StatusOK, do(""))
+			assert.Equal(t, http.StatusTooManyRequests, do(""))
+			assert.Equal(t, http.StatusTooManyRequests, do("b"))
+			assert.Equal(t, http.StatusOK, do("a"))
+
+			// after ttl retry is charged again.
+			c.now = c.now.Add(time.Minute)
+			l.Cleanup()
+			assert.Equal(t, http.StatusOK, do("a"))
+			assert.Equal(t, http.StatusOK, do("a"))
+			assert.Equal(t, http.StatusOK, do("b"))
+			assert.Equal(t, http.StatusTooManyRequests, do("c"))
+		})
+	}
+}
+
+func TestIdempotencyKeysLimit(t *testing.T) {
+	start := time.Unix(0, 0)
+	ks := &idempotencyKeys{keys: make(map[string]time.Time)}
+
+	for i := 0; i < maxIdempotencyKeys+1; i++ {
+		ks.add(fmt.Sprint(i), start.Add(time.Duration(i)*time.Second))
+	}
+
+	assert.Len(t, ks.keys, maxIdempotencyKeys)
+	assert.NotContains(t, ks.keys, "0")
+	assert.Contains(t, ks.keys, fmt.Sprint(maxIdempotencyKeys))
+}
+
+func TestIdempotencyKeyCleanup(t *testing.T) {
+	c := &manualClock{now: time.Unix(0, 0)}
+	l := New(IdempotencyKey("Idempotency-Key", time.Minute), WithClock(c))
+	defer l.Stop()
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(XOFF, "1.1.1.1")
+	req.Header.Set("Idempotency-Key", "a")
+	Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+
+	_, ok := l.(*limiter).idempotency.Load("1.1.1.1")
+	assert.True(t, ok)
+
+	c.now = c.now.Add(time.Minute)
+	l.Cleanup()
+
+	_, ok = l.(*limiter).idempotency.Load("1.1.1.1")
+	assert.False(t, ok)
+}
+
+func TestIdempotencyKeyInvalid(t *testing.T) {
+	_, err := NewWithError(IdempotencyKey("", time.Minute))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+
+	_, err = NewWithError(IdempotencyKey("Idempotency-Key", 0))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
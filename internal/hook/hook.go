@@ -0,0 +1,63 @@
+// Package hook lets middlewares in subpackages of limiter use internals of limiter.Limiter, so framework dependencies stay out of
+// limiter package without making its internals public.
+package hook
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	RetryAfter  = "Retry-After"
+	ProblemJSON = "application/problem+json"
+)
+
+// Limiter mirrors unexported methods of limiter.Limiter that middlewares use.
+type Limiter interface {
+	Skipped(path string) bool
+	Route(method, path string) Limiter
+	WebSocket(header func(string) string) (Limiter, bool)
+	MissingIPHeader(header func(string) string) bool
+	IPHeaderStatus() int
+	Key(r *http.Request, remoteIP func() string) string
+	RequestIP(r *http.Request, remoteIP func() string) string
+	IPHeaderValue(header func(string) string) string
+	ClientIP(header string, remoteIP func() string) string
+	Blocked(ip string) bool
+	BlockStatus() int
+	WhiteListed(key string) bool
+	RequestWhitelisted(r *http.Request) bool
+	Bypassed(header func(string) string) bool
+	RequestKey(key string, r *http.Request) string
+	Cost(r *http.Request) int
+	AllowN(key, path string, n int) bool
+	AllowIdempotent(key, path string, n int, header func(string) string) (allowed, taken bool)
+	Decided(key, path string, r *http.Request, allowed bool)
+	SetRateLimitHeaders(h http.Header, key, path string, r *http.Request, allowed bool) bool
+	SetRateLimitTrailer(h http.Header, key, path string)
+	Rejected(key string, r *http.Request)
+	DryRun() bool
+	RetryAfter(key, path string) time.Duration
+	RejectionHandler() http.Handler
+	// Problem and Hint return nil unless WithProblemDetails or WithBackoffHint is set.
+	Problem(retry time.Duration) any
+	Hint(retry time.Duration) any
+	RejectStatus() int
+	RejectMessage() string
+	Shed() (time.Duration, bool)
+	GlobalStatus() int
+	Admit(key, charged, path string, n int, allowed, taken bool) (func(), bool, bool)
+	PenalizesStatuses() bool
+	PenalizeStatus(key, path string, status int)
+	RefundCanceled(ctx context.Context, key, path string, n int)
+}
+
+var (
+	// From returns Limiter of l, which has to be limiter.Limiter. Set by limiter package.
+	From func(l any) Limiter
+	// RetryAfterSeconds formats d as value of Retry-After header. Set by limiter package.
+	RetryAfterSeconds func(d time.Duration) string
+	// OrRemoteAddr returns function returning ip, or host of r.RemoteAddr if ip is empty. Set by limiter package.
+	OrRemoteAddr func(ip func() string, r *http.Request) func() string
+)
@@ -0,0 +1,36 @@
+package limiter
+
+import "net/http"
+
+// RequireIPHeader rejects requests that have none of headers set by IPHeader or IPHeaders, instead of limiting them by remote
+// address, for example behind an edge proxy that always sets the header, so requests without it did not come through it.
+// They get http 400, or status set by IPHeaderStatus, before block list and whitelist are checked. Paths set by SkipPaths are not checked.
+func RequireIPHeader() option {
+	return func(opts *limiterOptions) {
+		opts.requireIPHeader = true
+	}
+}
+
+// IPHeaderStatus sets http status returned to requests rejected by RequireIPHeader, 400 by default.
+func IPHeaderStatus(code int) option {
+	var errs []error
+
+	if !validStatus(code) {
+		errs = append(errs, invalidOption("invalid ip header status %d", code))
+		code = http.StatusBadRequest
+	}
+
+	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.ipHeaderStatus = code
+	}
+}
+
+// missingIPHeader reports whether request must be rejected by RequireIPHeader, get returns header value by name.
+func (lim *limiter) missingIPHeader(get func(string) string) bool {
+	return lim.opts.requireIPHeader && lim.ipHeaderValue(get) == ""
+}
+
+func (lim *limiter) ipHeaderStatus() int {
+	return lim.opts.ipHeaderStatus
+}
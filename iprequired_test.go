@@ -0,0 +1,74 @@
+package limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireIPHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	tests := []struct {
+		name     string
+		opts     []option
+		headers  map[string]string
+		expected int
+	}{
+		{name: "present", headers: map[string]string{XOFF: "1.1.1.1"}, expected: http.StatusOK},
+		{name: "missing", expected: http.StatusBadRequest},
+		{name: "status", opts: []option{IPHeaderStatus(http.StatusForbidden)}, expected: http.StatusForbidden},
+		{name: "whitelisted remote address", opts: []option{AllowedIPs("192.0.2.1")}, expected: http.StatusBadRequest},
+		{
+			name:     "second of headers",
+			opts:     []option{IPHeaders("X-Forwarded-For", "X-Real-IP")},
+			headers:  map[string]string{"X-Real-IP": "1.1.1.1"},
+			expected: http.StatusOK,
+		},
+		{
+			name:     "none of headers",
+			opts:     []option{IPHeaders("X-Forwarded-For", "X-Real-IP")},
+			headers:  map[string]string{XOFF: "1.1.1.1"},
+			expected: http.StatusBadRequest,
+		},
+		{name: "skipped path", opts: []option{SkipPaths("/test")}, expected: http.StatusOK},
+	}
+
+	for name, handler := range handlers {
+		for _, tt := range tests {
+			t.Run(name+"_"+tt.name, func(t *testing.T) {
+				l := New(append([]option{RequireIPHeader()}, tt.opts...)...)
+				defer l.Stop()
+
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				for k, v := range tt.headers {
+					req.Header.Set(k, v)
+				}
+				rec := httptest.NewRecorder()
+				handler(l).ServeHTTP(rec, req)
+
+				assert.Equal(t, tt.expected, rec.Code)
+			})
+		}
+	}
+}
+
+func TestRequireIPHeaderDisabled(t *testing.T) {
+	l := New()
+	defer l.Stop()
+
+	rec := httptest.NewRecorder()
+	Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestInvalidIPHeaderStatus(t *testing.T) {
+	_, err := NewWithError(IPHeaderStatus(42))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
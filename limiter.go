@@ -1,8 +1,24 @@
 package limiter
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"math/rand/v2"
 	"net"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,146 +28,586 @@ import (
 )
 
 // Limit attempts to extract ip using header from options,
-// if fails, uses http RemoreAddr(). If limit is reached,
-// will respond with http 429 and "Too many requests" message
+// if fails, uses http RemoreAddr(). KeyFunc from options
+// takes precedence over ip when set, CostFunc sets tokens request takes. Blocked ips get http 403,
+// or status from options. If limit is reached,
+// calls OnReject from options and will respond with RejectStatus and RejectMessage, http 429 and "Too many requests" by default,
+// or with RejectionHandler from options if it is set,
+// Retry-After header tells client when to come back. Requests over GlobalLimit get http 503, or status from options
 func Limit(l Limiter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip := r.Header.Get(l.ipHeader())
+			if l.skipped(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-			if ip != "" {
-				ip = strings.Split(ip, ",")[0]
-				ip = strings.TrimSpace(ip)
+			l, limited := l.route(r.Method, r.URL.Path).webSocket(r.Header.Get)
+			if l.missingIPHeader(r.Header.Get) {
+				http.Error(w, http.StatusText(l.ipHeaderStatus()), l.ipHeaderStatus())
+				return
 			}
 
-			if ip == "" {
-				host, _, err := net.SplitHostPort(r.RemoteAddr)
-				if err == nil {
-					ip = host
-				} else {
-					ip = r.RemoteAddr
-				}
+			key, ip := l.key(r, remoteAddrIP(r)), l.requestIP(r, remoteAddrIP(r))
+			r = r.WithContext(context.WithValue(r.Context(), ClientIPKey, ip))
+
+			if l.blocked(ip) {
+				http.Error(w, http.StatusText(l.blockStatus()), l.blockStatus())
+				return
 			}
 
-			if l.whiteListed(ip) {
+			if !limited || l.whiteListed(key) || l.requestWhitelisted(r) {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			if !l.visitor(ip).Allow() {
-				http.Error(w, tooManyReqMsg, http.StatusTooManyRequests)
+			charged, cost := l.requestKey(key, r), l.cost(r)
+			allowed, taken := l.allowIdempotent(charged, r.URL.Path, cost, r.Header.Get)
+			release, allowed, taken := l.admit(key, charged, r.URL.Path, cost, allowed, taken)
+			defer release()
+
+			l.decided(charged, r.URL.Path, r, allowed)
+			trailer := l.setRateLimitHeaders(w.Header(), charged, r.URL.Path, r, allowed)
+			if !allowed {
+				l.rejected(key, r)
+				if l.dryRun() {
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				retry := l.retryAfter(charged, r.URL.Path)
+				w.Header().Set(retryAfter, retryAfterSeconds(retry))
+				l.reject(w, r, retry)
+				return
+			}
+
+			if retry, shed := l.shed(); shed && !l.dryRun() {
+				w.Header().Set(retryAfter, retryAfterSeconds(retry))
+				http.Error(w, http.StatusText(l.globalStatus()), l.globalStatus())
 				return
 			}
 
-			next.ServeHTTP(w, r)
+			if l.penalizesStatuses() && taken {
+				rec := &statusRecorder{ResponseWriter: w}
+				next.ServeHTTP(rec, r)
+				l.penalizeStatus(charged, r.URL.Path, rec.code())
+			} else {
+				next.ServeHTTP(w, r)
+			}
+
+			if taken {
+				l.refundCanceled(r.Context(), charged, r.URL.Path, cost)
+			}
+
+			if trailer {
+				l.setRateLimitTrailer(w.Header(), charged, r.URL.Path)
+			}
 		})
 	}
 }
 
+// LimitFunc is Limit for a single handler func.
+func LimitFunc(l Limiter, next http.HandlerFunc) http.HandlerFunc {
+	return Limit(l)(next).ServeHTTP
+}
+
+// Wrap is Limit for a single handler.
+func Wrap(l Limiter, h http.Handler) http.Handler {
+	return Limit(l)(h)
+}
+
+// LimiterHandler is http.Handler that limits requests with Limiter like Limit and serves allowed ones with Next, for routers that compose
+// handlers rather than middlewares. Nil Next is http.DefaultServeMux, like for http.Server.
+type LimiterHandler struct {
+	Limiter Limiter
+	Next    http.Handler
+}
+
+func (h *LimiterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	next := h.Next
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+
+	Limit(h.Limiter)(next).ServeHTTP(w, r)
+}
+
+// Middleware creates limiter with opts and returns Limit middleware for it,
+// returned limiter should be stopped when middleware is not needed anymore
+func Middleware(opts ...option) (func(http.Handler) http.Handler, Limiter) {
+	l := New(opts...)
+	return Limit(l), l
+}
+
 // GinLimit attempts to extract ip using header from options,
-// if fails, uses gin clientIP(). If limit is reached,
-// will respond with http 429 and "Too many requests" message
+// if fails, uses gin clientIP(). GinKeyFunc and KeyFunc from
+// options take precedence over ip when set, CostFunc sets tokens request takes. Blocked ips get http 403,
+// or status from options. If limit is reached,
+// calls GinOnReject or OnReject from options and will respond with RejectStatus and RejectMessage, http 429 and "Too many requests" by default,
+// or with GinRejectionHandler or RejectionHandler from options if one is set,
+// Retry-After header tells client when to come back. Requests over GlobalLimit get http 503, or status from options
 func GinLimit(l Limiter) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ip := c.GetHeader(l.ipHeader())
+		if l.skipped(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
 
-		if ip != "" {
-			ip = strings.Split(ip, ",")[0]
-			ip = strings.TrimSpace(ip)
+		l, limited := l.route(c.Request.Method, c.Request.URL.Path).webSocket(c.GetHeader)
+		if l.missingIPHeader(c.GetHeader) {
+			c.String(l.ipHeaderStatus(), http.StatusText(l.ipHeaderStatus()))
+			c.Abort()
+			return
 		}
 
-		if ip == "" {
-			ip = c.ClientIP()
+		key := l.ginKey(c)
+		ip := l.requestIP(c.Request, orRemoteAddr(c.ClientIP, c.Request))
+		c.Set(GinClientIPKey, ip)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), ClientIPKey, ip))
+
+		if l.blocked(ip) {
+			c.String(l.blockStatus(), http.StatusText(l.blockStatus()))
+			c.Abort()
+			return
 		}
 
-		if l.whiteListed(ip) {
+		if !limited || l.whiteListed(key) || l.ginWhitelisted(c) {
 			c.Next()
 			return
 		}
 
-		if !l.visitor(ip).Allow() {
-			//	logger.Error(fmt.Sprintf(notAllowedFmt, ip, c.GetString(domain.XFwdForHeader), ip, c.ClientIP()))
-			c.String(http.StatusTooManyRequests, tooManyReqMsg)
+		charged, cost := l.requestKey(key, c.Request), l.cost(c.Request)
+		allowed, taken := l.allowIdempotent(charged, c.Request.URL.Path, cost, c.GetHeader)
+		release, allowed, taken := l.admit(key, charged, c.Request.URL.Path, cost, allowed, taken)
+		defer release()
+
+		l.decided(charged, c.Request.URL.Path, c.Request, allowed)
+		trailer := l.setRateLimitHeaders(c.Writer.Header(), charged, c.Request.URL.Path, c.Request, allowed)
+		if !allowed {
+			l.ginRejected(key, c)
+			if l.dryRun() {
+				c.Next()
+				return
+			}
+
+			retry := l.retryAfter(charged, c.Request.URL.Path)
+			c.Header(retryAfter, retryAfterSeconds(retry))
+			l.ginReject(c, retry)
+			c.Abort()
+			return
+		}
+
+		if retry, shed := l.shed(); shed && !l.dryRun() {
+			c.Header(retryAfter, retryAfterSeconds(retry))
+			c.String(l.globalStatus(), http.StatusText(l.globalStatus()))
 			c.Abort()
 			return
 		}
 
 		c.Next()
+		if taken {
+			l.penalizeStatus(charged, c.Request.URL.Path, c.Writer.Status())
+			l.refundCanceled(c.Request.Context(), charged, c.Request.URL.Path, cost)
+		}
+
+		if trailer {
+			l.setRateLimitTrailer(c.Writer.Header(), charged, c.Request.URL.Path)
+		}
 	}
 }
 
 // Returns new instance of ratelimiter. If no opts are provided uses default settings. RPS = 10, BURST = 20, ttl and cleanup 5 minutes.
+// Invalid options are replaced with defaults, use NewWithError to get them reported.
 func New(opts ...option) Limiter {
+	lim, _ := newLimiter(opts...)
+
+	lim.start()
+
+	return lim
+}
+
+// NewWithError is the same as New, but returns error wrapping ErrInvalidOption for every invalid option instead of replacing it with default.
+func NewWithError(opts ...option) (Limiter, error) {
+	lim, err := newLimiter(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	lim.start()
+
+	return lim, nil
+}
+
+// newLimiter applies opts and returns limiter without starting cleanup, along with errors of invalid options.
+func newLimiter(opts ...option) (*limiter, error) {
 	o := defautlOptions()
 
 	for _, opt := range opts {
 		opt(o)
 	}
 
+	if o.store == nil && o.maxVisitors > 0 {
+		o.store = newBoundedStore(o.ttl, o.algo, o.maxVisitors)
+	}
+
+	if o.store == nil {
+		o.store = newMemoryStore(o.ttl, o.algo)
+	}
+
+	if cs, ok := o.store.(*cachedStore); ok {
+		cs.clock = o.clock
+	}
+
+	if ms, ok := o.store.(*memoryStore); ok {
+		ms.clock = o.clock
+		ms.refreshAllowed = o.refreshAllowed
+		ms.warmUp = o.warmUp
+
+		if len(o.tiers) > 1 {
+			for _, t := range o.tiers[1:] {
+				ms.tiers = append(ms.tiers, rateLimit{rate.Limit(t.Rps), t.Burst})
+			}
+		}
+	}
+
 	lim := &limiter{
-		storage: make(map[string]*record),
-		opts:    o,
-		stop:    make(chan struct{}),
-		limit:   rate.Limit(float64(o.requests) / o.period.Seconds()),
+		store: o.store,
+		opts:  o,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		grown: make(chan struct{}, 1),
+
+		overrides: make(map[string]rateLimit, len(o.tenantLimits)),
+		inflight:  make(map[string]int),
+		limit:     rate.Limit(float64(o.requests) / o.period.Seconds()),
+		burst:     o.burst,
 	}
 
-	go lim.scheduleCleanup()
+	if ms, ok := o.store.(*memoryStore); ok && o.cleanupSize > 0 && !o.noAutoCleanup {
+		ms.growLimit, ms.onGrow = int64(o.cleanupSize), lim.requestCleanup
+		ms.rearmGrow()
+	}
 
-	return lim
+	maps.Copy(lim.overrides, o.tenantLimits)
+
+	if o.global != nil {
+		lim.global = rate.NewLimiter(o.global.limit, o.global.burst)
+	}
+
+	if o.refill != nil {
+		lim.limit = *o.refill
+	}
+
+	if len(o.tiers) > 0 {
+		lim.limit, lim.burst = rate.Limit(o.tiers[0].Rps), o.tiers[0].Burst
+	}
+
+	if o.logger != nil && lim.limit != rate.Inf && float64(lim.burst) < float64(lim.limit) {
+		o.logger.Warn("limiter burst is smaller than rate, requests are limited by burst", slog.Float64("limit", float64(lim.limit)), slog.Int("burst", lim.burst))
+	}
+
+	if o.observer != nil {
+		lim.metrics = newMetrics(o.observer)
+	}
+
+	return lim, errors.Join(o.errs...)
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: retry after %s", ErrRateLimited, e.RetryAfter)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// invalidOption returns error wrapping ErrInvalidOption with description of the problem.
+func invalidOption(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrInvalidOption}, args...)...)
+}
+
+// Allow reports whether one more request identified by key is allowed, for use outside of http, for example with background jobs keyed by tenant.
+// Blocked keys are never allowed and whitelisted ones always are, overrides and GlobalLimit are applied like in middlewares.
+func (lim *limiter) Allow(key string) bool {
+	if lim.blocked(key) {
+		return false
+	}
+
+	if lim.whiteListed(key) {
+		return true
+	}
+
+	if !lim.allow(key) {
+		return false
+	}
+
+	_, shed := lim.shed()
+
+	return !shed
+}
+
+// RetryAfter returns how long request identified by key has to wait until it is allowed, without taking a token, or zero if it would
+// be allowed now. Keys that will never be allowed get period, like in Retry-After header. Stores that cant tell, and whitelisted keys,
+// get zero. Blocked keys are not limited by rate, so their time is computed like for any other key.
+func (lim *limiter) RetryAfter(key string) time.Duration {
+	if lim.whiteListed(key) {
+		return 0
+	}
+
+	d, ok := lim.store.(delayer)
+	if !ok {
+		return 0
+	}
+
+	limit, burst := lim.rateFor(key)
+	if delay, ok := d.Delay(lim.storeKey(key, ""), limit, burst); ok {
+		return delay
+	}
+
+	return lim.opts.period
+}
+
+// allow reports whether request from ip can be served. Stores that fail to make a decision return the one dictated by their failure policy, so the error is not checked here.
+func (lim *limiter) allow(ip string) bool {
+	return lim.allowN(ip, "", 1)
+}
+
+// allowN takes n tokens for ip requesting path at once. Stores that can not take several tokens are charged one.
+// Empty ip is rejected with RejectMissingKey, otherwise decided by WhenNoKey policy.
+func (lim *limiter) allowN(ip, path string, n int) bool {
+	limit, burst := lim.rateFor(ip)
+	key := lim.storeKey(ip, path)
+
+	var ok bool
+	if ip == "" && lim.opts.rejectMissingKey {
+		ok = false
+	} else if ip == "" && lim.opts.noKey != SharedBucket {
+		ok = lim.opts.noKey == FailOpen
+	} else {
+		start := lim.metrics.startDecision()
+		if m, is := lim.store.(multiAllower); is && n != 1 {
+			ok, _ = m.AllowN(key, limit, burst, n)
+		} else {
+			ok, _ = lim.store.Allow(key, limit, burst)
+		}
+		lim.metrics.observeDecision(start)
+	}
+
+	if p, is := lim.store.(penalizer); is && !ok && lim.opts.penalty > 0 && ip != "" {
+		p.Penalize(key, limit, burst, lim.opts.penalty)
+	}
+	lim.metrics.observe(ok)
+
+	if ok {
+		lim.allowedTotal.Add(1)
+	} else {
+		lim.rejectedTotal.Add(1)
+	}
+
+	return ok
+}
+
+// retryAfter returns how long rejected ip has to wait for the next request to path. If store can't tell, or request will never be allowed, period is used.
+func (lim *limiter) retryAfter(ip, path string) time.Duration {
+	if d, ok := lim.store.(delayer); ok {
+		limit, burst := lim.rateFor(ip)
+		if delay, ok := d.Delay(lim.storeKey(ip, path), limit, burst); ok {
+			return delay
+		}
+	}
+
+	return lim.opts.period
+}
+
+// storeKey returns key visitor is stored by, which is subnet of ip key with KeyBySubnet and includes path when WithPathScope is set.
+// Class of key set by ClassifierFunc is kept. With HashKeys the result is hashed.
+func (lim *limiter) storeKey(key, path string) string {
+	if class, k := splitClass(key); class != "" {
+		key = class + classSep + lim.subnetKey(k)
+	} else {
+		key = lim.subnetKey(key)
+	}
+
+	if lim.opts.pathScope && path != "" {
+		key += " " + path
+	}
+
+	return lim.hashKey(key)
+}
+
+// HashKeys makes limiter store HMAC-SHA256 of keys with salt, truncated to 128 bits, instead of keys themselves, so client ips can't
+// be read from visitor records or shared store. Whitelists, block lists and overrides still match raw keys, so do short lived caches,
+// such as the ones of WithLimitFunc and RegionFunc. Limiters sharing a store must use the same salt.
+func HashKeys(salt string) option {
+	return func(opts *limiterOptions) {
+		opts.hashSalt, opts.hashKeys = []byte(salt), true
+	}
+}
+
+// hashKey returns hex encoded hash of key with HashKeys, or key as is.
+func (lim *limiter) hashKey(key string) string {
+	if !lim.opts.hashKeys {
+		return key
+	}
+
+	h := hmac.New(sha256.New, lim.opts.hashSalt)
+	h.Write([]byte(key))
+
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Limit returns limit visitors' buckets are refilled at, in tokens per second, and their burst, as configured by options or changed
+// by SetLimit. Overrides and limiters set by LimitPath and LimitMethod are not taken into account.
+func (lim *limiter) Limit() (rate.Limit, int) {
+	return lim.rate()
+}
+
+// rate returns current limit and burst.
+func (lim *limiter) rate() (rate.Limit, int) {
+	lim.RLock()
+	defer lim.RUnlock()
+
+	return lim.limit, lim.burst
 }
 
-// visitor lloks up entry in storage and returns *rate.Limiter, updating lastSeen field. Doesnt check if string is empty, so will return same updated limiter for all empty ip visitors.
-func (lim *limiter) visitor(ip string) *rate.Limiter {
+// rateFor returns limit and burst for key, taking overrides, LimitFunc and class limits into account.
+func (lim *limiter) rateFor(key string) (rate.Limit, int) {
+	class, key := splitClass(key)
+
 	lim.RLock()
-	v, e := lim.storage[ip]
+	o, ok := lim.overrides[key]
+	limit, burst := lim.limit, lim.burst
 	lim.RUnlock()
 
-	if !e {
-		l := rate.NewLimiter(lim.limit, lim.opts.burst)
+	if ok {
+		return o.limit, o.burst
+	}
+
+	if l, b, ok := lim.dynamicRate(key); ok {
+		return l, b
+	}
+
+	if l, b, ok := lim.classRate(class); ok {
+		return l, b
+	}
+
+	return limit, burst
+}
+
+// Override sets rps and burst for key, instead of the ones limiter was created with. Override is kept until RemoveOverride is called,
+// even if visitor is removed by cleanup. Negative values are replaced with current defaults.
+func (lim *limiter) Override(key string, rps, burst int) {
+	lim.Lock()
+	defer lim.Unlock()
+
+	o := rateLimit{limit: rate.Limit(rps), burst: burst}
+	if rps < 0 {
+		o.limit = lim.limit
+	}
+	if burst < 0 {
+		o.burst = lim.burst
+	}
+
+	lim.overrides[key] = o
+}
+
+// RemoveOverride makes key use limiter defaults again.
+func (lim *limiter) RemoveOverride(key string) {
+	lim.Lock()
+	defer lim.Unlock()
+
+	delete(lim.overrides, key)
+}
+
+// SetLimit changes rps and burst for new and already seen visitors, keeping their state. Overrides are not changed. Negative values are ignored.
+func (lim *limiter) SetLimit(rps, burst int) {
+	lim.Lock()
+	if rps >= 0 {
+		lim.limit = rate.Limit(rps)
+	}
+	if burst >= 0 {
+		lim.burst = burst
+	}
+	limit, b := lim.limit, lim.burst
+	lim.Unlock()
 
-		lim.Lock()
-		lim.storage[ip] = &record{
-			lastSeen: time.Now(),
-			limiter:  l,
+	if r, ok := lim.store.(reconfigurer); ok {
+		r.SetLimit(limit, b)
+	}
+}
+
+// Reset forgets key, along with its budgets in classes of ClassLimits, in limiter and limiters set by LimitPath and LimitMethod, so its next request starts with full burst.
+// Does nothing for stores that can not forget visitors. Safe to call concurrently.
+func (lim *limiter) Reset(key string) {
+	if r, ok := lim.store.(resetter); ok {
+		r.Reset(lim.storeKey(key, ""))
+		for class := range lim.opts.classLimits {
+			r.Reset(lim.storeKey(class+classSep+key, ""))
 		}
-		lim.Unlock()
+	}
 
-		return l
+	for _, l := range lim.children() {
+		l.Reset(key)
+	}
+}
+
+// ResetAll forgets every visitor like Reset. Safe to call concurrently.
+func (lim *limiter) ResetAll() {
+	if r, ok := lim.store.(resetter); ok {
+		r.ResetAll()
 	}
 
-	if v != nil {
-		lim.Lock()
-		v.lastSeen = time.Now()
-		lim.Unlock()
+	for _, l := range lim.children() {
+		l.ResetAll()
 	}
+}
 
-	return v.limiter
+// retryAfterSeconds formats delay as Retry-After header value, rounding up to whole seconds.
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(d.Seconds()))))
 }
 
 func defautlOptions() *limiterOptions {
 	return &limiterOptions{
-		ttl:           defaultTTL,
-		requests:      defaultRps,
-		burst:         defaultBurst,
-		period:        defaultPeriod,
-		cleanupFreq:   defaultCleanupFrequency,
-		ipHeader:      XOFF,
-		allowedPrefix: []string{},
-		allowedIPs:    make(map[string]struct{}),
+		ttl:            defaultTTL,
+		requests:       defaultRps,
+		burst:          defaultBurst,
+		period:         defaultPeriod,
+		cleanupFreq:    defaultCleanupFrequency,
+		ipHeaders:      []string{XOFF},
+		clock:          realClock{},
+		whitelistCache: defaultWhitelistCache,
+		allowedPrefix:  []string{},
+		allowedIPs:     make(map[string]struct{}),
+		skipPaths:      make(map[string]struct{}),
+		blockedIPs:     make(map[string]struct{}),
+		blockStatus:    http.StatusForbidden,
+		ipHeaderStatus: http.StatusBadRequest,
+		rejectStatus:   http.StatusTooManyRequests,
+		globalStatus:   http.StatusServiceUnavailable,
+		rejectMessage:  tooManyReqMsg,
+		routes:         make(map[string]Limiter),
+		methods:        make(map[string]Limiter),
 	}
 }
 
-// RpsWithBurst sets custom rps and burst values. If burst is zero, all events will be blocked, unless rps is inf. If burst < rps requests will be limited by burst.
+// RpsWithBurst sets custom rps and burst values. If burst is zero, all events will be blocked, unless rps is inf. If burst < rps requests will be limited by burst,
+// which is logged as a warning to logger set by WithLogger.
 func RpsWithBurst(rps, burst int) option {
+	var errs []error
+
 	if rps < 0 {
+		errs = append(errs, invalidOption("negative rps %d", rps))
 		rps = defaultRps
 	}
 
 	if burst < 0 {
+		errs = append(errs, invalidOption("negative burst %d", burst))
 		burst = defaultRps
 	}
 
 	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
 		opts.requests = rps
 		opts.burst = burst
 	}
@@ -159,98 +615,760 @@ func RpsWithBurst(rps, burst int) option {
 
 // Rps sets allowed rps, burst will be disabled
 func Rps(rps int) option {
+	var errs []error
+
 	if rps < 0 {
+		errs = append(errs, invalidOption("negative rps %d", rps))
 		rps = defaultRps
 	}
 
 	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
 		opts.requests = rps
 		opts.burst = rps
 	}
 }
 
 func Burst(burst int) option {
+	var errs []error
+
 	if burst < 0 {
+		errs = append(errs, invalidOption("negative burst %d", burst))
 		burst = defaultBurst
 	}
 
 	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.burst = burst
+	}
+}
+
+// LimitWithBurst sets limit buckets are refilled at, in tokens per second, independently of burst they hold, taking precedence over
+// Rps and Period. For example limit 0.5 with burst 30 allows spikes of 30 requests, but only one request per 2 seconds on average.
+// Sustained rate is limit, burst is how many requests can be made at once after bucket is full, which takes burst/limit seconds.
+func LimitWithBurst(limit rate.Limit, burst int) option {
+	var errs []error
+
+	if limit < 0 || math.IsNaN(float64(limit)) {
+		errs = append(errs, invalidOption("invalid limit %v", limit))
+	}
+
+	if burst < 0 {
+		errs = append(errs, invalidOption("negative burst %d", burst))
+	}
+
+	return func(opts *limiterOptions) {
+		if len(errs) > 0 {
+			opts.errs = append(opts.errs, errs...)
+			return
+		}
+
+		opts.refill = &limit
 		opts.burst = burst
 	}
 }
 
-// Period sets allowed period when rps is smaller than 1. For example 1 request per 5 seconds. In most cases set burst to 1.
+// Period sets allowed period when rps is smaller than 1. For example 1 request per 5 seconds. In most cases set burst to 1, or use EveryN or OncePer,
+// which set it.
 func Period(requests int, period time.Duration) option {
+	var errs []error
+
 	if period < 0 {
+		errs = append(errs, invalidOption("negative period %s", period))
 		period = defaultPeriod
 	}
 	if requests < 0 {
+		errs = append(errs, invalidOption("negative requests %d", requests))
 		requests = 0
 	}
 
 	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
 		opts.period = period
 		opts.customPeriod = true
 		opts.requests = requests
 	}
 }
 
+// EveryN allows n requests per duration d, setting rate and burst in one call: visitor can make n requests at once, then one more
+// every d / n. For example EveryN(3, time.Minute) allows 3 requests right away and one every 20 seconds after them.
+func EveryN(n int, d time.Duration) option {
+	var errs []error
+
+	if d <= 0 {
+		errs = append(errs, invalidOption("duration %s is not positive", d))
+		d = defaultPeriod
+	}
+	if n < 0 {
+		errs = append(errs, invalidOption("negative requests %d", n))
+		n = 0
+	}
+
+	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.period = d
+		opts.customPeriod = true
+		opts.requests = n
+		opts.burst = n
+	}
+}
+
+// OncePer allows one request per duration d, it is EveryN(1, d).
+func OncePer(d time.Duration) option {
+	return EveryN(1, d)
+}
+
 // CleanupFrequency sets how often to cleanup storage.
 func CleanupFrequency(cf time.Duration) option {
+	var errs []error
+
 	if cf <= 0 {
+		errs = append(errs, invalidOption("cleanup frequency %s is not positive", cf))
 		cf = defaultCleanupFrequency
 	}
 
 	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
 		opts.cleanupFreq = cf
 	}
 }
 
+// WithoutAutoCleanup makes New start no cleanup routine, for tests and short lived processes such as serverless functions.
+// Expired visitors are then removed only by Cleanup, CleanupWhenLargerThan has no effect. Stop returns right away.
+func WithoutAutoCleanup() option {
+	return func(opts *limiterOptions) {
+		opts.noAutoCleanup = true
+	}
+}
+
+// WarmUp makes new visitors start with fewer tokens than burst, short of the ones their limit refills in d, so they get full burst only
+// after d, instead of right away. With d long enough to refill whole burst they start with one token. Window algorithms give tokens back
+// when the window moves past visitor's first request. Applied by in-memory store only.
+func WarmUp(d time.Duration) option {
+	var errs []error
+
+	if d < 0 {
+		errs = append(errs, invalidOption("negative warm up %s", d))
+		d = 0
+	}
+
+	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.warmUp = d
+	}
+}
+
+// RefreshOnlyWhenAllowed makes only allowed requests extend visitor's RecordTTL, so record of a client that was rejected until it stopped
+// expires RecordTTL after its last allowed request, instead of being kept alive by rejected ones. Has no effect when store is set with WithStore.
+func RefreshOnlyWhenAllowed() option {
+	return func(opts *limiterOptions) {
+		opts.refreshAllowed = true
+	}
+}
+
+// MaxVisitors caps number of visitors in-memory store keeps, so rotating ips can not grow it until the next cleanup.
+// When store is full, the least recently seen visitor is evicted before a new one is added. Visitors are split between shards
+// and eviction picks the oldest visitor of a shard, so cap is kept within number of shards. Has no effect when store is set with WithStore.
+func MaxVisitors(n int) option {
+	var errs []error
+
+	if n < 0 {
+		errs = append(errs, invalidOption("negative max visitors %d", n))
+		n = 0
+	}
+
+	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.maxVisitors = n
+	}
+}
+
+// CleanupWhenLargerThan makes in-memory store request cleanup as soon as it holds more than n visitors, in addition to periodic one.
+// Cleanup runs in the cleanup routine, so requests do not wait for it. If it leaves store larger than n, next one is requested only
+// after store grows by another quarter, so fresh visitors dont trigger a cleanup each. Has no effect when store is set with WithStore.
+func CleanupWhenLargerThan(n int) option {
+	var errs []error
+
+	if n < 0 {
+		errs = append(errs, invalidOption("negative cleanup size %d", n))
+		n = 0
+	}
+
+	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.cleanupSize = n
+	}
+}
+
+// WhitelistCacheSize sets how many ips matched by whitelisted prefixes and networks are remembered, so hot ones skip matching. 1024 by default, zero disables cache.
+// Whitelist is fixed after limiter is created, so cached decisions never become stale.
+func WhitelistCacheSize(n int) option {
+	var errs []error
+
+	if n < 0 {
+		errs = append(errs, invalidOption("negative whitelist cache size %d", n))
+		n = defaultWhitelistCache
+	}
+
+	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.whitelistCache = n
+	}
+}
+
 // RecordTTL sets lifetime of every record, before it is expired.
 func RecordTTL(ttl time.Duration) option {
+	var errs []error
+
 	if ttl < 0 {
+		errs = append(errs, invalidOption("negative record ttl %s", ttl))
 		ttl = defaultTTL
 	}
 
 	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
 		opts.ttl = ttl
 	}
 }
 
-// Allowed prefixes takes strings with ips (requester ip will be checked for equality) that will not be ratelimited.
+// Allowed prefixes takes strings with ips (requester ip will be checked for equality) that will not be ratelimited. Ips are normalized, so any form of address can be used.
 func AllowedIPs(ip ...string) option {
 	return func(opts *limiterOptions) {
 		for _, whitelisted := range ip {
-			opts.allowedIPs[whitelisted] = struct{}{}
+			opts.allowedIPs[normalizeIP(whitelisted)] = struct{}{}
 		}
 	}
 }
 
-// Allowed prefixes takes strings with ip prefixes that will not be ratelimited.
+// Allowed prefixes takes strings with ip prefixes that will not be ratelimited. Prefixes are matched as strings, so "10.1" matches
+// "10.100.0.1" too, unless StrictPrefixMatch is set. Use AllowedCIDRs for network semantics.
 func AllowedPrefixes(prefix ...string) option {
 	return func(opts *limiterOptions) {
 		opts.allowedPrefix = append(opts.allowedPrefix, prefix...)
 	}
 }
 
-func (lim *limiter) IPHeader(h string) option {
+// StrictPrefixMatch makes AllowedPrefixes and BlockedPrefixes match only at octet boundary: prefix has to end with "." or ":",
+// or be followed by one of them in ip, or be the whole ip. So "10.1" matches "10.1.0.1", but not "10.100.0.1".
+func StrictPrefixMatch() option {
 	return func(opts *limiterOptions) {
-		opts.ipHeader = h
+		opts.strictPrefix = true
 	}
 }
 
-// Stop stops cleanup routine in limiter
-func (lim *limiter) Stop() {
-	close(lim.stop)
+// SkipPaths sets paths that are never limited, such as health checks and metrics. Requests to them skip every check, blocked ips included.
+func SkipPaths(paths ...string) option {
+	return func(opts *limiterOptions) {
+		for _, p := range paths {
+			opts.skipPaths[p] = struct{}{}
+		}
+	}
 }
 
-func (lim *limiter) scheduleCleanup() {
-	ti := time.NewTicker(lim.opts.cleanupFreq)
-	defer ti.Stop()
+// SkipPrefixes sets path prefixes that are never limited, like SkipPaths.
+func SkipPrefixes(prefixes ...string) option {
+	return func(opts *limiterOptions) {
+		opts.skipPrefixes = append(opts.skipPrefixes, prefixes...)
+	}
+}
 
-	for {
+// WhitelistFunc sets function deciding if request bypasses limiting, in addition to whitelisted ips, prefixes and networks.
+// It is called before token is taken, blocked ips are rejected regardless of it.
+func WhitelistFunc(f func(*http.Request) bool) option {
+	return func(opts *limiterOptions) {
+		opts.whitelistFunc = f
+	}
+}
+
+// BypassHeader lets requests with header name set to value bypass limiting, for example health checks of load balancer.
+// Value is compared in constant time and is checked before token is taken, blocked ips are rejected regardless of it.
+// Can be set several times to accept any of the headers.
+func BypassHeader(name, value string) option {
+	var errs []error
+
+	if name == "" || value == "" {
+		errs = append(errs, invalidOption("empty bypass header %q or its value", name))
+	}
+
+	return func(opts *limiterOptions) {
+		if len(errs) > 0 {
+			opts.errs = append(opts.errs, errs...)
+			return
+		}
+
+		opts.bypassHeaders = append(opts.bypassHeaders, [2]string{name, value})
+	}
+}
+
+// GinWhitelistFunc sets function deciding if request bypasses GinLimit, WhitelistFunc is checked too if it returns false.
+func GinWhitelistFunc(f func(*gin.Context) bool) option {
+	return func(opts *limiterOptions) {
+		opts.ginWhitelistFunc = f
+	}
+}
+
+// BlockedIPs takes ips that are always rejected, before whitelist and limit are checked. Middlewares match client ip even with KeyFunc,
+// so blocked clients cant escape the list by sending another key.
+func BlockedIPs(ip ...string) option {
+	return func(opts *limiterOptions) {
+		for _, blocked := range ip {
+			opts.blockedIPs[normalizeIP(blocked)] = struct{}{}
+		}
+	}
+}
+
+// BlockedPrefixes takes ip prefixes that are always rejected, before whitelist and limit are checked.
+func BlockedPrefixes(prefix ...string) option {
+	return func(opts *limiterOptions) {
+		opts.blockedPrefix = append(opts.blockedPrefix, prefix...)
+	}
+}
+
+// BlockStatus sets http status returned to blocked ips, 403 by default.
+func BlockStatus(code int) option {
+	var errs []error
+
+	if !validStatus(code) {
+		errs = append(errs, invalidOption("invalid block status %d", code))
+		code = http.StatusForbidden
+	}
+
+	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.blockStatus = code
+	}
+}
+
+// RejectStatus sets http status returned to rate limited requests, 429 by default.
+func RejectStatus(code int) option {
+	var errs []error
+
+	if !validStatus(code) {
+		errs = append(errs, invalidOption("invalid reject status %d", code))
+		code = http.StatusTooManyRequests
+	}
+
+	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.rejectStatus = code
+	}
+}
+
+// RejectMessage sets body returned to rate limited requests, "Too many requests" by default.
+func RejectMessage(msg string) option {
+	return func(opts *limiterOptions) {
+		opts.rejectMessage = msg
+	}
+}
+
+// WithBackoffHint replaces default rejection response with JSON object telling client how long to wait in retry_after_ms field.
+// Random delay up to jitter is added to it, so rejected clients dont retry all at once. Has no effect when rejection handler is set.
+func WithBackoffHint(jitter time.Duration) option {
+	var errs []error
+
+	if jitter < 0 {
+		errs = append(errs, invalidOption("negative backoff jitter %s", jitter))
+		jitter = 0
+	}
+
+	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.backoffHint = true
+		opts.backoffJitter = jitter
+	}
+}
+
+// WithProblemDetails replaces default rejection response with RFC 7807 application/problem+json object, with RejectMessage as detail
+// and retryAfter extension telling client how many seconds to wait. Takes precedence over WithBackoffHint, has no effect when rejection handler is set.
+func WithProblemDetails() option {
+	return func(opts *limiterOptions) {
+		opts.problemDetails = true
+	}
+}
+
+// validStatus reports whether code is valid http status.
+func validStatus(code int) bool {
+	return code >= 100 && code <= 999
+}
+
+// AllowedCIDRs takes networks in CIDR notation, ips from them will not be ratelimited. Malformed networks are skipped by New.
+func AllowedCIDRs(cidrs ...string) option {
+	return func(opts *limiterOptions) {
+		opts.allowedNets = append(opts.allowedNets, opts.parseCIDRs(cidrs)...)
+	}
+}
+
+// parseCIDRs returns parsed networks, recording error for every malformed one.
+func (opts *limiterOptions) parseCIDRs(cidrs []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			opts.errs = append(opts.errs, invalidOption("malformed cidr %q", cidr))
+			continue
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets
+}
+
+// WithStore sets storage backend used to count requests. By default requests are counted in memory of current instance.
+func WithStore(s Store) option {
+	return func(opts *limiterOptions) {
+		opts.store = s
+	}
+}
+
+// DryRun makes middlewares serve rejected requests anyway, after OnReject and metrics saw the rejection, so new limits can be checked in production safely.
+func DryRun(enabled bool) option {
+	return func(opts *limiterOptions) {
+		opts.dryRun = enabled
+	}
+}
+
+// PenalizeRejections takes extraCost more tokens from visitor every time its request is rejected, so clients that keep retrying
+// have to wait longer instead of getting through as soon as a token is refilled. With fixed window penalty lasts until the end of window.
+// Applied by in-memory store only, has no effect when store is set with WithStore.
+func PenalizeRejections(extraCost int) option {
+	var errs []error
+
+	if extraCost < 0 {
+		errs = append(errs, invalidOption("negative rejection penalty %d", extraCost))
+		extraCost = 0
+	}
+
+	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.penalty = extraCost
+	}
+}
+
+// WithPathScope gives every key separate budget for every request path, so exhausting one endpoint does not affect others.
+// Rps, burst and overrides are the same for all paths, use LimitPath for different limits. Reset does not forget path scoped budgets.
+func WithPathScope() option {
+	return func(opts *limiterOptions) {
+		opts.pathScope = true
+	}
+}
+
+// WhenNoKey sets what happens with requests whose key can not be determined, for example when ip header and remote address are empty.
+// FailOpen lets them through and is the default, FailClosed rejects them, SharedBucket limits all of them with a single bucket.
+func WhenNoKey(p FailPolicy) option {
+	return func(opts *limiterOptions) {
+		opts.noKey = p
+	}
+}
+
+// KeyFunc sets function that returns key to limit request by, for example user id or api key. If it returns empty string, ip is used.
+func KeyFunc(f func(r *http.Request) string) option {
+	return func(opts *limiterOptions) {
+		opts.keyFunc = f
+	}
+}
+
+// RejectMissingKey rejects requests KeyFunc returns empty key for, instead of limiting them by ip.
+func RejectMissingKey() option {
+	return func(opts *limiterOptions) {
+		opts.rejectMissingKey = true
+	}
+}
+
+// CostFunc sets function returning how many tokens request takes, so heavy endpoints can be charged more than one.
+// Cost less than one counts as one. Stores without AllowN, and fiber middleware, charge one token for every request.
+func CostFunc(f func(*http.Request) int) option {
+	return func(opts *limiterOptions) {
+		opts.costFunc = f
+	}
+}
+
+// ContentLengthCost returns CostFunc function charging a token for every bytesPerToken bytes of request body, rounded up, so limit
+// and burst are budgets of bytes, or of bytesPerToken chunks, per second. Requests with unknown or empty body take one token.
+// Uploads larger than burst are never allowed. Sliding window algorithm keeps every token it counts, so use others for large budgets.
+func ContentLengthCost(bytesPerToken int) func(*http.Request) int {
+	unit := int64(max(1, bytesPerToken))
+
+	return func(r *http.Request) int {
+		if r.ContentLength <= 0 {
+			return 1
+		}
+
+		return int(min((r.ContentLength+unit-1)/unit, math.MaxInt32))
+	}
+}
+
+// GinKeyFunc is the same as KeyFunc, but for GinLimit. Takes precedence over KeyFunc.
+func GinKeyFunc(f func(c *gin.Context) string) option {
+	return func(opts *limiterOptions) {
+		opts.ginKeyFunc = f
+	}
+}
+
+// OnReject sets function called with key and request every time request is rejected, before response is written.
+func OnReject(f func(key string, r *http.Request)) option {
+	return func(opts *limiterOptions) {
+		opts.onReject = f
+	}
+}
+
+// WithLogger sets logger rejected requests are logged to at warn level, with key, path, method, remote address and value of ip header.
+// Used by Limit, GinLimit and echolimit.Limit, nothing is logged by default. New also warns to it when burst is smaller than rate.
+func WithLogger(l *slog.Logger) option {
+	return func(opts *limiterOptions) {
+		opts.logger = l
+	}
+}
+
+// GinRejectionLogger sets logger GinLimit logs rejected requests to at warn level, with key, path, method, ip gin's ClientIP resolves
+// and value of ip header. Takes precedence over WithLogger.
+func GinRejectionLogger(l *slog.Logger) option {
+	return func(opts *limiterOptions) {
+		opts.ginLogger = l
+	}
+}
+
+// GinOnReject is the same as OnReject, but for GinLimit. Takes precedence over OnReject.
+func GinOnReject(f func(key string, c *gin.Context)) option {
+	return func(opts *limiterOptions) {
+		opts.ginOnReject = f
+	}
+}
+
+// RejectionHandler sets handler that writes response to rejected requests instead of default 429 "Too many requests". Retry-After header is already set when it is called.
+func RejectionHandler(h http.Handler) option {
+	return func(opts *limiterOptions) {
+		opts.rejectionHandler = h
+	}
+}
+
+// GinRejectionHandler is the same as RejectionHandler, but for GinLimit. Takes precedence over RejectionHandler. Request is aborted after it returns.
+func GinRejectionHandler(h gin.HandlerFunc) option {
+	return func(opts *limiterOptions) {
+		opts.ginRejectionHandler = h
+	}
+}
+
+// DelegateErrors makes GinLimit add RateLimitError to gin context errors and abort rejected request without writing body,
+// so error handling middleware can format it. Retry-After header and RejectStatus are still set, but not written. Takes precedence over GinRejectionHandler and RejectionHandler.
+func DelegateErrors() option {
+	return func(opts *limiterOptions) {
+		opts.delegateErrors = true
+	}
+}
+
+// IPHeader sets header client ip is looked up in, x-original-forwarded-for by default. If it is empty, remote address is used.
+func IPHeader(h string) option {
+	return func(opts *limiterOptions) {
+		if h == "" {
+			opts.errs = append(opts.errs, invalidOption("empty ip header"))
+			return
+		}
+
+		opts.ipHeaders = []string{h}
+	}
+}
+
+// IPHeaders sets headers client ip is looked up in, first non empty one is used. If all are empty, remote address is used.
+func IPHeaders(headers ...string) option {
+	var errs []error
+
+	if len(headers) == 0 || slices.Contains(headers, "") {
+		errs = append(errs, invalidOption("empty ip header in %q", headers))
+	}
+
+	return func(opts *limiterOptions) {
+		if len(errs) > 0 {
+			opts.errs = append(opts.errs, errs...)
+			return
+		}
+
+		opts.ipHeaders = headers
+	}
+}
+
+// KeyBySubnet stores visitors by network address of their ip masked to v4bits or v6bits prefix, so clients rotating ips within
+// a subnet, for example a /24, share a bucket. Keys that are not ips, like ones returned by KeyFunc, are kept as is.
+// Whitelist, block list and overrides still match exact ip.
+func KeyBySubnet(v4bits, v6bits int) option {
+	var errs []error
+
+	if v4bits < 0 || v4bits > 32 {
+		errs = append(errs, invalidOption("ipv4 prefix length %d is out of range", v4bits))
+		v4bits = 32
+	}
+
+	if v6bits < 0 || v6bits > 128 {
+		errs = append(errs, invalidOption("ipv6 prefix length %d is out of range", v6bits))
+		v6bits = 128
+	}
+
+	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.subnet = true
+		opts.subnetV4 = v4bits
+		opts.subnetV6 = v6bits
+	}
+}
+
+// subnetKey returns network address of key masked by KeyBySubnet if key is an ip, key as is otherwise.
+func (lim *limiter) subnetKey(key string) string {
+	if !lim.opts.subnet {
+		return key
+	}
+
+	addr, err := netip.ParseAddr(key)
+	if err != nil {
+		return key
+	}
+
+	bits := lim.opts.subnetV6
+	if addr.Is4() {
+		bits = lim.opts.subnetV4
+	}
+
+	p, err := addr.Prefix(bits)
+	if err != nil {
+		return key
+	}
+
+	return p.String()
+}
+
+// Stop stops limiter like Close, ignoring error of closing store. Kept for compatibility.
+func (lim *limiter) Stop() {
+	_ = lim.Close()
+}
+
+// Close stops cleanup routine in limiter and limiters set by LimitPath and LimitMethod, waits for them to exit,
+// then closes stores that implement io.Closer. Safe to call more than once, store is closed only by the first call, whose error is returned.
+func (lim *limiter) Close() error {
+	lim.halt()
+	<-lim.done
+
+	var err error
+	lim.closeOnce.Do(func() {
+		if c, ok := lim.store.(io.Closer); ok {
+			err = c.Close()
+		}
+	})
+
+	for _, l := range lim.children() {
+		if cerr := l.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// halt signals cleanup routines of limiter and its children to exit without waiting for them.
+func (lim *limiter) halt() {
+	lim.stopOnce.Do(func() {
+		close(lim.stop)
+
+		if lim.registry != nil {
+			lim.registry.remove(lim)
+		}
+	})
+
+	for _, l := range lim.children() {
+		l.halt()
+	}
+}
+
+// StopContext stops cleanup routines and waits for them to exit, or ctx to be done, in which case its error is returned. Stores are not closed.
+func (lim *limiter) StopContext(ctx context.Context) error {
+	lim.halt()
+
+	select {
+	case <-lim.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for _, l := range lim.children() {
+		if err := l.StopContext(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StopAndDrain stops limiter like StopContext, then runs cleanup one last time and flushes stores that buffer state.
+// Limiters set by LimitPath and LimitMethod are drained too. Returns the first flush error.
+func (lim *limiter) StopAndDrain() error {
+	_ = lim.StopContext(context.Background())
+
+	return lim.drain()
+}
+
+// drain runs final cleanup and flush of limiter and its children, cleanup routines must be stopped already.
+func (lim *limiter) drain() error {
+	lim.cleanup()
+
+	var err error
+	if f, ok := lim.store.(flusher); ok {
+		err = f.Flush()
+	}
+
+	for _, l := range lim.children() {
+		if cerr := l.drain(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// Stats returns number of allowed and rejected requests, along with visitor stats from store. Safe to call concurrently.
+func (lim *limiter) Stats() Stats {
+	var st Stats
+	if s, ok := lim.store.(statser); ok {
+		st = s.Stats()
+	}
+
+	st.Allowed = lim.allowedTotal.Load()
+	st.Rejected = lim.rejectedTotal.Load()
+
+	return st
+}
+
+// Range calls f for every visitor tracked by store, with the key it is stored by, time it was last seen and tokens it has left,
+// until f returns false. Stores that cant list visitors, such as redis one, call f for none.
+func (lim *limiter) Range(f func(key string, lastSeen time.Time, tokens float64) bool) {
+	if r, ok := lim.store.(ranger); ok {
+		r.Range(f)
+	}
+}
+
+// start starts cleanup routine, unless WithoutAutoCleanup is set or limiter is cleaned by registry. Ticker is created before it, so clock
+// advanced right after New already ticks it.
+func (lim *limiter) start() {
+	if lim.opts.noAutoCleanup {
+		close(lim.done)
+		return
+	}
+
+	if lim.registry != nil {
+		lim.registry.add(lim)
+		close(lim.done)
+		return
+	}
+
+	go lim.scheduleCleanup(lim.opts.clock.NewTicker(lim.opts.cleanupFreq))
+}
+
+func (lim *limiter) scheduleCleanup(ti Ticker) {
+	defer close(lim.done)
+	defer ti.Stop()
+
+	for {
 		select {
-		case <-ti.C:
+		case <-ti.C():
+			lim.cleanup()
+		case <-lim.grown:
 			lim.cleanup()
 		case <-lim.stop:
 			return
@@ -258,36 +1376,166 @@ func (lim *limiter) scheduleCleanup() {
 	}
 }
 
+// Cleanup removes expired visitors of limiter and limiters set by LimitPath and LimitMethod right away, see WithoutAutoCleanup.
+// Safe to call concurrently with requests and cleanup routine.
+func (lim *limiter) Cleanup() {
+	lim.cleanup()
+
+	for _, l := range lim.children() {
+		l.Cleanup()
+	}
+}
+
+// requestCleanup asks cleanup routine to run cleanup now. Requests made while one is pending are dropped, so cleanups never run concurrently.
+func (lim *limiter) requestCleanup() {
+	select {
+	case lim.grown <- struct{}{}:
+	default:
+	}
+}
+
+// cleanup deletes expired visitors of store with RecordTTL if it is expirer, counting them in metrics, or calls its Cleanup.
 func (lim *limiter) cleanup() {
-	exp := make([]string, len(lim.storage)>>1)
+	if e, ok := lim.store.(expirer); ok {
+		lim.metrics.addExpired(e.DeleteExpired(lim.opts.ttl, lim.opts.clock.Now()))
+	} else {
+		lim.store.Cleanup()
+	}
 
-	lim.RLock()
-	for k, v := range lim.storage {
-		if v == nil {
-			exp = append(exp, k)
-		}
+	lim.cleanupDynamic()
+	lim.cleanupIdempotency()
+	lim.clearRegions()
+	lim.metrics.setVisitors(lim.store)
+}
+
+// requestWhitelisted reports whether BypassHeader, AllowedTenants or WhitelistFunc lets request bypass limiting.
+func (lim *limiter) requestWhitelisted(r *http.Request) bool {
+	if lim.bypassed(r.Header.Get) || lim.tenantAllowed(r) {
+		return true
+	}
+
+	return lim.opts.whitelistFunc != nil && lim.opts.whitelistFunc(r)
+}
 
-		if v == nil || time.Since(v.lastSeen) >= lim.opts.ttl {
-			exp = append(exp, k)
+// bypassed reports whether one of headers set by BypassHeader has its value.
+func (lim *limiter) bypassed(header func(string) string) bool {
+	for _, h := range lim.opts.bypassHeaders {
+		if subtle.ConstantTimeCompare([]byte(header(h[0])), []byte(h[1])) == 1 {
+			return true
 		}
 	}
-	lim.RUnlock()
 
-	lim.Lock()
-	for _, k := range exp {
-		delete(lim.storage, k)
+	return false
+}
+
+// ginWhitelisted reports whether GinWhitelistFunc or WhitelistFunc lets request bypass limiting.
+func (lim *limiter) ginWhitelisted(c *gin.Context) bool {
+	if lim.opts.ginWhitelistFunc != nil && lim.opts.ginWhitelistFunc(c) {
+		return true
 	}
-	lim.Unlock()
+
+	return lim.requestWhitelisted(c.Request)
+}
+
+func (lim *limiter) blocked(ip string) bool {
+	if _, ok := lim.opts.blockedIPs[ip]; ok {
+		return true
+	}
+
+	for _, v := range lim.opts.blockedPrefix {
+		if lim.hasPrefix(ip, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (lim *limiter) blockStatus() int {
+	return lim.opts.blockStatus
 }
 
 func (lim *limiter) whiteListed(ip string) bool {
-	_, ok := lim.opts.allowedIPs[ip]
-	return ok || lim.hasWhitelistedPrefix(ip)
+	if _, ok := lim.opts.allowedIPs[ip]; ok {
+		return true
+	}
+
+	if len(lim.opts.allowedPrefix) == 0 && len(lim.opts.allowedNets) == 0 {
+		return false
+	}
+
+	if _, ok := lim.whitelisted.Load(ip); ok {
+		return true
+	}
+
+	if !lim.hasWhitelistedPrefix(ip) && !lim.inWhitelistedNet(ip) {
+		return false
+	}
+
+	if lim.whitelistedSize.Add(1) <= int64(lim.opts.whitelistCache) {
+		lim.whitelisted.Store(ip, struct{}{})
+	}
+
+	return true
+}
+
+func (lim *limiter) inWhitelistedNet(ip string) bool {
+	return inNets(ip, lim.opts.allowedNets)
+}
+
+// inNets reports whether ip belongs to one of nets.
+func inNets(ip string, nets []*net.IPNet) bool {
+	if len(nets) == 0 {
+		return false
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (lim *limiter) hasWhitelistedPrefix(ip string) bool {
 	for _, v := range lim.opts.allowedPrefix {
-		if strings.HasPrefix(ip, v) {
+		if lim.hasPrefix(ip, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasPrefix reports whether ip starts with prefix, at octet boundary with StrictPrefixMatch.
+func (lim *limiter) hasPrefix(ip, prefix string) bool {
+	if !strings.HasPrefix(ip, prefix) {
+		return false
+	}
+
+	if !lim.opts.strictPrefix || len(ip) == len(prefix) || prefix == "" {
+		return true
+	}
+
+	last, next := prefix[len(prefix)-1], ip[len(prefix)]
+
+	return last == '.' || last == ':' || next == '.' || next == ':'
+}
+
+// skipped reports whether path is set by SkipPaths or starts with one of SkipPrefixes.
+func (lim *limiter) skipped(path string) bool {
+	if _, ok := lim.opts.skipPaths[path]; ok {
+		return true
+	}
+
+	for _, p := range lim.opts.skipPrefixes {
+		if strings.HasPrefix(path, p) {
 			return true
 		}
 	}
@@ -295,6 +1543,287 @@ func (lim *limiter) hasWhitelistedPrefix(ip string) bool {
 	return false
 }
 
-func (lim *limiter) ipHeader() string {
-	return lim.opts.ipHeader
+func (lim *limiter) rejectionHandler() http.Handler {
+	return lim.opts.rejectionHandler
+}
+
+func (lim *limiter) dryRun() bool {
+	return lim.opts.dryRun
+}
+
+func (lim *limiter) rejectStatus() int {
+	return lim.opts.rejectStatus
+}
+
+func (lim *limiter) rejectMessage() string {
+	return lim.opts.rejectMessage
+}
+
+// ipHeaderValue returns value of the first ip header that is not empty, get returns header value by name.
+func (lim *limiter) ipHeaderValue(get func(string) string) string {
+	for _, h := range lim.opts.ipHeaders {
+		if v := get(h); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// cost returns number of tokens request takes, using CostFunc if set. Cost is at least one.
+func (lim *limiter) cost(r *http.Request) int {
+	if lim.opts.costFunc == nil {
+		return 1
+	}
+
+	return max(1, lim.opts.costFunc(r))
+}
+
+// key returns key request is limited by. Uses KeyFunc if set and it returns non empty key, otherwise client ip,
+// or empty key with RejectMissingKey.
+func (lim *limiter) key(r *http.Request, remoteIP func() string) string {
+	if lim.opts.keyFunc != nil {
+		if k := lim.opts.keyFunc(r); k != "" || lim.opts.rejectMissingKey {
+			return k
+		}
+	}
+
+	return lim.requestIP(r, remoteIP)
+}
+
+// requestIP returns client ip of request, using ip headers and remoteIP. With trusted proxies, address of the peer is used instead of remoteIP.
+func (lim *limiter) requestIP(r *http.Request, remoteIP func() string) string {
+	if len(lim.opts.trustedProxies) > 0 {
+		remoteIP = remoteAddrIP(r)
+	}
+
+	return lim.clientIP(lim.ipHeaderValue(r.Header.Get), remoteIP)
+}
+
+// ClientIPFromContext returns client ip resolved by Limit, GinLimit or echolimit.Limit, ctx can be request context or gin context.
+func ClientIPFromContext(ctx context.Context) (string, bool) {
+	if c, ok := ctx.(*gin.Context); ok {
+		return c.GetString(GinClientIPKey), c.GetString(GinClientIPKey) != ""
+	}
+
+	ip, ok := ctx.Value(ClientIPKey).(string)
+
+	return ip, ok
+}
+
+// clientIP returns ip from header value picked by XFFStrategy, falling back to remoteIP. With RightMostTrusted, remoteIP must return address of the peer.
+// Returned ip is normalized, so different forms of the same address share a bucket.
+func (lim *limiter) clientIP(header string, remoteIP func() string) string {
+	switch lim.strategy() {
+	case RightMostTrusted:
+		return normalizeIP(lim.trustedClientIP(header, normalizeIP(remoteIP())))
+	case RightMost:
+		if ip := rightMostIP(header); ip != "" {
+			return normalizeIP(ip)
+		}
+	default:
+		if ip := headerIP(header); ip != "" {
+			return normalizeIP(ip)
+		}
+	}
+
+	return normalizeIP(remoteIP())
+}
+
+// normalizeIP returns canonical form of ip without zone and brackets, so "[::1]" and "::1" are the same, or s as is if it is not an ip.
+func normalizeIP(s string) string {
+	host := s
+	if len(host) > 1 && host[0] == '[' && host[len(host)-1] == ']' {
+		host = host[1 : len(host)-1]
+	}
+
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+
+	return s
+}
+
+// ginKey is a gin counterpart of key, GinKeyFunc is tried before KeyFunc.
+func (lim *limiter) ginKey(c *gin.Context) string {
+	if lim.opts.ginKeyFunc != nil {
+		if k := lim.opts.ginKeyFunc(c); k != "" {
+			return k
+		}
+	}
+
+	return lim.key(c.Request, orRemoteAddr(c.ClientIP, c.Request))
+}
+
+// orRemoteAddr returns ip, falling back to host of RemoteAddr when ip is empty, which is what gin and echo return for RemoteAddr without port.
+func orRemoteAddr(ip func() string, r *http.Request) func() string {
+	return func() string {
+		if v := ip(); v != "" {
+			return v
+		}
+
+		return remoteAddrIP(r)()
+	}
+}
+
+// remoteAddrIP returns host part of RemoteAddr, or whole RemoteAddr if it has no port.
+func remoteAddrIP(r *http.Request) func() string {
+	return func() string {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+
+		return host
+	}
+}
+
+// rejected logs rejected request and calls OnReject callback if it is set.
+func (lim *limiter) rejected(key string, r *http.Request) {
+	lim.logRejected(key, r)
+
+	if lim.opts.onReject != nil {
+		lim.opts.onReject(key, r)
+	}
+}
+
+// ginRejected logs rejected request with GinRejectionLogger or WithLogger logger and calls GinOnReject callback, or OnReject if only it is set.
+func (lim *limiter) ginRejected(key string, c *gin.Context) {
+	if lim.opts.ginLogger != nil {
+		lim.opts.ginLogger.LogAttrs(c.Request.Context(), slog.LevelWarn, "request rate limited",
+			slog.String("key", key),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("method", c.Request.Method),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("ip_header", lim.ipHeaderValue(c.GetHeader)),
+		)
+	} else {
+		lim.logRejected(key, c.Request)
+	}
+
+	if lim.opts.ginOnReject != nil {
+		lim.opts.ginOnReject(key, c)
+		return
+	}
+
+	if lim.opts.onReject != nil {
+		lim.opts.onReject(key, c.Request)
+	}
+}
+
+// logRejected logs rejected request to logger set by WithLogger.
+func (lim *limiter) logRejected(key string, r *http.Request) {
+	if lim.opts.logger == nil {
+		return
+	}
+
+	lim.opts.logger.LogAttrs(r.Context(), slog.LevelWarn, "request rate limited",
+		slog.String("key", key),
+		slog.String("path", r.URL.Path),
+		slog.String("method", r.Method),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("ip_header", lim.ipHeaderValue(r.Header.Get)),
+	)
+}
+
+// reject writes response to rejected request, using RejectionHandler if it is set. Default response is backoff hint if it is enabled.
+func (lim *limiter) reject(w http.ResponseWriter, r *http.Request, retry time.Duration) {
+	if lim.opts.rejectionHandler != nil {
+		lim.opts.rejectionHandler.ServeHTTP(w, r)
+		return
+	}
+
+	if p := lim.problem(retry); p != nil {
+		w.Header().Set("Content-Type", problemJSON)
+		w.WriteHeader(lim.opts.rejectStatus)
+		_ = json.NewEncoder(w).Encode(p)
+		return
+	}
+
+	if h := lim.hint(retry); h != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(lim.opts.rejectStatus)
+		_ = json.NewEncoder(w).Encode(h)
+		return
+	}
+
+	http.Error(w, lim.opts.rejectMessage, lim.opts.rejectStatus)
+}
+
+// ginReject writes response to rejected request, using GinRejectionHandler or RejectionHandler if one is set.
+func (lim *limiter) ginReject(c *gin.Context, retry time.Duration) {
+	if lim.opts.delegateErrors {
+		c.Status(lim.opts.rejectStatus)
+		_ = c.Error(&RateLimitError{IP: c.GetString(GinClientIPKey), Status: lim.opts.rejectStatus, RetryAfter: retry})
+		return
+	}
+
+	if lim.opts.ginRejectionHandler != nil {
+		lim.opts.ginRejectionHandler(c)
+		return
+	}
+
+	if lim.opts.rejectionHandler != nil {
+		lim.opts.rejectionHandler.ServeHTTP(c.Writer, c.Request)
+		return
+	}
+
+	if p := lim.problem(retry); p != nil {
+		c.Header("Content-Type", problemJSON)
+		c.JSON(lim.opts.rejectStatus, p)
+		return
+	}
+
+	if h := lim.hint(retry); h != nil {
+		c.JSON(lim.opts.rejectStatus, h)
+		return
+	}
+
+	c.String(lim.opts.rejectStatus, lim.opts.rejectMessage)
+}
+
+// hint returns backoff hint for rejected request that has to wait retry, or nil if WithBackoffHint is not set.
+func (lim *limiter) hint(retry time.Duration) *backoffHint {
+	if !lim.opts.backoffHint {
+		return nil
+	}
+
+	if lim.opts.backoffJitter > 0 {
+		retry += rand.N(lim.opts.backoffJitter)
+	}
+
+	return &backoffHint{
+		Error:        lim.opts.rejectMessage,
+		RetryAfterMs: retry.Milliseconds(),
+	}
+}
+
+// problem returns problem details for rejected request that has to wait retry, or nil if WithProblemDetails is not set.
+func (lim *limiter) problem(retry time.Duration) *problemDetails {
+	if !lim.opts.problemDetails {
+		return nil
+	}
+
+	retryAfter, _ := strconv.Atoi(retryAfterSeconds(retry))
+
+	return &problemDetails{
+		Type:       "about:blank",
+		Title:      http.StatusText(lim.opts.rejectStatus),
+		Status:     lim.opts.rejectStatus,
+		Detail:     lim.opts.rejectMessage,
+		RetryAfter: retryAfter,
+	}
+}
+
+// headerIP returns first ip from comma separated header value.
+func headerIP(v string) string {
+	if hops := headerHops(v); len(hops) > 0 {
+		return hops[0]
+	}
+
+	return ""
 }
@@ -1,14 +1,49 @@
 package limiter
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
 )
 
+// middlewares returns http and gin handlers limited by l, by name, that answer requests with serve, or with 200 if serve is nil.
+func middlewares(serve http.HandlerFunc) map[string]func(l Limiter) http.Handler {
+	if serve == nil {
+		serve = func(w http.ResponseWriter, r *http.Request) {}
+	}
+
+	return map[string]func(l Limiter) http.Handler{
+		"http": func(l Limiter) http.Handler {
+			return Limit(l)(serve)
+		},
+		"gin": func(l Limiter) http.Handler {
+			router := gin.New()
+			router.Use(GinLimit(l))
+			router.NoRoute(func(c *gin.Context) {
+				c.Status(http.StatusOK)
+				serve(c.Writer, c.Request)
+			})
+
+			return router
+		},
+	}
+}
+
 func TestLimit(t *testing.T) {
 	var (
 		someIP = "1.1.1.1"
@@ -243,3 +278,1800 @@ func TestGinLimit(t *testing.T) {
 		})
 	}
 }
+
+func TestAllowedIPs(t *testing.T) {
+	ips := make([]string, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		ips = append(ips, fmt.Sprintf("10.%d.%d.%d", i>>16, (i>>8)&0xff, i&0xff))
+	}
+
+	l := New(AllowedIPs(ips...), AllowedIPs(ips[:100]...))
+	defer l.Stop()
+
+	assert.Len(t, l.(*limiter).opts.allowedIPs, len(ips))
+
+	for _, ip := range ips {
+		assert.True(t, l.whiteListed(ip))
+	}
+
+	assert.False(t, l.whiteListed("10.1.0.0"))
+	assert.False(t, l.whiteListed("1.1.1.1"))
+}
+
+func TestStrictPrefixMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		ip     string
+		loose  bool
+		strict bool
+	}{
+		{name: "partial octet", prefix: "10.1", ip: "10.100.0.1", loose: true, strict: false},
+		{name: "whole octet", prefix: "10.1", ip: "10.1.0.1", loose: true, strict: true},
+		{name: "trailing dot", prefix: "192.168.", ip: "192.168.1.1", loose: true, strict: true},
+		{name: "whole ip", prefix: "10.0.0.1", ip: "10.0.0.1", loose: true, strict: true},
+		{name: "ipv6 group", prefix: "2001:db8", ip: "2001:db8::1", loose: true, strict: true},
+		{name: "ipv6 partial group", prefix: "2001:db", ip: "2001:db8::1", loose: true, strict: false},
+		{name: "other ip", prefix: "10.1", ip: "11.1.0.1", loose: false, strict: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loose := New(AllowedPrefixes(tt.prefix), BlockedPrefixes(tt.prefix))
+			defer loose.Stop()
+			strict := New(AllowedPrefixes(tt.prefix), BlockedPrefixes(tt.prefix), StrictPrefixMatch())
+			defer strict.Stop()
+
+			assert.Equal(t, tt.loose, loose.whiteListed(tt.ip))
+			assert.Equal(t, tt.loose, loose.blocked(tt.ip))
+			assert.Equal(t, tt.strict, strict.whiteListed(tt.ip))
+			assert.Equal(t, tt.strict, strict.blocked(tt.ip))
+		})
+	}
+}
+
+func TestGinLimitWhitelistedOverLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	l := New(AllowedIPs("1.1.1.1"), RpsWithBurst(1, 1))
+	defer l.Stop()
+
+	served := 0
+	router := gin.New()
+	router.Use(GinLimit(l))
+	router.GET("/test", func(c *gin.Context) {
+		served++
+		c.String(http.StatusOK, "OK")
+	})
+
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set(XOFF, "1.1.1.1")
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "OK", rec.Body.String())
+	}
+
+	assert.Equal(t, 5, served)
+}
+
+func TestRetryAfter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		opts     []option
+		numReq   int
+		expected string
+	}{
+		{
+			name:     "one_rps",
+			opts:     []option{RpsWithBurst(1, 1)},
+			numReq:   2,
+			expected: "1",
+		},
+		{
+			name:     "one_per_five_seconds",
+			opts:     []option{Period(1, 5*time.Second), Burst(1)},
+			numReq:   2,
+			expected: "5",
+		},
+		{
+			name:     "zero_burst_uses_period",
+			opts:     []option{Period(1, 10*time.Second), Burst(0)},
+			numReq:   1,
+			expected: "10",
+		},
+		{
+			name:     "not_limited",
+			opts:     []option{RpsWithBurst(1, 1)},
+			numReq:   1,
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.opts...)
+			defer l.Stop()
+
+			h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			var rec *httptest.ResponseRecorder
+			for i := 0; i < tt.numReq; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				rec = httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+			}
+
+			assert.Equal(t, tt.expected, rec.Header().Get("Retry-After"))
+
+			gl := New(tt.opts...)
+			defer gl.Stop()
+
+			router := gin.New()
+			router.Use(GinLimit(gl))
+			router.GET("/test", func(c *gin.Context) {
+				c.String(http.StatusOK, "OK")
+			})
+
+			for i := 0; i < tt.numReq; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				rec = httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+			}
+
+			assert.Equal(t, tt.expected, rec.Header().Get("Retry-After"))
+		})
+	}
+}
+
+func TestEveryN(t *testing.T) {
+	tests := []struct {
+		name     string
+		opt      option
+		burst    int
+		interval time.Duration
+	}{
+		{name: "1 per 5s", opt: OncePer(5 * time.Second), burst: 1, interval: 5 * time.Second},
+		{name: "3 per minute", opt: EveryN(3, time.Minute), burst: 3, interval: 20 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &manualClock{now: time.Unix(0, 0)}
+			l := New(tt.opt, WithClock(c))
+			defer l.Stop()
+
+			for i := 0; i < tt.burst; i++ {
+				assert.True(t, l.Allow("1.1.1.1"))
+			}
+			assert.False(t, l.Allow("1.1.1.1"))
+
+			c.now = c.now.Add(tt.interval - time.Second)
+			assert.False(t, l.Allow("1.1.1.1"))
+
+			c.now = c.now.Add(time.Second)
+			assert.True(t, l.Allow("1.1.1.1"))
+			assert.False(t, l.Allow("1.1.1.1"))
+		})
+	}
+}
+
+func TestLimitGetter(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []option
+		limit rate.Limit
+		burst int
+	}{
+		{name: "defaults", limit: defaultRps, burst: defaultBurst},
+		{name: "rps", opts: []option{Rps(5)}, limit: 5, burst: 5},
+		{name: "rps with burst", opts: []option{RpsWithBurst(2, 8)}, limit: 2, burst: 8},
+		{name: "period", opts: []option{Period(1, 5*time.Second), Burst(1)}, limit: 0.2, burst: 1},
+		{name: "limit with burst", opts: []option{LimitWithBurst(0.5, 30)}, limit: 0.5, burst: 30},
+		{name: "limit with burst over rps", opts: []option{LimitWithBurst(0.5, 30), Rps(5)}, limit: 0.5, burst: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.opts...)
+			defer l.Stop()
+
+			limit, burst := l.Limit()
+			assert.InDelta(t, float64(tt.limit), float64(limit), 1e-9)
+			assert.Equal(t, tt.burst, burst)
+		})
+	}
+
+	l := New()
+	defer l.Stop()
+
+	l.SetLimit(3, 6)
+	limit, burst := l.Limit()
+	assert.Equal(t, rate.Limit(3), limit)
+	assert.Equal(t, 6, burst)
+}
+
+func TestLimitWithBurst(t *testing.T) {
+	c := &manualClock{now: time.Unix(0, 0)}
+	l := New(LimitWithBurst(0.5, 3), WithClock(c))
+	defer l.Stop()
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.Allow("1.1.1.1"))
+	}
+	assert.False(t, l.Allow("1.1.1.1"))
+
+	c.now = c.now.Add(time.Second)
+	assert.False(t, l.Allow("1.1.1.1"))
+
+	c.now = c.now.Add(time.Second)
+	assert.True(t, l.Allow("1.1.1.1"))
+	assert.False(t, l.Allow("1.1.1.1"))
+}
+
+func TestBurstSmallerThanRateWarning(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  option
+		warn bool
+	}{
+		{name: "smaller", opt: RpsWithBurst(10, 2), warn: true},
+		{name: "equal", opt: Rps(10)},
+		{name: "larger", opt: RpsWithBurst(2, 10)},
+		{name: "unlimited", opt: LimitWithBurst(rate.Inf, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l := New(tt.opt, WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+			defer l.Stop()
+
+			assert.Equal(t, tt.warn, strings.Contains(buf.String(), "burst is smaller than rate"), buf.String())
+		})
+	}
+}
+
+func TestKeyFunc(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	httpHandler := func(l Limiter) http.Handler {
+		return Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+
+	ginHandler := func(l Limiter) http.Handler {
+		router := gin.New()
+		router.Use(GinLimit(l))
+		router.GET("/test", func(c *gin.Context) {
+			c.String(http.StatusOK, "OK")
+		})
+
+		return router
+	}
+
+	byAuth := func(r *http.Request) string {
+		return r.Header.Get("Authorization")
+	}
+
+	tests := []struct {
+		name    string
+		opt     option
+		handler func(Limiter) http.Handler
+	}{
+		{
+			name:    "key_func",
+			opt:     KeyFunc(byAuth),
+			handler: httpHandler,
+		},
+		{
+			name:    "key_func_gin",
+			opt:     KeyFunc(byAuth),
+			handler: ginHandler,
+		},
+		{
+			name: "gin_key_func",
+			opt: GinKeyFunc(func(c *gin.Context) string {
+				return c.GetHeader("Authorization")
+			}),
+			handler: ginHandler,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.opt, RpsWithBurst(1, 1))
+			defer l.Stop()
+
+			handler := tt.handler(l)
+
+			send := func(auth string) int {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				req.Header.Set("Authorization", auth)
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				return rec.Code
+			}
+
+			assert.Equal(t, http.StatusOK, send("token-a"))
+			assert.Equal(t, http.StatusTooManyRequests, send("token-a"))
+			assert.Equal(t, http.StatusOK, send("token-b"))
+			assert.Equal(t, http.StatusTooManyRequests, send("token-b"))
+
+			assert.Equal(t, http.StatusOK, send(""), "falls back to ip")
+			assert.Equal(t, http.StatusTooManyRequests, send(""))
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	mw, l := Middleware(RpsWithBurst(1, 1), CleanupFrequency(time.Millisecond))
+
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	codes := make([]int, 0, 2)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set(XOFF, "1.1.1.1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests}, codes)
+
+	lim := l.(*limiter)
+	select {
+	case <-lim.stop:
+		t.Fatal("cleanup stopped before Stop")
+	default:
+	}
+
+	l.Stop()
+
+	_, open := <-lim.stop
+	assert.False(t, open)
+}
+
+func TestLimitFuncAndWrap(t *testing.T) {
+	next := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	handlers := map[string]func(l Limiter) http.Handler{
+		"Limit":     func(l Limiter) http.Handler { return Limit(l)(http.HandlerFunc(next)) },
+		"LimitFunc": func(l Limiter) http.Handler { return LimitFunc(l, next) },
+		"Wrap":      func(l Limiter) http.Handler { return Wrap(l, http.HandlerFunc(next)) },
+		"LimiterHandler": func(l Limiter) http.Handler {
+			return &LimiterHandler{Limiter: l, Next: http.HandlerFunc(next)}
+		},
+	}
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			l := New(RpsWithBurst(1, 2), BlockedIPs("3.3.3.3"))
+			defer l.Stop()
+
+			h := handler(l)
+			do := func(ip string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set(XOFF, ip)
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				return rec
+			}
+
+			assert.Equal(t, http.StatusOK, do("1.1.1.1").Code)
+			assert.Equal(t, http.StatusOK, do("1.1.1.1").Code)
+
+			rec := do("1.1.1.1")
+			assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+			assert.Equal(t, "1", rec.Header().Get(retryAfter))
+
+			assert.Equal(t, http.StatusOK, do("2.2.2.2").Code)
+			assert.Equal(t, http.StatusForbidden, do("3.3.3.3").Code)
+		})
+	}
+}
+
+func TestLimiterHandler(t *testing.T) {
+	l := New(RpsWithBurst(1, 2))
+	defer l.Stop()
+
+	calls := 0
+	h := &LimiterHandler{Limiter: l, Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		ip, _ := ClientIPFromContext(r.Context())
+		assert.Equal(t, "1.1.1.1", ip)
+		_, _ = w.Write([]byte("next"))
+	})}
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set(XOFF, "1.1.1.1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if i < 2 {
+			assert.Equal(t, "next", rec.Body.String())
+		} else {
+			assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		}
+	}
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestSkipPaths(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	tests := []struct {
+		path     string
+		ip       string
+		expected int
+	}{
+		{path: "/healthz", ip: "1.1.1.1", expected: http.StatusOK},
+		{path: "/metrics/jobs", ip: "1.1.1.1", expected: http.StatusOK},
+		{path: "/healthz", ip: "6.6.6.6", expected: http.StatusOK},
+		{path: "/healthz/deep", ip: "1.1.1.1", expected: http.StatusTooManyRequests},
+		{path: "/api", ip: "1.1.1.1", expected: http.StatusTooManyRequests},
+	}
+
+	for name, handler := range handlers {
+		for _, tt := range tests {
+			t.Run(name+tt.path+"_"+tt.ip, func(t *testing.T) {
+				l := New(RpsWithBurst(1, 1), SkipPaths("/healthz"), SkipPrefixes("/metrics/"), BlockedIPs("6.6.6.6"))
+				defer l.Stop()
+
+				h := handler(l)
+				code := 0
+				for i := 0; i < 100; i++ {
+					req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+					req.Header.Set(XOFF, tt.ip)
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, req)
+					code = rec.Code
+				}
+
+				assert.Equal(t, tt.expected, code)
+			})
+		}
+	}
+}
+
+func TestAllowedCIDRs(t *testing.T) {
+	l := New(AllowedCIDRs("10.0.0.0/8", "2001:db8::/32", "not-a-cidr"))
+	defer l.Stop()
+
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "10.0.0.1", expected: true},
+		{ip: "10.255.255.255", expected: true},
+		{ip: "11.0.0.1", expected: false},
+		{ip: "2001:db8::1", expected: true},
+		{ip: "2001:db9::1", expected: false},
+		{ip: "not-an-ip", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.expected, l.whiteListed(tt.ip))
+		})
+	}
+
+	assert.Len(t, l.(*limiter).opts.allowedNets, 2)
+}
+
+func TestNewWithError(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []option
+		contains []string
+	}{
+		{
+			name: "valid",
+			opts: []option{RpsWithBurst(5, 10), AllowedCIDRs("10.0.0.0/8")},
+		},
+		{
+			name:     "malformed_cidrs",
+			opts:     []option{AllowedCIDRs("10.0.0.0/8", "10.0.0.0/33", "nope")},
+			contains: []string{`malformed cidr "10.0.0.0/33"`, `malformed cidr "nope"`},
+		},
+		{
+			name:     "zero_cleanup_frequency",
+			opts:     []option{CleanupFrequency(0)},
+			contains: []string{"cleanup frequency 0s is not positive"},
+		},
+		{
+			name:     "empty_ip_header",
+			opts:     []option{IPHeader("")},
+			contains: []string{"empty ip header"},
+		},
+		{
+			name:     "negative_values",
+			opts:     []option{RpsWithBurst(-1, -2), RecordTTL(-time.Second)},
+			contains: []string{"negative rps -1", "negative burst -2", "negative record ttl -1s"},
+		},
+		{
+			name:     "every_n",
+			opts:     []option{EveryN(-1, 0)},
+			contains: []string{"negative requests -1", "duration 0s is not positive"},
+		},
+		{
+			name:     "negative_warm_up",
+			opts:     []option{WarmUp(-time.Second)},
+			contains: []string{"negative warm up -1s"},
+		},
+		{
+			name:     "invalid_limit_with_burst",
+			opts:     []option{LimitWithBurst(-1, -1)},
+			contains: []string{"invalid limit -1", "negative burst -1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := NewWithError(tt.opts...)
+
+			if len(tt.contains) == 0 {
+				assert.NoError(t, err)
+				assert.NotNil(t, l)
+				l.Stop()
+				return
+			}
+
+			assert.ErrorIs(t, err, ErrInvalidOption)
+			assert.Nil(t, l)
+			for _, c := range tt.contains {
+				assert.Contains(t, err.Error(), c)
+			}
+
+			l = New(tt.opts...)
+			assert.NotNil(t, l)
+			l.Stop()
+		})
+	}
+}
+
+func TestOnReject(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	type call struct {
+		key  string
+		path string
+	}
+
+	tests := []struct {
+		name    string
+		handler func(l Limiter) http.Handler
+		opt     func(calls *[]call) option
+	}{
+		{
+			name: "http",
+			handler: func(l Limiter) http.Handler {
+				return Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}))
+			},
+			opt: func(calls *[]call) option {
+				return OnReject(func(key string, r *http.Request) {
+					*calls = append(*calls, call{key: key, path: r.URL.Path})
+				})
+			},
+		},
+		{
+			name: "gin",
+			handler: func(l Limiter) http.Handler {
+				router := gin.New()
+				router.Use(GinLimit(l))
+				router.GET("/test", func(c *gin.Context) {
+					c.String(http.StatusOK, "OK")
+				})
+
+				return router
+			},
+			opt: func(calls *[]call) option {
+				return GinOnReject(func(key string, c *gin.Context) {
+					*calls = append(*calls, call{key: key, path: c.Request.URL.Path})
+				})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []call
+
+			l := New(RpsWithBurst(1, 2), tt.opt(&calls))
+			defer l.Stop()
+
+			h := tt.handler(l)
+
+			for _, ip := range []string{"1.1.1.1", "1.1.1.1", "2.2.2.2", "1.1.1.1", "1.1.1.1"} {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set(XOFF, ip)
+				h.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			assert.Equal(t, []call{{key: "1.1.1.1", path: "/test"}, {key: "1.1.1.1", path: "/test"}}, calls)
+		})
+	}
+}
+
+func TestRejectionHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	jsonHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"error":"rate_limited"}`))
+	})
+
+	ginRouter := func(l Limiter) http.Handler {
+		router := gin.New()
+		router.Use(GinLimit(l))
+		router.GET("/test", func(c *gin.Context) {
+			c.String(http.StatusOK, "OK")
+		})
+
+		return router
+	}
+
+	tests := []struct {
+		name         string
+		opts         []option
+		handler      func(l Limiter) http.Handler
+		expectedCode int
+		expectedType string
+		expectedBody string
+	}{
+		{
+			name: "http_json",
+			opts: []option{RejectionHandler(jsonHandler)},
+			handler: func(l Limiter) http.Handler {
+				return Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}))
+			},
+			expectedCode: http.StatusServiceUnavailable,
+			expectedType: "application/json",
+			expectedBody: `{"error":"rate_limited"}`,
+		},
+		{
+			name:         "gin_uses_http_handler",
+			opts:         []option{RejectionHandler(jsonHandler)},
+			handler:      ginRouter,
+			expectedCode: http.StatusServiceUnavailable,
+			expectedType: "application/json",
+			expectedBody: `{"error":"rate_limited"}`,
+		},
+		{
+			name: "gin_json",
+			opts: []option{
+				RejectionHandler(jsonHandler),
+				GinRejectionHandler(func(c *gin.Context) {
+					c.JSON(http.StatusTooManyRequests, gin.H{"error": "gin_rate_limited"})
+				}),
+			},
+			handler:      ginRouter,
+			expectedCode: http.StatusTooManyRequests,
+			expectedType: "application/json; charset=utf-8",
+			expectedBody: `{"error":"gin_rate_limited"}`,
+		},
+		{
+			name:         "gin_default",
+			handler:      ginRouter,
+			expectedCode: http.StatusTooManyRequests,
+			expectedType: "text/plain; charset=utf-8",
+			expectedBody: tooManyReqMsg,
+		},
+		{
+			name: "http_status_and_message",
+			opts: []option{RejectStatus(http.StatusServiceUnavailable), RejectMessage("slow down")},
+			handler: func(l Limiter) http.Handler {
+				return Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}))
+			},
+			expectedCode: http.StatusServiceUnavailable,
+			expectedType: "text/plain; charset=utf-8",
+			expectedBody: "slow down\n",
+		},
+		{
+			name:         "gin_status_and_message",
+			opts:         []option{RejectStatus(http.StatusServiceUnavailable), RejectMessage("slow down")},
+			handler:      ginRouter,
+			expectedCode: http.StatusServiceUnavailable,
+			expectedType: "text/plain; charset=utf-8",
+			expectedBody: "slow down",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(append(tt.opts, RpsWithBurst(1, 1))...)
+			defer l.Stop()
+
+			h := tt.handler(l)
+
+			var rec *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				rec = httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+			}
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+			assert.Equal(t, tt.expectedType, rec.Header().Get("Content-Type"))
+			assert.Equal(t, tt.expectedBody, rec.Body.String())
+			assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+		})
+	}
+}
+
+func TestStopTwice(t *testing.T) {
+	l := New(LimitPath("/a", New()))
+
+	assert.NotPanics(t, func() {
+		l.Stop()
+		l.Stop()
+	})
+}
+
+func TestStopContext(t *testing.T) {
+	l := New(CleanupFrequency(time.Millisecond), LimitPath("/a", New()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(t, l.StopContext(ctx))
+
+	select {
+	case <-l.(*limiter).done:
+	default:
+		t.Fatal("cleanup routine is still running")
+	}
+
+	select {
+	case <-l.(*limiter).opts.routes["/a"].(*limiter).done:
+	default:
+		t.Fatal("route cleanup routine is still running")
+	}
+
+	assert.NoError(t, l.StopContext(ctx))
+}
+
+type flushStore struct {
+	stubStore
+	flushed int
+	err     error
+}
+
+func (s *flushStore) Flush() error {
+	s.flushed++
+	return s.err
+}
+
+func TestStopAndDrain(t *testing.T) {
+	l := New(RecordTTL(time.Minute), CleanupFrequency(time.Hour))
+
+	l.allow("1.1.1.1")
+	l.allow("2.2.2.2")
+	s := l.(*limiter).store.(*memoryStore)
+	s.get("1.1.1.1").touch(time.Now().Add(-time.Hour))
+
+	assert.NoError(t, l.StopAndDrain())
+
+	select {
+	case <-l.(*limiter).done:
+	default:
+		t.Fatal("cleanup routine is still running")
+	}
+
+	assert.Nil(t, s.get("1.1.1.1"), "final cleanup ran")
+	assert.NotNil(t, s.get("2.2.2.2"))
+}
+
+func TestStopAndDrainFlush(t *testing.T) {
+	errFlush := errors.New("flush failed")
+	route := &flushStore{err: errFlush}
+	store := &flushStore{}
+
+	l := New(WithStore(store), LimitPath("/a", New(WithStore(route))))
+
+	assert.ErrorIs(t, l.StopAndDrain(), errFlush)
+	assert.Equal(t, 1, store.flushed)
+	assert.Equal(t, 1, route.flushed)
+}
+
+type closeStore struct {
+	stubStore
+	closed int
+	err    error
+}
+
+func (s *closeStore) Close() error {
+	s.closed++
+	return s.err
+}
+
+func TestClose(t *testing.T) {
+	store := &closeStore{}
+	route := &closeStore{}
+
+	var c io.Closer = New(WithStore(store), LimitPath("/a", New(WithStore(route))))
+
+	assert.NoError(t, c.Close())
+	assert.NoError(t, c.Close())
+	assert.Equal(t, 1, store.closed)
+	assert.Equal(t, 1, route.closed)
+}
+
+func TestWithoutAutoCleanup(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	c := &manualClock{now: time.Unix(0, 0)}
+	ls := make([]Limiter, 10)
+	for i := range ls {
+		ls[i] = New(WithoutAutoCleanup(), WithClock(c), RecordTTL(time.Minute))
+	}
+
+	assert.Less(t, runtime.NumGoroutine()-before, len(ls), "no cleanup routine is started")
+
+	l := ls[0]
+	l.Allow("1.1.1.1")
+	c.now = c.now.Add(30 * time.Second)
+	l.Allow("2.2.2.2")
+	assert.Equal(t, 2, l.Stats().Visitors)
+
+	c.now = c.now.Add(45 * time.Second)
+	l.Cleanup()
+	assert.Equal(t, 1, l.Stats().Visitors, "only expired visitor is removed")
+
+	c.now = c.now.Add(time.Hour)
+	l.Cleanup()
+	assert.Equal(t, 0, l.Stats().Visitors)
+
+	done := make(chan struct{})
+	go func() {
+		for _, l := range ls {
+			l.Stop()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Stop blocked without cleanup routine")
+	}
+}
+
+func TestCleanupChildren(t *testing.T) {
+	c := &manualClock{now: time.Unix(0, 0)}
+	child := New(WithoutAutoCleanup(), WithClock(c), RecordTTL(time.Minute))
+	l := New(WithoutAutoCleanup(), WithClock(c), RecordTTL(time.Minute), LimitPath("/api/", child))
+	defer l.Stop()
+
+	child.Allow("1.1.1.1")
+	c.now = c.now.Add(time.Hour)
+	l.Cleanup()
+
+	assert.Equal(t, 0, child.Stats().Visitors)
+}
+
+func TestCloseError(t *testing.T) {
+	errClose := errors.New("close failed")
+	l := New(LimitPath("/a", New(WithStore(&closeStore{err: errClose}))))
+
+	assert.ErrorIs(t, l.Close(), errClose)
+	assert.NoError(t, l.Close())
+}
+
+func TestStopContextExpired(t *testing.T) {
+	lim, err := newLimiter()
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.ErrorIs(t, lim.StopContext(ctx), context.Canceled)
+}
+
+func TestBlocked(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         []option
+		ip           string
+		expectedCode int
+	}{
+		{
+			name:         "blocked ip",
+			opts:         []option{BlockedIPs("1.1.1.1")},
+			ip:           "1.1.1.1",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "blocked prefix",
+			opts:         []option{BlockedPrefixes("1.1.")},
+			ip:           "1.1.2.2",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "not blocked",
+			opts:         []option{BlockedIPs("1.1.1.1")},
+			ip:           "2.2.2.2",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "block over whitelist",
+			opts:         []option{BlockedIPs("1.1.1.1"), AllowedIPs("1.1.1.1")},
+			ip:           "1.1.1.1",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "custom status",
+			opts:         []option{BlockedIPs("1.1.1.1"), BlockStatus(http.StatusUnauthorized)},
+			ip:           "1.1.1.1",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "blocked with key func",
+			opts:         []option{BlockedIPs("1.1.1.1"), KeyFunc(func(r *http.Request) string { return r.Header.Get("Authorization") })},
+			ip:           "1.1.1.1",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "blocked with tenant header",
+			opts:         []option{BlockedIPs("1.1.1.1"), TenantHeader("X-Tenant-ID")},
+			ip:           "1.1.1.1",
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(append(tt.opts, RpsWithBurst(1000, 1000))...)
+			defer l.Stop()
+
+			h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set(XOFF, tt.ip)
+			req.Header.Set("Authorization", "token")
+			req.Header.Set("X-Tenant-ID", "acme")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedCode, rec.Code)
+		})
+	}
+}
+
+func TestGinBlocked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for name, opt := range map[string]option{
+		"allowed":      AllowedIPs("1.1.1.1"),
+		"gin_key_func": GinKeyFunc(func(c *gin.Context) string { return c.GetHeader("Authorization") }),
+	} {
+		t.Run(name, func(t *testing.T) {
+			l := New(BlockedIPs("1.1.1.1"), opt)
+			defer l.Stop()
+
+			r := gin.New()
+			r.Use(GinLimit(l))
+			r.GET("/test", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set(XOFF, "1.1.1.1")
+			req.Header.Set("Authorization", "token")
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		})
+	}
+}
+
+func TestInvalidStatus(t *testing.T) {
+	for _, opt := range []option{BlockStatus(0), RejectStatus(0), RejectStatus(1000)} {
+		_, err := NewWithError(opt)
+		assert.ErrorIs(t, err, ErrInvalidOption)
+	}
+}
+
+func TestCostFunc(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cost := CostFunc(func(r *http.Request) int {
+		if r.URL.Path == "/batch" {
+			return 5
+		}
+
+		return 0
+	})
+
+	handlers := middlewares(nil)
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			l := New(RpsWithBurst(1, 10), cost)
+			defer l.Stop()
+
+			h := handler(l)
+			do := func(path string) int {
+				req := httptest.NewRequest(http.MethodPost, path, nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				return rec.Code
+			}
+
+			assert.Equal(t, http.StatusOK, do("/batch"))
+			assert.Equal(t, http.StatusOK, do("/batch"))
+			assert.Equal(t, http.StatusTooManyRequests, do("/batch"))
+			assert.Equal(t, http.StatusTooManyRequests, do("/single"), "bucket is empty")
+		})
+	}
+}
+
+func TestContentLengthCost(t *testing.T) {
+	tests := []struct {
+		name     string
+		unit     int
+		length   int64
+		expected int
+	}{
+		{name: "bytes", unit: 1, length: 1500, expected: 1500},
+		{name: "chunks rounded up", unit: 1024, length: 1500, expected: 2},
+		{name: "unknown length", unit: 1024, length: -1, expected: 1},
+		{name: "empty body", unit: 1024, length: 0, expected: 1},
+		{name: "invalid unit", unit: 0, length: 10, expected: 10},
+		{name: "huge body", unit: 1, length: math.MaxInt64, expected: math.MaxInt32},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/upload", nil)
+			r.ContentLength = tt.length
+			assert.Equal(t, tt.expected, ContentLengthCost(tt.unit)(r))
+		})
+	}
+}
+
+func TestContentLengthCostThrottlesUploads(t *testing.T) {
+	// 1 KiB per second with 10 KiB burst.
+	l := New(RpsWithBurst(1024, 10*1024), CostFunc(ContentLengthCost(1)), WithClock(&manualClock{now: time.Unix(0, 0)}))
+	defer l.Stop()
+
+	h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	upload := func(size int) int {
+		req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(strings.Repeat("x", size)))
+		req.Header.Set(XOFF, "1.1.1.1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, upload(8*1024))
+	assert.Equal(t, http.StatusTooManyRequests, upload(8*1024), "big upload is throttled")
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, http.StatusOK, upload(100), "small uploads fit into what is left")
+	}
+
+	assert.Equal(t, http.StatusTooManyRequests, upload(20*1024), "upload larger than burst is never allowed")
+}
+
+func TestAllow(t *testing.T) {
+	l := New(RpsWithBurst(1, 3), AllowedIPs("9.9.9.9"), BlockedIPs("6.6.6.6"))
+	defer l.Stop()
+
+	l.Override("partner", 1, 5)
+
+	count := func(key string) int {
+		allowed := 0
+		for i := 0; i < 10; i++ {
+			if l.Allow(key) {
+				allowed++
+			}
+		}
+
+		return allowed
+	}
+
+	assert.Equal(t, 3, count("tenant"))
+	assert.Equal(t, 5, count("partner"))
+	assert.Equal(t, 10, count("9.9.9.9"))
+	assert.Equal(t, 0, count("6.6.6.6"))
+}
+
+func TestRetryAfterKey(t *testing.T) {
+	for _, algo := range []Algo{AlgoTokenBucket, AlgoGCRA} {
+		t.Run(fmt.Sprint(algo), func(t *testing.T) {
+			c := &manualClock{now: time.Unix(1000, 0)}
+			l := New(RpsWithBurst(1, 2), Algorithm(algo), WithClock(c), AllowedIPs("9.9.9.9"))
+			defer l.Stop()
+
+			assert.Zero(t, l.RetryAfter("1.1.1.1"), "unknown key is allowed now")
+
+			assert.True(t, l.Allow("1.1.1.1"))
+			assert.Zero(t, l.RetryAfter("1.1.1.1"))
+			assert.True(t, l.Allow("1.1.1.1"))
+
+			var last time.Duration = time.Hour
+			for i := 0; i < 3; i++ {
+				d := l.RetryAfter("1.1.1.1")
+				assert.Positive(t, d)
+				assert.Less(t, d, last)
+				last = d
+
+				assert.Equal(t, d, l.RetryAfter("1.1.1.1"), "checking takes no token")
+				c.now = c.now.Add(300 * time.Millisecond)
+			}
+
+			c.now = c.now.Add(100 * time.Millisecond)
+			assert.Zero(t, l.RetryAfter("1.1.1.1"))
+			assert.True(t, l.Allow("1.1.1.1"))
+
+			for i := 0; i < 5; i++ {
+				l.Allow("9.9.9.9")
+			}
+			assert.Zero(t, l.RetryAfter("9.9.9.9"))
+		})
+	}
+
+	l := New(RpsWithBurst(1, 0))
+	defer l.Stop()
+	assert.Equal(t, time.Second, l.RetryAfter("1.1.1.1"), "key that is never allowed gets period")
+}
+
+func TestBackoffHint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			l := New(RpsWithBurst(1, 1), WithBackoffHint(200*time.Millisecond))
+			defer l.Stop()
+
+			h := handler(l)
+
+			var rec *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				rec = httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+			}
+
+			assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+			assert.Contains(t, rec.Header().Get("Content-Type"), "application/json")
+
+			var hint backoffHint
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &hint))
+			assert.Equal(t, tooManyReqMsg, hint.Error)
+			assert.GreaterOrEqual(t, hint.RetryAfterMs, int64(900))
+			assert.LessOrEqual(t, hint.RetryAfterMs, int64(1200))
+		})
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			l := New(Period(1, 5*time.Second), Burst(1), RejectStatus(http.StatusServiceUnavailable), WithProblemDetails(), WithBackoffHint(0))
+			defer l.Stop()
+
+			h := handler(l)
+
+			var rec *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				rec = httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+			}
+
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			assert.Equal(t, problemJSON, rec.Header().Get("Content-Type"))
+			assert.Equal(t, "5", rec.Header().Get(retryAfter))
+
+			var body map[string]any
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, map[string]any{
+				"type":       "about:blank",
+				"title":      http.StatusText(http.StatusServiceUnavailable),
+				"status":     float64(http.StatusServiceUnavailable),
+				"detail":     tooManyReqMsg,
+				"retryAfter": float64(5),
+			}, body)
+		})
+	}
+}
+
+func TestInvalidBackoffHint(t *testing.T) {
+	_, err := NewWithError(WithBackoffHint(-time.Second))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestWhitelistFunc(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	internal := func(r *http.Request) bool {
+		return r.Header.Get("X-Service-Token") == "secret"
+	}
+
+	handlers := middlewares(nil)
+
+	tests := []struct {
+		name     string
+		opts     []option
+		token    string
+		expected []int
+		checked  uint64
+	}{
+		{
+			name:     "token",
+			opts:     []option{WhitelistFunc(internal)},
+			token:    "secret",
+			expected: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:     "no_token",
+			opts:     []option{WhitelistFunc(internal)},
+			expected: []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests},
+			checked:  3,
+		},
+		{
+			name:     "blocked",
+			opts:     []option{WhitelistFunc(internal), BlockedIPs("1.1.1.1")},
+			token:    "secret",
+			expected: []int{http.StatusForbidden},
+		},
+	}
+
+	for name, handler := range handlers {
+		for _, tt := range tests {
+			t.Run(name+"_"+tt.name, func(t *testing.T) {
+				l := New(append(tt.opts, RpsWithBurst(1, 1))...)
+				defer l.Stop()
+
+				h := handler(l)
+
+				for _, code := range tt.expected {
+					req := httptest.NewRequest(http.MethodGet, "/test", nil)
+					req.Header.Set(XOFF, "1.1.1.1")
+					req.Header.Set("X-Service-Token", tt.token)
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, req)
+
+					assert.Equal(t, code, rec.Code)
+				}
+
+				st := l.Stats()
+				assert.Equal(t, tt.checked, st.Allowed+st.Rejected, "whitelisted requests dont take tokens")
+			})
+		}
+	}
+}
+
+func TestGinWhitelistFunc(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	l := New(RpsWithBurst(1, 1), GinWhitelistFunc(func(c *gin.Context) bool {
+		return c.GetHeader("X-Service-Token") == "secret"
+	}))
+	defer l.Stop()
+
+	router := gin.New()
+	router.Use(GinLimit(l))
+	router.NoRoute(func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set(XOFF, "1.1.1.1")
+		req.Header.Set("X-Service-Token", "secret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+}
+
+func TestWhenNoKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []option
+		expected []int
+	}{
+		{
+			name:     "default_fail_open",
+			expected: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:     "fail_open",
+			opts:     []option{WhenNoKey(FailOpen)},
+			expected: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:     "fail_closed",
+			opts:     []option{WhenNoKey(FailClosed)},
+			expected: []int{http.StatusTooManyRequests, http.StatusTooManyRequests},
+		},
+		{
+			name:     "shared_bucket",
+			opts:     []option{WhenNoKey(SharedBucket)},
+			expected: []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(append(tt.opts, RpsWithBurst(1, 1))...)
+			defer l.Stop()
+
+			h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			for _, code := range tt.expected {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.RemoteAddr = ""
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				assert.Equal(t, code, rec.Code)
+			}
+		})
+	}
+}
+
+func TestIPHeaders(t *testing.T) {
+	l := New(IPHeaders("CF-Connecting-IP", "X-Forwarded-For", "X-Real-IP"))
+	defer l.Stop()
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected string
+	}{
+		{
+			name:     "first",
+			headers:  map[string]string{"CF-Connecting-IP": "1.1.1.1", "X-Forwarded-For": "2.2.2.2", "X-Real-IP": "3.3.3.3"},
+			expected: "1.1.1.1",
+		},
+		{
+			name:     "second",
+			headers:  map[string]string{"X-Forwarded-For": "2.2.2.2, 4.4.4.4", "X-Real-IP": "3.3.3.3"},
+			expected: "2.2.2.2",
+		},
+		{
+			name:     "last",
+			headers:  map[string]string{"X-Real-IP": "3.3.3.3"},
+			expected: "3.3.3.3",
+		},
+		{
+			name:     "remote_addr",
+			expected: "192.0.2.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			assert.Equal(t, tt.expected, l.key(req, remoteAddrIP(req)))
+		})
+	}
+}
+
+func TestInvalidIPHeaders(t *testing.T) {
+	for _, opt := range []option{IPHeaders(), IPHeaders("X-Real-IP", "")} {
+		_, err := NewWithError(opt)
+		assert.ErrorIs(t, err, ErrInvalidOption)
+	}
+}
+
+func TestIPHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	l, err := NewWithError(IPHeader("X-Real-IP"), RpsWithBurst(1, 1))
+	assert.NoError(t, err)
+	defer l.Stop()
+
+	router := gin.New()
+	router.Use(GinLimit(l))
+	router.NoRoute(func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	do := func(ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-Real-IP", ip)
+		req.Header.Set(XOFF, "9.9.9.9")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, do("1.1.1.1"))
+	assert.Equal(t, http.StatusTooManyRequests, do("1.1.1.1"))
+	assert.Equal(t, http.StatusOK, do("2.2.2.2"))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("X-Real-IP", "3.3.3.3")
+	assert.Equal(t, "3.3.3.3", l.key(req, remoteAddrIP(req)))
+}
+
+func TestWhitelistCache(t *testing.T) {
+	l := New(AllowedPrefixes("10.0."), AllowedCIDRs("192.168.0.0/16"), WhitelistCacheSize(2))
+	defer l.Stop()
+
+	lim := l.(*limiter)
+
+	for _, ip := range []string{"10.0.0.1", "192.168.1.1", "10.0.0.2", "10.0.0.1"} {
+		assert.True(t, l.whiteListed(ip))
+	}
+	assert.False(t, l.whiteListed("1.1.1.1"))
+
+	cached := 0
+	lim.whitelisted.Range(func(k, v any) bool {
+		cached++
+		return true
+	})
+	assert.Equal(t, 2, cached, "cache is bounded")
+
+	_, ok := lim.whitelisted.Load("10.0.0.1")
+	assert.True(t, ok)
+	_, ok = lim.whitelisted.Load("1.1.1.1")
+	assert.False(t, ok, "only whitelisted ips are cached")
+}
+
+func BenchmarkWhitelisted(b *testing.B) {
+	prefixes := make([]string, 0, 64)
+	for i := 0; i < 64; i++ {
+		prefixes = append(prefixes, fmt.Sprintf("172.%d.", i))
+	}
+
+	for _, size := range []int{0, defaultWhitelistCache} {
+		b.Run(fmt.Sprintf("cache_%d", size), func(b *testing.B) {
+			l := New(AllowedPrefixes(prefixes...), AllowedCIDRs("10.0.0.0/8"), WhitelistCacheSize(size))
+			defer l.Stop()
+
+			ips := []string{"10.1.1.1", "10.2.2.2", "172.63.0.1"}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				l.whiteListed(ips[i%len(ips)])
+			}
+		})
+	}
+}
+
+func TestClientIPFromContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keyByUser := KeyFunc(func(r *http.Request) string { return r.Header.Get("X-User") })
+
+	handlers := map[string]func(l Limiter, got *string) http.Handler{
+		"http": func(l Limiter, got *string) http.Handler {
+			return Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				*got, _ = ClientIPFromContext(r.Context())
+			}))
+		},
+		"gin": func(l Limiter, got *string) http.Handler {
+			router := gin.New()
+			router.Use(GinLimit(l))
+			router.NoRoute(func(c *gin.Context) {
+				*got, _ = ClientIPFromContext(c)
+
+				fromRequest, _ := ClientIPFromContext(c.Request.Context())
+				assert.Equal(t, *got, fromRequest)
+			})
+
+			return router
+		},
+	}
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			l := New(keyByUser)
+			defer l.Stop()
+
+			var got string
+			h := handler(l, &got)
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set(XOFF, "1.1.1.1, 2.2.2.2")
+			req.Header.Set("X-User", "user")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, "1.1.1.1", got)
+		})
+	}
+
+	_, ok := ClientIPFromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestDryRun(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			o := &countingObserver{}
+			rejected := 0
+
+			l := New(RpsWithBurst(1, 1), DryRun(true), WithObserver(o), OnReject(func(string, *http.Request) {
+				rejected++
+			}))
+			defer l.Stop()
+
+			h := handler(l)
+
+			for i := 0; i < 3; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Empty(t, rec.Header().Get(retryAfter))
+			}
+
+			assert.Equal(t, 2, rejected)
+			assert.Equal(t, uint64(2), l.Stats().Rejected)
+			assert.Equal(t, 2, o.rejected)
+		})
+	}
+}
+
+func TestKeyBySubnet(t *testing.T) {
+	handlers := middlewares(nil)
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			l := New(RpsWithBurst(1, 1), KeyBySubnet(24, 64), AllowedIPs("1.1.1.9"))
+			defer l.Stop()
+
+			h := handler(l)
+			do := func(ip string) int {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set(XOFF, ip)
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				return rec.Code
+			}
+
+			assert.Equal(t, http.StatusOK, do("1.1.1.1"))
+			assert.Equal(t, http.StatusTooManyRequests, do("1.1.1.2"), "same /24 shares bucket")
+			assert.Equal(t, http.StatusOK, do("1.1.2.1"), "other /24 has own bucket")
+			assert.Equal(t, http.StatusOK, do("1.1.1.9"), "whitelist matches exact ip")
+
+			assert.Equal(t, http.StatusOK, do("2001:db8::1"))
+			assert.Equal(t, http.StatusTooManyRequests, do("2001:db8::2:1"), "same /64 shares bucket")
+		})
+	}
+}
+
+func TestKeyBySubnetInvalid(t *testing.T) {
+	_, err := NewWithError(KeyBySubnet(33, 64))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+
+	_, err = NewWithError(KeyBySubnet(24, -1))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestDelegateErrors(t *testing.T) {
+	l := New(RpsWithBurst(1, 1), DelegateErrors())
+	defer l.Stop()
+
+	var errs []*gin.Error
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Next()
+		errs = c.Errors
+	})
+	router.Use(GinLimit(l))
+	router.NoRoute(func(c *gin.Context) { c.String(http.StatusOK, "OK") })
+
+	var rec *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set(XOFF, "1.1.1.1")
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+	}
+
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.Equal(t, "1", rec.Header().Get(retryAfter))
+	if assert.Len(t, errs, 1) {
+		assert.ErrorIs(t, errs[0], ErrRateLimited)
+
+		var rle *RateLimitError
+		assert.ErrorAs(t, errs[0], &rle)
+		assert.Equal(t, "1.1.1.1", rle.IP)
+		assert.Equal(t, http.StatusTooManyRequests, rle.Status)
+	}
+}
+
+func TestBypassHeader(t *testing.T) {
+	handlers := middlewares(nil)
+
+	tests := []struct {
+		name     string
+		value    string
+		expected []int
+	}{
+		{
+			name:     "bypass",
+			value:    "secret",
+			expected: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:     "wrong_value",
+			value:    "secre",
+			expected: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:     "no_header",
+			expected: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+	}
+
+	for name, handler := range handlers {
+		for _, tt := range tests {
+			t.Run(name+"_"+tt.name, func(t *testing.T) {
+				l := New(RpsWithBurst(1, 1), BypassHeader("X-Health-Check", "secret"))
+				defer l.Stop()
+
+				h := handler(l)
+				for i, code := range tt.expected {
+					req := httptest.NewRequest(http.MethodGet, "/health", nil)
+					req.Header.Set(XOFF, "1.1.1.1")
+					if tt.value != "" {
+						req.Header.Set("X-Health-Check", tt.value)
+					}
+
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, req)
+					assert.Equal(t, code, rec.Code, "request %d", i)
+				}
+			})
+		}
+	}
+}
+
+func TestBypassHeaderInvalid(t *testing.T) {
+	_, err := NewWithError(BypassHeader("X-Health-Check", ""))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestWithLogger(t *testing.T) {
+	handlers := middlewares(nil)
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			l := New(RpsWithBurst(1, 1), WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+			defer l.Stop()
+
+			h := handler(l)
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodPost, "/login", nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				req.RemoteAddr = "10.0.0.1:1234"
+				h.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			var record map[string]any
+			assert.NoError(t, json.Unmarshal(buf.Bytes(), &record), "one record is logged")
+			assert.Equal(t, "WARN", record["level"])
+			assert.Equal(t, "request rate limited", record["msg"])
+			assert.Equal(t, "1.1.1.1", record["key"])
+			assert.Equal(t, "/login", record["path"])
+			assert.Equal(t, http.MethodPost, record["method"])
+			assert.Equal(t, "10.0.0.1:1234", record["remote_addr"])
+			assert.Equal(t, "1.1.1.1", record["ip_header"])
+		})
+	}
+}
+
+func TestPenalizeRejections(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []option
+		expected time.Duration
+	}{
+		{
+			name:     "without_penalty",
+			expected: time.Second,
+		},
+		{
+			name:     "with_penalty",
+			opts:     []option{PenalizeRejections(2)},
+			expected: 3 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(append([]option{RpsWithBurst(1, 1)}, tt.opts...)...)
+			defer l.Stop()
+
+			assert.True(t, l.allow("1.1.1.1"))
+			assert.False(t, l.allow("1.1.1.1"))
+			assert.InDelta(t, tt.expected, l.retryAfter("1.1.1.1", ""), float64(100*time.Millisecond))
+		})
+	}
+
+	_, err := NewWithError(PenalizeRejections(-1))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestGinRejectionLogger(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		global bytes.Buffer
+	)
+
+	l := New(RpsWithBurst(1, 1),
+		GinRejectionLogger(slog.New(slog.NewJSONHandler(&buf, nil))),
+		WithLogger(slog.New(slog.NewJSONHandler(&global, nil))),
+	)
+	defer l.Stop()
+
+	router := gin.New()
+	router.Use(GinLimit(l))
+	router.NoRoute(func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/search", nil)
+		req.Header.Set(XOFF, "1.1.1.1")
+		req.RemoteAddr = "10.0.0.1:1234"
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var record map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record), "one record is logged")
+	assert.Equal(t, "WARN", record["level"])
+	assert.Equal(t, "1.1.1.1", record["key"])
+	assert.Equal(t, "/search", record["path"])
+	assert.Equal(t, http.MethodGet, record["method"])
+	assert.Equal(t, "10.0.0.1", record["client_ip"])
+	assert.Equal(t, "1.1.1.1", record["ip_header"])
+	assert.Empty(t, global.String(), "gin logger takes precedence")
+}
@@ -0,0 +1,89 @@
+// Package limitertest provides helpers for testing code that uses limiter.
+package limitertest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/eldarthepro/limiter"
+)
+
+type (
+	// FakeClock is limiter.Clock that moves only when Advance is called. Safe for concurrent use.
+	FakeClock struct {
+		now     time.Time
+		tickers []*fakeTicker
+		sync.Mutex
+	}
+
+	fakeTicker struct {
+		clock   *FakeClock
+		c       chan time.Time
+		period  time.Duration
+		next    time.Time
+		stopped bool
+	}
+)
+
+// NewFakeClock returns clock starting at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns current fake time.
+func (c *FakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.now
+}
+
+// NewTicker returns ticker that ticks when clock is advanced past its next tick. Like time.Ticker, it drops ticks for slow receivers.
+func (c *FakeClock) NewTicker(d time.Duration) limiter.Ticker {
+	c.Lock()
+	defer c.Unlock()
+
+	t := &fakeTicker{
+		clock:  c,
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   c.now.Add(d),
+	}
+	c.tickers = append(c.tickers, t)
+
+	return t
+}
+
+// Advance moves clock forward by d, firing tickers that are due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.now = c.now.Add(d)
+
+	for _, t := range c.tickers {
+		if t.stopped || t.next.After(c.now) {
+			continue
+		}
+
+		for !t.next.After(c.now) {
+			t.next = t.next.Add(t.period)
+		}
+
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+
+	t.stopped = true
+}
@@ -0,0 +1,76 @@
+package limitertest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eldarthepro/limiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClockRefill(t *testing.T) {
+	c := NewFakeClock(time.Now())
+
+	l := limiter.New(limiter.WithClock(c), limiter.RpsWithBurst(1, 1))
+	defer l.Stop()
+
+	assert.True(t, l.Allow("1.1.1.1"))
+	assert.False(t, l.Allow("1.1.1.1"))
+
+	c.Advance(999 * time.Millisecond)
+	assert.False(t, l.Allow("1.1.1.1"))
+
+	c.Advance(time.Millisecond)
+	assert.True(t, l.Allow("1.1.1.1"))
+}
+
+func TestFakeClockExpiry(t *testing.T) {
+	c := NewFakeClock(time.Now())
+
+	l := limiter.New(limiter.WithClock(c), limiter.RecordTTL(time.Minute), limiter.CleanupFrequency(time.Minute))
+	defer l.Stop()
+
+	l.Allow("1.1.1.1")
+	c.Advance(30 * time.Second)
+	l.Allow("2.2.2.2")
+
+	c.Advance(30 * time.Second)
+	assert.Eventually(t, func() bool { return l.Stats().Visitors == 1 }, time.Second, time.Millisecond)
+
+	c.Advance(time.Minute)
+	assert.Eventually(t, func() bool { return l.Stats().Visitors == 0 }, time.Second, time.Millisecond)
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	c := NewFakeClock(time.Now())
+	ti := c.NewTicker(time.Second)
+
+	c.Advance(500 * time.Millisecond)
+	select {
+	case <-ti.C():
+		t.Fatal("ticked too early")
+	default:
+	}
+
+	c.Advance(3 * time.Second)
+	<-ti.C()
+
+	select {
+	case <-ti.C():
+		t.Fatal("missed ticks are not delivered")
+	default:
+	}
+
+	ti.Stop()
+	c.Advance(time.Hour)
+	select {
+	case <-ti.C():
+		t.Fatal("stopped ticker ticked")
+	default:
+	}
+}
+
+func TestWithNilClock(t *testing.T) {
+	_, err := limiter.NewWithError(limiter.WithClock(nil))
+	assert.ErrorIs(t, err, limiter.ErrInvalidOption)
+}
@@ -0,0 +1,37 @@
+package limiter
+
+import "context"
+
+// MessageLimiter limits messages within long lived connections, such as WebSocket ones, that WebSocketPolicy only limits at handshake.
+// Every connection has its own bucket keyed by connection id, counted by the same store and algorithms as requests.
+type MessageLimiter struct {
+	l Limiter
+}
+
+// NewMessageLimiter returns MessageLimiter limiting messages of every connection with opts, for example RpsWithBurst(10, 20) for
+// 10 messages per second with bursts of 20. GlobalLimit limits messages of all connections together. Options of http requests, such as
+// ip headers and KeyFunc, have no effect. It should be stopped with Stop when it is not needed anymore.
+func NewMessageLimiter(opts ...option) *MessageLimiter {
+	return &MessageLimiter{l: New(opts...)}
+}
+
+// Allow reports whether one more message of connection connID is allowed, for use in its read loop.
+func (m *MessageLimiter) Allow(connID string) bool {
+	return m.l.Allow(connID)
+}
+
+// Wait blocks until message of connection connID is allowed or ctx is done, like Limiter's Wait, so read loop can slow down the sender
+// instead of dropping its messages.
+func (m *MessageLimiter) Wait(ctx context.Context, connID string) error {
+	return m.l.Wait(ctx, connID)
+}
+
+// Forget removes bucket of connection connID, call it when connection is closed, so bucket does not wait for RecordTTL to expire.
+func (m *MessageLimiter) Forget(connID string) {
+	m.l.Reset(connID)
+}
+
+// Stop stops cleanup routine of MessageLimiter.
+func (m *MessageLimiter) Stop() {
+	m.l.Stop()
+}
@@ -0,0 +1,51 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageLimiter(t *testing.T) {
+	c := &manualClock{now: time.Unix(0, 0)}
+	m := NewMessageLimiter(RpsWithBurst(10, 5), WithClock(c))
+	defer m.Stop()
+
+	// rapid messages of one connection are throttled after burst.
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		if m.Allow("conn-1") {
+			allowed++
+		}
+	}
+	assert.Equal(t, 5, allowed)
+
+	// other connections have their own buckets.
+	assert.True(t, m.Allow("conn-2"))
+
+	c.now = c.now.Add(100 * time.Millisecond)
+	assert.True(t, m.Allow("conn-1"))
+	assert.False(t, m.Allow("conn-1"))
+
+	m.Forget("conn-1")
+	for i := 0; i < 5; i++ {
+		assert.True(t, m.Allow("conn-1"))
+	}
+}
+
+func TestMessageLimiterWait(t *testing.T) {
+	m := NewMessageLimiter(RpsWithBurst(100, 1))
+	defer m.Stop()
+
+	assert.True(t, m.Allow("conn-1"))
+
+	start := time.Now()
+	assert.NoError(t, m.Wait(context.Background(), "conn-1"))
+	assert.GreaterOrEqual(t, time.Since(start), 5*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, m.Wait(ctx, "conn-1"))
+}
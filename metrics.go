@@ -0,0 +1,87 @@
+package limiter
+
+import (
+	"time"
+)
+
+type (
+	// Observer is told about decisions and cleanups of limiter, for example to export them as metrics, see WithObserver. Observe gets
+	// every request checked against the limit, so whitelisted ones are not included. SetVisitors is called on cleanup, if store can
+	// report its size, and so is AddExpired, if store can tell how many visitors it removed. Package promlimit implements it with prometheus.
+	Observer interface {
+		Observe(allowed bool)
+		SetVisitors(n int)
+		AddExpired(n int)
+	}
+
+	// decisionObserver is implemented by observers that measure how long store takes to decide on a request.
+	decisionObserver interface {
+		ObserveDecision(d time.Duration)
+	}
+
+	metrics struct {
+		observer Observer
+		// decision is nil unless observer measures decisions.
+		decision decisionObserver
+	}
+)
+
+// WithObserver sets observer told about every decision of limiter and its cleanups. Observers that have ObserveDecision(time.Duration)
+// method also get time the store took to decide, including lock waits of in-memory store and round trips of redis one.
+func WithObserver(o Observer) option {
+	return func(opts *limiterOptions) {
+		opts.observer = o
+	}
+}
+
+func newMetrics(o Observer) *metrics {
+	m := &metrics{observer: o}
+	m.decision, _ = o.(decisionObserver)
+
+	return m
+}
+
+func (m *metrics) observe(allowed bool) {
+	if m == nil {
+		return
+	}
+
+	m.observer.Observe(allowed)
+}
+
+// startDecision returns time decision starts at, or zero time if decisions are not measured.
+func (m *metrics) startDecision() time.Time {
+	if m == nil || m.decision == nil {
+		return time.Time{}
+	}
+
+	return time.Now()
+}
+
+// observeDecision records duration of decision started at start, unless it is zero.
+func (m *metrics) observeDecision(start time.Time) {
+	if start.IsZero() {
+		return
+	}
+
+	m.decision.ObserveDecision(time.Since(start))
+}
+
+func (m *metrics) setVisitors(s Store) {
+	if m == nil {
+		return
+	}
+
+	if l, ok := s.(sizer); ok {
+		m.observer.SetVisitors(l.Len())
+	}
+}
+
+// addExpired counts n visitors removed by cleanup.
+func (m *metrics) addExpired(n int) {
+	if m == nil {
+		return
+	}
+
+	m.observer.AddExpired(n)
+}
@@ -0,0 +1,93 @@
+package limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingObserver is Observer that remembers what it was told.
+type countingObserver struct {
+	sync.Mutex
+	allowed, rejected, visitors, expired int
+}
+
+func (o *countingObserver) Observe(allowed bool) {
+	o.Lock()
+	defer o.Unlock()
+
+	if allowed {
+		o.allowed++
+	} else {
+		o.rejected++
+	}
+}
+
+func (o *countingObserver) SetVisitors(n int) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.visitors = n
+}
+
+func (o *countingObserver) AddExpired(n int) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.expired += n
+}
+
+// timingObserver is countingObserver that counts measured decisions too.
+type timingObserver struct {
+	countingObserver
+	decisions int
+}
+
+func (o *timingObserver) ObserveDecision(time.Duration) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.decisions++
+}
+
+func TestWithObserver(t *testing.T) {
+	o := &countingObserver{}
+	c := &manualClock{now: time.Unix(0, 0)}
+	l := New(WithObserver(o), RpsWithBurst(1, 2), AllowedIPs("9.9.9.9"), RecordTTL(time.Minute), WithClock(c), WithoutAutoCleanup())
+	defer l.Stop()
+
+	h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, ip := range []string{"1.1.1.1", "1.1.1.1", "1.1.1.1", "2.2.2.2", "9.9.9.9"} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set(XOFF, ip)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	l.Cleanup()
+	assert.Equal(t, 3, o.allowed)
+	assert.Equal(t, 1, o.rejected, "whitelisted request is not observed")
+	assert.Equal(t, 2, o.visitors)
+
+	c.now = c.now.Add(time.Hour)
+	l.Cleanup()
+	assert.Equal(t, 2, o.expired)
+	assert.Zero(t, o.visitors)
+}
+
+func TestWithObserverDecisions(t *testing.T) {
+	counting, timing := &countingObserver{}, &timingObserver{}
+
+	for _, o := range []Observer{counting, timing} {
+		l := New(WithObserver(o), AllowedIPs("9.9.9.9"))
+		l.Allow("1.1.1.1")
+		l.Allow("9.9.9.9")
+		l.Stop()
+	}
+
+	assert.Equal(t, 1, counting.allowed)
+	assert.Equal(t, 1, timing.decisions, "whitelisted key is not decided by store")
+}
@@ -0,0 +1,157 @@
+// Package promlimit exports decisions and visitors of limiter as prometheus metrics.
+package promlimit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/eldarthepro/limiter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type (
+	// Metrics is limiter.Observer counting requests and visitors of limiter in prometheus metrics, see New.
+	Metrics struct {
+		allowed  prometheus.Counter
+		rejected prometheus.Counter
+		visitors prometheus.Gauge
+		expired  prometheus.Counter
+	}
+
+	// decisionMetrics are Metrics that also measure decisions, see DecisionDuration.
+	decisionMetrics struct {
+		*Metrics
+		decision prometheus.Histogram
+	}
+
+	options struct {
+		labels  prometheus.Labels
+		buckets []float64
+		errs    []error
+	}
+
+	option func(*options)
+)
+
+// New registers limiter_requests_allowed_total, limiter_requests_rejected_total, limiter_visitors and limiter_visitors_expired_total
+// in reg and returns observer that updates them, to be set with limiter.WithObserver. Nothing is registered if one of metrics cant be.
+func New(reg prometheus.Registerer, opts ...option) (limiter.Observer, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if len(o.errs) > 0 {
+		return nil, o.errs[0]
+	}
+
+	m := &Metrics{
+		allowed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "limiter_requests_allowed_total",
+			Help:        "Number of requests allowed by limiter.",
+			ConstLabels: o.labels,
+		}),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "limiter_requests_rejected_total",
+			Help:        "Number of requests rejected by limiter.",
+			ConstLabels: o.labels,
+		}),
+		visitors: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "limiter_visitors",
+			Help:        "Number of visitors tracked by limiter after last cleanup.",
+			ConstLabels: o.labels,
+		}),
+		expired: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "limiter_visitors_expired_total",
+			Help:        "Number of visitors removed by cleanup after RecordTTL.",
+			ConstLabels: o.labels,
+		}),
+	}
+
+	var observer limiter.Observer = m
+	collectors := []prometheus.Collector{m.allowed, m.rejected, m.visitors, m.expired}
+	if o.buckets != nil {
+		d := &decisionMetrics{
+			Metrics: m,
+			decision: prometheus.NewHistogram(prometheus.HistogramOpts{
+				Name:        "limiter_decision_duration_seconds",
+				Help:        "Time store took to decide whether request is allowed.",
+				ConstLabels: o.labels,
+				Buckets:     o.buckets,
+			}),
+		}
+		observer = d
+		collectors = append(collectors, d.decision)
+	}
+
+	for i, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			for _, registered := range collectors[:i] {
+				reg.Unregister(registered)
+			}
+
+			return nil, fmt.Errorf("promlimit: register metrics: %w", err)
+		}
+	}
+
+	return observer, nil
+}
+
+// Label adds constant label to metrics, so several limiters can be registered in the same registry.
+func Label(name, value string) option {
+	return func(o *options) {
+		if o.labels == nil {
+			o.labels = make(prometheus.Labels)
+		}
+
+		o.labels[name] = value
+	}
+}
+
+// DecisionDuration adds limiter_decision_duration_seconds histogram to metrics, measuring how long the store takes to decide on
+// a request, including lock waits of in-memory store and round trips of redis one. Buckets are upper bounds in seconds, from 1µs to
+// about a quarter of a second by default. Buckets that are not increasing are reported by New with limiter.ErrInvalidOption.
+func DecisionDuration(buckets ...float64) option {
+	var errs []error
+
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			errs = append(errs, fmt.Errorf("%w: decision duration buckets %v are not increasing", limiter.ErrInvalidOption, buckets))
+			buckets = nil
+			break
+		}
+	}
+
+	if len(buckets) == 0 {
+		buckets = prometheus.ExponentialBuckets(1e-6, 4, 10)
+	}
+
+	return func(o *options) {
+		o.errs = append(o.errs, errs...)
+		o.buckets = buckets
+	}
+}
+
+// Observe counts request as allowed or rejected.
+func (m *Metrics) Observe(allowed bool) {
+	if allowed {
+		m.allowed.Inc()
+	} else {
+		m.rejected.Inc()
+	}
+}
+
+// SetVisitors sets number of visitors tracked by limiter.
+func (m *Metrics) SetVisitors(n int) {
+	m.visitors.Set(float64(n))
+}
+
+// AddExpired counts n visitors removed by cleanup.
+func (m *Metrics) AddExpired(n int) {
+	m.expired.Add(float64(n))
+}
+
+// ObserveDecision records duration of decision.
+func (m *decisionMetrics) ObserveDecision(d time.Duration) {
+	m.decision.Observe(d.Seconds())
+}
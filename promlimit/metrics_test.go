@@ -0,0 +1,141 @@
+package promlimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eldarthepro/limiter"
+	"github.com/eldarthepro/limiter/limitertest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	m, err := New(reg, Label("limiter", "api"))
+	assert.NoError(t, err)
+
+	l, err := limiter.NewWithError(limiter.WithObserver(m), limiter.RpsWithBurst(1, 2), limiter.AllowedIPs("9.9.9.9"))
+	assert.NoError(t, err)
+	defer l.Stop()
+
+	h := limiter.Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, ip := range []string{"1.1.1.1", "1.1.1.1", "1.1.1.1", "2.2.2.2", "9.9.9.9"} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set(limiter.XOFF, ip)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	l.Cleanup()
+
+	expected := `
+# HELP limiter_requests_allowed_total Number of requests allowed by limiter.
+# TYPE limiter_requests_allowed_total counter
+limiter_requests_allowed_total{limiter="api"} 3
+# HELP limiter_requests_rejected_total Number of requests rejected by limiter.
+# TYPE limiter_requests_rejected_total counter
+limiter_requests_rejected_total{limiter="api"} 1
+# HELP limiter_visitors Number of visitors tracked by limiter after last cleanup.
+# TYPE limiter_visitors gauge
+limiter_visitors{limiter="api"} 2
+# HELP limiter_visitors_expired_total Number of visitors removed by cleanup after RecordTTL.
+# TYPE limiter_visitors_expired_total counter
+limiter_visitors_expired_total{limiter="api"} 0
+`
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected)))
+}
+
+func TestMetricsDuplicateRegistration(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	_, err := New(reg, Label("limiter", "api"))
+	assert.NoError(t, err)
+
+	_, err = New(reg, Label("limiter", "api"), DecisionDuration())
+	assert.Error(t, err)
+
+	n, err := testutil.GatherAndCount(reg, "limiter_decision_duration_seconds")
+	assert.NoError(t, err)
+	assert.Zero(t, n, "nothing is registered by failed call")
+
+	_, err = New(reg, Label("limiter", "other"))
+	assert.NoError(t, err)
+}
+
+func TestDecisionDuration(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	m, err := New(reg, DecisionDuration())
+	assert.NoError(t, err)
+
+	l := limiter.New(limiter.WithObserver(m), limiter.RpsWithBurst(1, 1), limiter.AllowedIPs("9.9.9.9"))
+	defer l.Stop()
+
+	h := limiter.Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, ip := range []string{"1.1.1.1", "1.1.1.1", "2.2.2.2", "9.9.9.9"} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set(limiter.XOFF, ip)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+
+	var samples uint64
+	for _, f := range families {
+		if f.GetName() == "limiter_decision_duration_seconds" {
+			h := f.GetMetric()[0].GetHistogram()
+			samples = h.GetSampleCount()
+			assert.Len(t, h.GetBucket(), 10)
+		}
+	}
+
+	assert.Equal(t, uint64(3), samples, "whitelisted request is not decided by store")
+}
+
+func TestDecisionDurationDisabled(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	m, err := New(reg)
+	assert.NoError(t, err)
+
+	l := limiter.New(limiter.WithObserver(m))
+	defer l.Stop()
+
+	l.Allow("1.1.1.1")
+
+	n, err := testutil.GatherAndCount(reg, "limiter_decision_duration_seconds")
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestDecisionDurationInvalidBuckets(t *testing.T) {
+	_, err := New(prometheus.NewRegistry(), DecisionDuration(0.1, 0.01))
+	assert.ErrorIs(t, err, limiter.ErrInvalidOption)
+}
+
+func TestExpiredMetric(t *testing.T) {
+	c := limitertest.NewFakeClock(time.Unix(0, 0))
+
+	m, err := New(prometheus.NewRegistry())
+	assert.NoError(t, err)
+
+	l := limiter.New(limiter.WithObserver(m), limiter.WithClock(c), limiter.RecordTTL(time.Minute))
+	defer l.Stop()
+
+	l.Allow("1.1.1.1")
+	l.Allow("2.2.2.2")
+	c.Advance(time.Hour)
+	l.Cleanup()
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.(*Metrics).expired))
+}
@@ -0,0 +1,141 @@
+package limiter
+
+import (
+	"strings"
+)
+
+// IPStrategy decides which address of comma separated ip header, such as X-Forwarded-For, is client ip, see XFFStrategy.
+type IPStrategy int
+
+const (
+	// LeftMost takes the first address, set by client or the first proxy. Client can choose it by sending the header.
+	LeftMost IPStrategy = iota
+	// RightMost takes the last address, appended by the proxy in front of the server.
+	RightMost
+	// RightMostTrusted walks addresses from the right and takes the first one not from TrustedProxies, like TrustedProxies alone does.
+	RightMostTrusted
+)
+
+// XFFStrategy sets which address of ip header is client ip, LeftMost by default, or RightMostTrusted when TrustedProxies are set.
+// LeftMost and RightMost read the header from any peer, without checking TrustedProxies.
+func XFFStrategy(s IPStrategy) option {
+	var errs []error
+
+	if s < LeftMost || s > RightMostTrusted {
+		errs = append(errs, invalidOption("unknown ip strategy %d", s))
+		return func(opts *limiterOptions) {
+			opts.errs = append(opts.errs, errs...)
+		}
+	}
+
+	return func(opts *limiterOptions) {
+		opts.ipStrategy, opts.ipStrategySet = s, true
+	}
+}
+
+// strategy returns ip strategy set by XFFStrategy, or the one implied by TrustedProxies.
+func (lim *limiter) strategy() IPStrategy {
+	if lim.opts.ipStrategySet {
+		return lim.opts.ipStrategy
+	}
+
+	if len(lim.opts.trustedProxies) > 0 {
+		return RightMostTrusted
+	}
+
+	return LeftMost
+}
+
+// rightMostIP returns the last address of comma separated header value.
+func rightMostIP(v string) string {
+	if hops := headerHops(v); len(hops) > 0 {
+		return hops[len(hops)-1]
+	}
+
+	return ""
+}
+
+// headerHops splits comma separated header value into addresses, skipping empty ones. Commas inside quotes or brackets dont split,
+// quotes are removed and so are ports, so `"[2001:db8::1]:443", 10.0.0.1:80` gives 2001:db8::1 and 10.0.0.1.
+func headerHops(v string) []string {
+	var (
+		hops            []string
+		start           int
+		quoted, bracket bool
+	)
+
+	for i := 0; i <= len(v); i++ {
+		if i < len(v) {
+			switch v[i] {
+			case '"':
+				quoted = !quoted
+			case '[':
+				bracket = true
+			case ']':
+				bracket = false
+			}
+
+			if v[i] != ',' || quoted || bracket {
+				continue
+			}
+		}
+
+		if hop := hopHost(v[start:i]); hop != "" {
+			hops = append(hops, hop)
+		}
+		start = i + 1
+	}
+
+	return hops
+}
+
+// hopHost returns address of header hop without surrounding spaces and quotes, brackets and port.
+func hopHost(hop string) string {
+	hop = strings.Trim(strings.TrimSpace(hop), `"`)
+	hop = strings.TrimSpace(hop)
+
+	if strings.HasPrefix(hop, "[") {
+		if end := strings.IndexByte(hop, ']'); end > 0 {
+			return hop[1:end]
+		}
+
+		return hop
+	}
+
+	// ipv4 or host with port has exactly one colon, bare ipv6 has more of them.
+	if i := strings.IndexByte(hop, ':'); i >= 0 && strings.LastIndexByte(hop, ':') == i {
+		return hop[:i]
+	}
+
+	return hop
+}
+
+// TrustedProxies takes networks in CIDR notation of proxies that append client address to ip header.
+// When set, ip header is read right to left and the first address not from these networks is used,
+// so client can't choose ip by sending the header. Requests from peers that are not trusted are limited by their own address.
+// Peer address is taken from RemoteAddr, or fiber IP(). Malformed networks are skipped by New. XFFStrategy other than RightMostTrusted ignores them.
+func TrustedProxies(cidrs ...string) option {
+	return func(opts *limiterOptions) {
+		opts.trustedProxies = append(opts.trustedProxies, opts.parseCIDRs(cidrs)...)
+	}
+}
+
+// trustedClientIP walks comma separated header from the right, skipping trusted proxies, starting with peer.
+// If every address is trusted, the left-most one is returned.
+func (lim *limiter) trustedClientIP(header, peer string) string {
+	if !inNets(peer, lim.opts.trustedProxies) {
+		return peer
+	}
+
+	ip := peer
+	for hops := headerHops(header); len(hops) > 0; hops = hops[:len(hops)-1] {
+		hop := hops[len(hops)-1]
+
+		ip = hop
+		if !inNets(hop, lim.opts.trustedProxies) {
+			break
+		}
+	}
+
+	return ip
+}
@@ -0,0 +1,292 @@
+package limiter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		header     string
+		remoteAddr string
+		expected   string
+	}{
+		{
+			name:       "spoofed_left_most_ignored",
+			header:     "6.6.6.6, 1.2.3.4, 10.0.0.2",
+			remoteAddr: "10.0.0.1:1234",
+			expected:   "1.2.3.4",
+		},
+		{
+			name:       "untrusted_peer_header_ignored",
+			header:     "6.6.6.6",
+			remoteAddr: "5.5.5.5:1234",
+			expected:   "5.5.5.5",
+		},
+		{
+			name:       "no_header",
+			remoteAddr: "10.0.0.1:1234",
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "all_trusted",
+			header:     "10.0.0.3, 10.0.0.2",
+			remoteAddr: "10.0.0.1:1234",
+			expected:   "10.0.0.3",
+		},
+		{
+			name:       "ipv6_proxy",
+			header:     "6.6.6.6, 2.2.2.2",
+			remoteAddr: "[fd00::1]:1234",
+			expected:   "2.2.2.2",
+		},
+	}
+
+	l := New(TrustedProxies("10.0.0.0/8", "fd00::/8"))
+	defer l.Stop()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				req.Header.Set(XOFF, tt.header)
+			}
+
+			assert.Equal(t, tt.expected, l.key(req, remoteAddrIP(req)))
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = req
+			assert.Equal(t, tt.expected, l.ginKey(c))
+		})
+	}
+}
+
+func TestXFFStrategy(t *testing.T) {
+	const hops = "6.6.6.6, 1.2.3.4, 10.0.0.2"
+
+	tests := []struct {
+		name       string
+		opts       []option
+		header     string
+		remoteAddr string
+		expected   string
+	}{
+		{name: "default_left_most", header: hops, remoteAddr: "10.0.0.1:1234", expected: "6.6.6.6"},
+		{name: "left_most", opts: []option{XFFStrategy(LeftMost)}, header: hops, remoteAddr: "10.0.0.1:1234", expected: "6.6.6.6"},
+		{name: "right_most", opts: []option{XFFStrategy(RightMost)}, header: hops, remoteAddr: "10.0.0.1:1234", expected: "10.0.0.2"},
+		{name: "right_most_trailing_comma", opts: []option{XFFStrategy(RightMost)}, header: hops + ", ", remoteAddr: "10.0.0.1:1234", expected: "10.0.0.2"},
+		{name: "right_most_no_header", opts: []option{XFFStrategy(RightMost)}, remoteAddr: "10.0.0.1:1234", expected: "10.0.0.1"},
+		{
+			name:       "right_most_trusted",
+			opts:       []option{XFFStrategy(RightMostTrusted), TrustedProxies("10.0.0.0/8")},
+			header:     hops,
+			remoteAddr: "10.0.0.1:1234",
+			expected:   "1.2.3.4",
+		},
+		{
+			name:       "right_most_trusted_untrusted_peer",
+			opts:       []option{XFFStrategy(RightMostTrusted), TrustedProxies("10.0.0.0/8")},
+			header:     hops,
+			remoteAddr: "5.5.5.5:1234",
+			expected:   "5.5.5.5",
+		},
+		{
+			name:       "right_most_trusted_without_proxies",
+			opts:       []option{XFFStrategy(RightMostTrusted)},
+			header:     hops,
+			remoteAddr: "10.0.0.1:1234",
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "left_most_overrides_trusted_proxies",
+			opts:       []option{TrustedProxies("10.0.0.0/8"), XFFStrategy(LeftMost)},
+			header:     hops,
+			remoteAddr: "5.5.5.5:1234",
+			expected:   "6.6.6.6",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.opts...)
+			defer l.Stop()
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				req.Header.Set(XOFF, tt.header)
+			}
+
+			assert.Equal(t, tt.expected, l.key(req, remoteAddrIP(req)))
+		})
+	}
+}
+
+func TestHeaderHops(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected []string
+	}{
+		{header: "1.1.1.1", expected: []string{"1.1.1.1"}},
+		{header: " 1.1.1.1 , , 2.2.2.2,", expected: []string{"1.1.1.1", "2.2.2.2"}},
+		{header: `"[2001:db8::1]:443", 10.0.0.1`, expected: []string{"2001:db8::1", "10.0.0.1"}},
+		{header: "[2001:db8::1], 10.0.0.1:8080", expected: []string{"2001:db8::1", "10.0.0.1"}},
+		{header: "2001:db8::1, ::1", expected: []string{"2001:db8::1", "::1"}},
+		{header: `"a,b", 1.1.1.1`, expected: []string{"a,b", "1.1.1.1"}},
+		{header: "", expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, tt.expected, headerHops(tt.header))
+		})
+	}
+}
+
+func TestXFFIPv6Hops(t *testing.T) {
+	const hops = `"[2001:db8::1]:%d", [2001:db8::2]:%d, 10.0.0.2:%d`
+
+	tests := []struct {
+		name     string
+		opts     []option
+		expected string
+	}{
+		{name: "left_most", expected: "2001:db8::1"},
+		{name: "right_most", opts: []option{XFFStrategy(RightMost)}, expected: "10.0.0.2"},
+		{name: "right_most_trusted", opts: []option{TrustedProxies("10.0.0.0/8")}, expected: "2001:db8::2"},
+		{name: "right_most_trusted_ipv6_proxy", opts: []option{TrustedProxies("10.0.0.0/8", "2001:db8::2/128")}, expected: "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(append([]option{RpsWithBurst(1, 1)}, tt.opts...)...)
+			defer l.Stop()
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set(XOFF, fmt.Sprintf(hops, 443, 8443, 80))
+
+			assert.Equal(t, tt.expected, l.key(req, remoteAddrIP(req)))
+
+			// the same client through another port of the same proxy shares the bucket.
+			h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			for i, code := range []int{http.StatusOK, http.StatusTooManyRequests} {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.RemoteAddr = "10.0.0.1:1234"
+				req.Header.Set(XOFF, fmt.Sprintf(hops, 443+i, 8443+i, 80+i))
+
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				assert.Equal(t, code, rec.Code)
+			}
+		})
+	}
+}
+
+func TestXFFStrategyInvalid(t *testing.T) {
+	_, err := NewWithError(XFFStrategy(IPStrategy(42)))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestTrustedProxiesSpoofedBucket(t *testing.T) {
+	l := New(TrustedProxies("10.0.0.0/8"), RpsWithBurst(1, 1))
+	defer l.Stop()
+
+	h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	codes := make([]int, 0, 3)
+	for _, spoofed := range []string{"7.7.7.7", "8.8.8.8", "9.9.9.9"} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set(XOFF, spoofed+", 1.2.3.4")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}, codes)
+}
+
+func TestTrustedProxiesMalformed(t *testing.T) {
+	_, err := NewWithError(TrustedProxies("10.0.0.0/8", "bad"))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
+
+func TestNormalizeIP(t *testing.T) {
+	forms := []string{"::1", "0:0:0:0:0:0:0:1", "0000:0000:0000:0000:0000:0000:0000:0001", "::1%eth0", "::0:1"}
+
+	l := New(RpsWithBurst(1, 3), AllowedIPs("2001:DB8:0:0:0:0:0:1"))
+	defer l.Stop()
+
+	h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	codes := make([]int, 0, len(forms))
+	for _, form := range forms {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set(XOFF, form)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+
+		assert.Equal(t, "::1", l.key(req, remoteAddrIP(req)))
+	}
+
+	assert.Equal(t, []int{200, 200, 200, 429, 429}, codes)
+
+	for _, form := range []string{"2001:db8::1", "2001:0db8:0000::0001", "2001:db8::1%2"} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set(XOFF, form)
+		assert.True(t, l.whiteListed(l.key(req, remoteAddrIP(req))), form)
+	}
+
+	assert.Equal(t, "1.2.3.4", normalizeIP("::ffff:1.2.3.4"))
+	assert.Equal(t, "not-an-ip%x", normalizeIP("not-an-ip%x"))
+}
+
+func TestRemoteAddrIPv6Forms(t *testing.T) {
+	handlers := middlewares(nil)
+
+	forms := []string{"[::1]:8080", "::1", "[::1]"}
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			l := New(RpsWithBurst(1, 2), IPHeaders("X-Unused"))
+			defer l.Stop()
+
+			h := handler(l)
+			codes := make([]int, 0, len(forms))
+			for _, form := range forms {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.RemoteAddr = form
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				codes = append(codes, rec.Code)
+
+				assert.Equal(t, "::1", l.key(req, remoteAddrIP(req)), form)
+			}
+
+			assert.Equal(t, []int{200, 200, 429}, codes, "all forms share a bucket")
+		})
+	}
+
+	l := New(AllowedCIDRs("::1/128"), KeyBySubnet(24, 64))
+	defer l.Stop()
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.RemoteAddr = "[::1]"
+	assert.True(t, l.whiteListed(l.key(req, remoteAddrIP(req))))
+	assert.Equal(t, "::/64", l.(*limiter).storeKey(l.key(req, remoteAddrIP(req)), ""))
+}
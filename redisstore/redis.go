@@ -0,0 +1,164 @@
+// Package redisstore provides limiter.Store that shares limits between instances of a service in redis.
+package redisstore
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/eldarthepro/limiter"
+	"github.com/redis/go-redis/v9"
+
+	"golang.org/x/time/rate"
+)
+
+const redisKeyPrefix = "limiter:"
+
+// slidingWindowScript drops timestamps that left the window, then adds cost new ones if there is room for all of them.
+// Key expires together with the window, so idle visitors do not need cleanup.
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local max = tonumber(ARGV[3])
+local cost = tonumber(ARGV[5])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+
+if redis.call('ZCARD', KEYS[1]) + cost > max then
+	return 0
+end
+
+for i = 1, cost do
+	redis.call('ZADD', KEYS[1], now, ARGV[4] .. '-' .. i)
+end
+redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000))
+
+return 1
+`)
+
+// delayScript returns timestamp of the request that has to leave the window before one more fits, or -1 if there is room already.
+var delayScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local max = tonumber(ARGV[3])
+
+local count = redis.call('ZCOUNT', KEYS[1], '(' .. (now - window), '+inf')
+if count < max then
+	return -1
+end
+
+local res = redis.call('ZRANGEBYSCORE', KEYS[1], '(' .. (now - window), '+inf', 'WITHSCORES', 'LIMIT', count - max, 1)
+
+return tonumber(res[2])
+`)
+
+type (
+	store struct {
+		client redis.UniversalClient
+		policy limiter.FailPolicy
+	}
+
+	option func(*store)
+)
+
+// New returns limiter.Store that shares limits between instances using redis sliding window log.
+// Window is time needed to refill whole burst with configured limit, so at most burst requests are allowed in any window.
+// By default requests are let through when redis is unavailable.
+func New(client redis.UniversalClient, opts ...option) limiter.Store {
+	s := &store{
+		client: client,
+		policy: limiter.FailOpen,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// OnFailure sets what happens with requests when redis can not be reached.
+func OnFailure(p limiter.FailPolicy) option {
+	return func(s *store) {
+		s.policy = p
+	}
+}
+
+// Allow records request from ip in its window. On redis error returns decision of failure policy together with the error.
+func (s *store) Allow(ip string, limit rate.Limit, burst int) (bool, error) {
+	return s.AllowN(ip, limit, burst, 1)
+}
+
+// AllowN records n requests from ip in its window at once, like Allow.
+func (s *store) AllowN(ip string, limit rate.Limit, burst, n int) (bool, error) {
+	if limit == rate.Inf {
+		return true, nil
+	}
+
+	if burst <= 0 || limit <= 0 {
+		return false, nil
+	}
+
+	now := time.Now().UnixMicro()
+	window := window(limit, burst).Microseconds()
+	member := strconv.FormatInt(now, 10) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+
+	res, err := slidingWindowScript.Run(context.Background(), s.client,
+		[]string{redisKeyPrefix + ip}, now, window, burst, member, n).Int()
+	if err != nil {
+		return s.policy == limiter.FailOpen, err
+	}
+
+	return res == 1, nil
+}
+
+// Delay returns time left until enough requests of ip leave the window for one more to fit, zero if it fits already.
+func (s *store) Delay(ip string, limit rate.Limit, burst int) (time.Duration, bool) {
+	if limit == rate.Inf {
+		return 0, true
+	}
+
+	if burst <= 0 || limit <= 0 {
+		return 0, false
+	}
+
+	now := time.Now()
+	window := window(limit, burst)
+
+	leaves, err := delayScript.Run(context.Background(), s.client,
+		[]string{redisKeyPrefix + ip}, now.UnixMicro(), window.Microseconds(), burst).Int64()
+	if err != nil {
+		return 0, false
+	}
+
+	if leaves < 0 {
+		return 0, true
+	}
+
+	return max(0, time.UnixMicro(leaves).Add(window).Sub(now)), true
+}
+
+// Reset deletes window of ip.
+func (s *store) Reset(ip string) {
+	s.client.Del(context.Background(), redisKeyPrefix+ip)
+}
+
+// ResetAll deletes windows of every visitor, scanning keys with limiter prefix.
+func (s *store) ResetAll() {
+	ctx := context.Background()
+
+	iter := s.client.Scan(ctx, 0, redisKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		s.client.Del(ctx, iter.Val())
+	}
+}
+
+// Cleanup does nothing, keys expire in redis on their own.
+func (s *store) Cleanup() {}
+
+// window returns time needed to refill whole burst with positive limit.
+func window(limit rate.Limit, burst int) time.Duration {
+	return time.Duration(math.Ceil(float64(burst) / float64(limit) * float64(time.Second)))
+}
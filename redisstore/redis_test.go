@@ -0,0 +1,208 @@
+package redisstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/eldarthepro/limiter"
+	"github.com/eldarthepro/limiter/limitertest"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, redis.UniversalClient) {
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return m, client
+}
+
+func TestRedisStoreAllow(t *testing.T) {
+	m, client := newTestRedis(t)
+	s := New(client)
+
+	for i := 0; i < 3; i++ {
+		ok, err := s.Allow("1.1.1.1", 1, 3)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	ok, err := s.Allow("1.1.1.1", 1, 3)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = s.Allow("2.2.2.2", 1, 3)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.True(t, m.Exists("limiter:1.1.1.1"))
+	assert.Equal(t, 3*time.Second, m.TTL("limiter:1.1.1.1"))
+}
+
+func TestRedisStoreSharedBetweenInstances(t *testing.T) {
+	_, client := newTestRedis(t)
+	a, b := New(client), New(client)
+
+	ok, _ := a.Allow("1.1.1.1", 1, 1)
+	assert.True(t, ok)
+
+	ok, _ = b.Allow("1.1.1.1", 1, 1)
+	assert.False(t, ok)
+}
+
+func TestRedisStoreWindowSlides(t *testing.T) {
+	_, client := newTestRedis(t)
+	s := New(client)
+
+	ok, _ := s.Allow("1.1.1.1", 100, 1)
+	assert.True(t, ok)
+
+	ok, _ = s.Allow("1.1.1.1", 100, 1)
+	assert.False(t, ok)
+
+	time.Sleep(20 * time.Millisecond)
+
+	ok, _ = s.Allow("1.1.1.1", 100, 1)
+	assert.True(t, ok)
+}
+
+func TestRedisStoreFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []option
+		expected bool
+	}{
+		{
+			name:     "fail_open_by_default",
+			expected: true,
+		},
+		{
+			name:     "fail_open",
+			opts:     []option{OnFailure(limiter.FailOpen)},
+			expected: true,
+		},
+		{
+			name:     "fail_closed",
+			opts:     []option{OnFailure(limiter.FailClosed)},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, client := newTestRedis(t)
+			m.Close()
+
+			ok, err := New(client, tt.opts...).Allow("1.1.1.1", 1, 1)
+			assert.Error(t, err)
+			assert.Equal(t, tt.expected, ok)
+		})
+	}
+}
+
+func TestRedisStoreDelay(t *testing.T) {
+	_, client := newTestRedis(t)
+	s := New(client).(*store)
+
+	d, ok := s.Delay("1.1.1.1", 1, 1)
+	assert.True(t, ok)
+	assert.Zero(t, d, "unseen ip fits right away")
+
+	_, _ = s.Allow("1.1.1.1", 1, 1)
+
+	d, ok = s.Delay("1.1.1.1", 1, 1)
+	assert.True(t, ok)
+	assert.InDelta(t, time.Second, d, float64(100*time.Millisecond))
+
+	_, _ = s.Allow("2.2.2.2", 1, 3)
+	d, ok = s.Delay("2.2.2.2", 1, 3)
+	assert.True(t, ok)
+	assert.Zero(t, d, "window has room left")
+}
+
+func TestRedisStoreRetryAfter(t *testing.T) {
+	_, client := newTestRedis(t)
+	l := limiter.New(limiter.WithStore(New(client)), limiter.RpsWithBurst(1, 10))
+	defer l.Stop()
+
+	assert.Zero(t, l.RetryAfter("1.1.1.1"), "unseen key")
+
+	assert.True(t, l.Allow("1.1.1.1"))
+	assert.Zero(t, l.RetryAfter("1.1.1.1"), "nine requests left")
+
+	for i := 0; i < 9; i++ {
+		assert.True(t, l.Allow("1.1.1.1"))
+	}
+	assert.False(t, l.Allow("1.1.1.1"))
+	assert.InDelta(t, 10*time.Second, l.RetryAfter("1.1.1.1"), float64(time.Second))
+}
+
+func TestRedisStoreReset(t *testing.T) {
+	m, client := newTestRedis(t)
+	s := New(client)
+
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2"} {
+		_, _ = s.Allow(ip, 1, 1)
+	}
+
+	s.(*store).Reset("1.1.1.1")
+	assert.False(t, m.Exists("limiter:1.1.1.1"))
+	assert.True(t, m.Exists("limiter:2.2.2.2"))
+
+	ok, _ := s.Allow("1.1.1.1", 1, 1)
+	assert.True(t, ok)
+
+	assert.NoError(t, m.Set("other", "kept"))
+	s.(*store).ResetAll()
+	assert.Equal(t, []string{"other"}, m.Keys())
+}
+
+func TestRedisStoreAllowN(t *testing.T) {
+	_, client := newTestRedis(t)
+	s := New(client).(*store)
+
+	ok, err := s.AllowN("1.1.1.1", 1, 10, 5)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, _ = s.AllowN("1.1.1.1", 1, 10, 6)
+	assert.False(t, ok)
+
+	ok, _ = s.AllowN("1.1.1.1", 1, 10, 5)
+	assert.True(t, ok)
+
+	ok, _ = s.AllowN("1.1.1.1", 1, 10, 1)
+	assert.False(t, ok)
+}
+
+func TestHashKeysRedis(t *testing.T) {
+	m, client := newTestRedis(t)
+	l := limiter.New(limiter.WithStore(New(client)), limiter.HashKeys("pepper"))
+	defer l.Stop()
+
+	assert.True(t, l.Allow("1.1.1.1"))
+
+	keys := m.Keys()
+	assert.Len(t, keys, 1)
+	assert.NotContains(t, keys[0], "1.1.1.1")
+	assert.Regexp(t, "^limiter:[0-9a-f]{32}$", keys[0])
+}
+
+func TestCachedStoreRedis(t *testing.T) {
+	m, client := newTestRedis(t)
+	c := limitertest.NewFakeClock(time.Now())
+	l := limiter.New(limiter.WithStore(limiter.NewCachedStore(New(client), time.Minute)), limiter.RpsWithBurst(1, 1), limiter.WithClock(c))
+	defer l.Stop()
+
+	assert.True(t, l.Allow("1.1.1.1"))
+	assert.False(t, l.Allow("1.1.1.1"))
+
+	// local block is honored even when redis forgets visitor.
+	m.FlushAll()
+	assert.False(t, l.Allow("1.1.1.1"))
+
+	c.Advance(time.Second)
+	assert.True(t, l.Allow("1.1.1.1"))
+}
@@ -0,0 +1,45 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+)
+
+// RefundOnClientCancel gives tokens taken by request back to key if its context is canceled by the time handler returns, which is
+// the case when client disconnects before it gets response, for example after its own timeout while upstream is slow. Requests rejected
+// by GlobalLimit keep their tokens. Applied by in-memory store only. Used by Limit, GinLimit and echolimit.Limit,
+// fasthttp doesnt cancel request context of fiberlimit.Limit.
+func RefundOnClientCancel() option {
+	return func(opts *limiterOptions) {
+		opts.refundCanceled = true
+	}
+}
+
+// refundCanceled gives n tokens back to key that requested path if ctx is canceled and RefundOnClientCancel is set.
+func (lim *limiter) refundCanceled(ctx context.Context, key, path string, n int) {
+	if !lim.opts.refundCanceled || !errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+
+	lim.refund(key, path, n)
+}
+
+// refund gives n tokens taken by request of key to path back, if store can refund them.
+func (lim *limiter) refund(key, path string, n int) {
+	if key == "" {
+		return
+	}
+
+	r, ok := lim.store.(refunder)
+	if !ok {
+		return
+	}
+
+	// stores that cant take several tokens at once were charged one.
+	if _, multi := lim.store.(multiAllower); !multi {
+		n = 1
+	}
+
+	limit, burst := lim.rateFor(key)
+	r.Refund(lim.storeKey(key, path), limit, burst, n)
+}
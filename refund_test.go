@@ -0,0 +1,119 @@
+package limiter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefundOnClientCancel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// handlers cancel request context, as if client disconnected while they run.
+	var cancel context.CancelFunc
+	handlers := middlewares(func(w http.ResponseWriter, r *http.Request) { cancel() })
+
+	tests := []struct {
+		name    string
+		opts    []option
+		allowed int
+	}{
+		{name: "refunded", opts: []option{RefundOnClientCancel()}, allowed: 10},
+		{name: "disabled", allowed: 3},
+	}
+
+	for name, handler := range handlers {
+		for _, tt := range tests {
+			t.Run(name+"_"+tt.name, func(t *testing.T) {
+				l := New(append([]option{RpsWithBurst(1, 3), WithClock(&manualClock{now: time.Unix(0, 0)})}, tt.opts...)...)
+				defer l.Stop()
+
+				h := handler(l)
+				allowed := 0
+				for i := 0; i < 10; i++ {
+					var ctx context.Context
+					ctx, cancel = context.WithCancel(context.Background())
+					req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+					req.Header.Set(XOFF, "1.1.1.1")
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, req)
+					cancel()
+
+					if rec.Code != http.StatusTooManyRequests {
+						allowed++
+					}
+				}
+
+				assert.Equal(t, tt.allowed, allowed)
+			})
+		}
+	}
+}
+
+func TestRefundOnClientCancelCompleted(t *testing.T) {
+	l := New(RpsWithBurst(1, 3), RefundOnClientCancel(), WithClock(&manualClock{now: time.Unix(0, 0)}))
+	defer l.Stop()
+
+	h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(XOFF, "1.1.1.1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code == http.StatusOK {
+			allowed++
+		}
+	}
+
+	assert.Equal(t, 3, allowed)
+}
+
+func TestRefundSkipsIdempotentRetries(t *testing.T) {
+	l := New(RpsWithBurst(1, 2), IdempotencyKey("Idempotency-Key", time.Minute), RefundOnClientCancel(),
+		WithClock(&manualClock{now: time.Unix(0, 0)}))
+	defer l.Stop()
+
+	var cancel context.CancelFunc
+	h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Cancel") != "" {
+			cancel()
+		}
+	}))
+
+	do := func(id string, canceled bool) int {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+
+		req := httptest.NewRequest(http.MethodPost, "/", nil).WithContext(ctx)
+		req.Header.Set(XOFF, "1.1.1.1")
+		if id != "" {
+			req.Header.Set("Idempotency-Key", id)
+		}
+		if canceled {
+			req.Header.Set("X-Cancel", "1")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, do("a", false))
+	assert.Equal(t, http.StatusOK, do("", false))
+	assert.Equal(t, http.StatusTooManyRequests, do("", false))
+
+	// retries took no tokens, so canceling them gives none back.
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, do("a", true))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, do("", false))
+}
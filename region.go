@@ -0,0 +1,77 @@
+package limiter
+
+import (
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// regionPrefix marks class of request set by RegionFunc, so regions dont collide with classes of ClassifierFunc.
+const regionPrefix = "\x01"
+
+// regionCacheSize is max number of ips region is cached for between cleanups.
+const regionCacheSize = 4096
+
+// RegionFunc sets function returning region of client ip, for example country from GeoIP lookup. Requests from a region are limited
+// by its RegionLimits, every key has separate budget in every region. Regions are cached per ip until the next cleanup. Empty region,
+// or region without limits, uses limiter defaults. Class set by ClassifierFunc takes precedence over region. Used by Limit, GinLimit and echolimit.Limit.
+func RegionFunc(f func(ip string) string) option {
+	return func(opts *limiterOptions) {
+		opts.regionFunc = f
+	}
+}
+
+// RegionLimits sets rps and burst of regions returned by RegionFunc.
+func RegionLimits(limits map[string]RpsBurst) option {
+	var errs []error
+
+	for region, l := range limits {
+		if l.Rps < 0 || l.Burst < 0 {
+			errs = append(errs, invalidOption("negative limits %v of region %q", l, region))
+		}
+	}
+
+	return func(opts *limiterOptions) {
+		if len(errs) > 0 {
+			opts.errs = append(opts.errs, errs...)
+			return
+		}
+
+		if opts.classLimits == nil {
+			opts.classLimits = make(map[string]rateLimit, len(limits))
+		}
+
+		for region, l := range limits {
+			opts.classLimits[regionPrefix+region] = rateLimit{rate.Limit(l.Rps), l.Burst}
+		}
+	}
+}
+
+// regionClass returns class of request r from region of its client ip, or empty class if RegionFunc is not set or returns empty region.
+func (lim *limiter) regionClass(r *http.Request) string {
+	if lim.opts.regionFunc == nil {
+		return ""
+	}
+
+	ip, _ := ClientIPFromContext(r.Context())
+
+	region, ok := lim.regions.Load(ip)
+	if !ok {
+		region = lim.opts.regionFunc(ip)
+		if lim.regionsSize.Add(1) <= regionCacheSize {
+			lim.regions.Store(ip, region)
+		}
+	}
+
+	if region == "" {
+		return ""
+	}
+
+	return regionPrefix + region.(string)
+}
+
+// clearRegions forgets cached regions, so changes of GeoIP data are seen.
+func (lim *limiter) clearRegions() {
+	lim.regions.Clear()
+	lim.regionsSize.Store(0)
+}
@@ -0,0 +1,106 @@
+package limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegionFunc(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			var lookups atomic.Int32
+			l := New(RpsWithBurst(1, 2),
+				RegionFunc(func(ip string) string {
+					lookups.Add(1)
+
+					switch {
+					case strings.HasPrefix(ip, "1."):
+						return "DE"
+					case strings.HasPrefix(ip, "2."):
+						return "US"
+					case strings.HasPrefix(ip, "3."):
+						return "FR"
+					}
+
+					return ""
+				}),
+				RegionLimits(map[string]RpsBurst{"DE": {Rps: 1, Burst: 1}, "US": {Rps: 1, Burst: 4}}),
+			)
+			defer l.Stop()
+
+			h := handler(l)
+			allowed := func(ip string) int {
+				n := 0
+				for i := 0; i < 6; i++ {
+					req := httptest.NewRequest(http.MethodGet, "/test", nil)
+					req.Header.Set(XOFF, ip)
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, req)
+					if rec.Code == http.StatusOK {
+						n++
+					}
+				}
+
+				return n
+			}
+
+			assert.Equal(t, 1, allowed("1.1.1.1"), "DE")
+			assert.Equal(t, 4, allowed("2.2.2.2"), "US")
+			assert.Equal(t, 2, allowed("3.3.3.3"), "region without limits uses defaults")
+			assert.Equal(t, 2, allowed("4.4.4.4"), "no region uses defaults")
+			assert.Equal(t, int32(4), lookups.Load(), "region is looked up once per ip")
+
+			l.Reset("2.2.2.2")
+			assert.Equal(t, 4, allowed("2.2.2.2"), "reset forgets region budgets")
+
+			l.(*limiter).cleanup()
+			allowed("1.1.1.1")
+			assert.Equal(t, int32(5), lookups.Load(), "cleanup forgets cached regions")
+		})
+	}
+}
+
+func TestRegionFuncWithClassifier(t *testing.T) {
+	l := New(RpsWithBurst(1, 1),
+		ClassifierFunc(func(r *http.Request) string { return r.Header.Get("X-Class") }),
+		ClassLimits(map[string]RpsBurst{"premium": {Rps: 1, Burst: 5}}),
+		RegionFunc(func(string) string { return "DE" }),
+		RegionLimits(map[string]RpsBurst{"DE": {Rps: 1, Burst: 2}}),
+	)
+	defer l.Stop()
+
+	h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	allowed := func(class string) int {
+		n := 0
+		for i := 0; i < 6; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set(XOFF, "1.1.1.1")
+			req.Header.Set("X-Class", class)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code == http.StatusOK {
+				n++
+			}
+		}
+
+		return n
+	}
+
+	assert.Equal(t, 5, allowed("premium"), "class takes precedence over region")
+	assert.Equal(t, 2, allowed(""), "requests without class use region")
+}
+
+func TestRegionLimitsInvalid(t *testing.T) {
+	_, err := NewWithError(RegionLimits(map[string]RpsBurst{"DE": {Burst: -1}}))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
@@ -0,0 +1,111 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// Registry runs cleanup of every limiter created with NewWithRegistry from one routine, instead of a routine per limiter, which adds up
+// when there are many of them, for example one per route. Its limiters are cleaned with frequency of registry, their CleanupFrequency
+// has no effect, and CleanupWhenLargerThan of any of them cleans all of them.
+type Registry struct {
+	limiters map[*limiter]struct{}
+	grown    chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	sync.Mutex
+}
+
+// NewRegistry starts cleanup routine of registry running every cleanupFreq, or every 5 minutes if it is not positive.
+// Registry should be stopped when its limiters are not needed anymore.
+func NewRegistry(cleanupFreq time.Duration) *Registry {
+	if cleanupFreq <= 0 {
+		cleanupFreq = defaultCleanupFrequency
+	}
+
+	r := &Registry{
+		limiters: make(map[*limiter]struct{}),
+		grown:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go r.run(realClock{}.NewTicker(cleanupFreq))
+
+	return r
+}
+
+// NewWithRegistry returns limiter like New, but cleaned by routine of reg instead of its own one. Stopping limiter removes it from reg
+// without waiting for cleanup reg may be running. With nil reg it is the same as New.
+func NewWithRegistry(reg *Registry, opts ...option) Limiter {
+	if reg == nil {
+		return New(opts...)
+	}
+
+	lim, _ := newLimiter(opts...)
+	lim.registry, lim.grown = reg, reg.grown
+
+	lim.start()
+
+	return lim
+}
+
+// Len returns number of limiters registry cleans.
+func (r *Registry) Len() int {
+	r.Lock()
+	defer r.Unlock()
+
+	return len(r.limiters)
+}
+
+// Stop stops cleanup routine and waits for it to exit. Limiters are not stopped, from now on their expired visitors are removed only by Cleanup.
+func (r *Registry) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+
+	<-r.done
+}
+
+func (r *Registry) add(lim *limiter) {
+	r.Lock()
+	r.limiters[lim] = struct{}{}
+	r.Unlock()
+}
+
+func (r *Registry) remove(lim *limiter) {
+	r.Lock()
+	delete(r.limiters, lim)
+	r.Unlock()
+}
+
+func (r *Registry) run(ti Ticker) {
+	defer close(r.done)
+	defer ti.Stop()
+
+	for {
+		select {
+		case <-ti.C():
+			r.cleanup()
+		case <-r.grown:
+			r.cleanup()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// cleanup cleans every registered limiter, lock is held only to list them, so limiters can be added and stopped meanwhile.
+func (r *Registry) cleanup() {
+	r.Lock()
+	ls := make([]*limiter, 0, len(r.limiters))
+	for lim := range r.limiters {
+		ls = append(ls, lim)
+	}
+	r.Unlock()
+
+	for _, lim := range ls {
+		lim.cleanup()
+	}
+}
@@ -0,0 +1,82 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry(10 * time.Millisecond)
+	defer reg.Stop()
+
+	ls := make([]Limiter, 3)
+	for i := range ls {
+		ls[i] = NewWithRegistry(reg, RecordTTL(10*time.Millisecond))
+		defer ls[i].Stop()
+
+		ls[i].Allow("1.1.1.1")
+		assert.Equal(t, 1, ls[i].Stats().Visitors)
+
+		// limiter has no cleanup routine of its own.
+		select {
+		case <-ls[i].(*limiter).done:
+		default:
+			t.Fatal("limiter started cleanup routine")
+		}
+	}
+
+	assert.Equal(t, 3, reg.Len())
+
+	assert.Eventually(t, func() bool {
+		for _, l := range ls {
+			if l.Stats().Visitors != 0 {
+				return false
+			}
+		}
+
+		return true
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestRegistryStop(t *testing.T) {
+	reg := NewRegistry(time.Hour)
+
+	l := NewWithRegistry(reg)
+	other := NewWithRegistry(reg)
+	defer other.Stop()
+	assert.Equal(t, 2, reg.Len())
+
+	l.Stop()
+	assert.Equal(t, 1, reg.Len())
+
+	reg.Stop()
+	reg.Stop()
+
+	other.Allow("1.1.1.1")
+	assert.Equal(t, 1, other.Stats().Visitors)
+}
+
+func TestRegistryCleanupWhenLargerThan(t *testing.T) {
+	reg := NewRegistry(time.Hour)
+	defer reg.Stop()
+
+	c := &manualClock{now: time.Unix(0, 0)}
+	l := NewWithRegistry(reg, RecordTTL(0), CleanupWhenLargerThan(1), WithClock(c))
+	defer l.Stop()
+
+	l.Allow("1.1.1.1")
+	l.Allow("2.2.2.2")
+
+	assert.Eventually(t, func() bool {
+		return l.Stats().Visitors == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestNewWithNilRegistry(t *testing.T) {
+	l := NewWithRegistry(nil, Rps(1))
+	defer l.Stop()
+
+	assert.True(t, l.Allow("1.1.1.1"))
+}
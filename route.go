@@ -0,0 +1,68 @@
+package limiter
+
+import "strings"
+
+// LimitPath sets limiter used for requests to pattern instead of the one being created, which becomes default for all other paths.
+// Pattern is matched exactly, unless it ends with "/", then it matches every path starting with it.
+// When several patterns match, exact pattern wins, then the longest prefix.
+// Limiters set by LimitPath are stopped together with the default one.
+func LimitPath(pattern string, l Limiter) option {
+	return func(opts *limiterOptions) {
+		opts.routes[pattern] = l
+	}
+}
+
+// LimitMethod sets limiter used for requests with http method instead of the one being created, which stays default for other methods.
+// Method is matched case insensitively. Limiters set by LimitPath take precedence, so LimitMethod can be used on them for per route method limits.
+// Limiters set by LimitMethod are stopped together with the default one.
+func LimitMethod(method string, l Limiter) option {
+	return func(opts *limiterOptions) {
+		opts.methods[strings.ToUpper(method)] = l
+	}
+}
+
+// route returns limiter responsible for method and path.
+func (lim *limiter) route(method, path string) Limiter {
+	if l, ok := lim.opts.routes[path]; ok {
+		return l.route(method, path)
+	}
+
+	var (
+		match Limiter
+		best  int
+	)
+
+	for pattern, l := range lim.opts.routes {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) && len(pattern) > best {
+			match, best = l, len(pattern)
+		}
+	}
+
+	if match != nil {
+		return match.route(method, path)
+	}
+
+	if l, ok := lim.opts.methods[method]; ok {
+		return l.route(method, path)
+	}
+
+	return lim
+}
+
+// children returns limiters set by LimitPath, LimitMethod and WebSocketPolicy.
+func (lim *limiter) children() []Limiter {
+	ls := make([]Limiter, 0, len(lim.opts.routes)+len(lim.opts.methods)+1)
+	for _, l := range lim.opts.routes {
+		ls = append(ls, l)
+	}
+
+	for _, l := range lim.opts.methods {
+		ls = append(ls, l)
+	}
+
+	if lim.opts.webSocket != nil {
+		ls = append(ls, lim.opts.webSocket)
+	}
+
+	return ls
+}
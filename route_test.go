@@ -0,0 +1,183 @@
+package limiter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitPath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	l := New(RpsWithBurst(1, 3),
+		LimitPath("/login", New(RpsWithBurst(1, 1))),
+		LimitPath("/api/", New(RpsWithBurst(1, 2))),
+		LimitPath("/api/search", New(RpsWithBurst(1, 4))),
+	)
+	defer l.Stop()
+
+	router := gin.New()
+	router.Use(GinLimit(l))
+	router.NoRoute(func(c *gin.Context) {
+		c.String(http.StatusOK, "OK")
+	})
+
+	handlers := map[string]http.Handler{
+		"http": Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})),
+		"gin": router,
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		allowed int
+	}{
+		{
+			name:    "exact",
+			path:    "/login",
+			allowed: 1,
+		},
+		{
+			name:    "prefix",
+			path:    "/api/users",
+			allowed: 2,
+		},
+		{
+			name:    "exact_over_prefix",
+			path:    "/api/search",
+			allowed: 4,
+		},
+		{
+			name:    "default",
+			path:    "/other",
+			allowed: 3,
+		},
+	}
+
+	for name, h := range handlers {
+		for _, tt := range tests {
+			t.Run(name+"_"+tt.name, func(t *testing.T) {
+				ip := name + tt.name
+
+				for i := 0; i < tt.allowed; i++ {
+					req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+					req.Header.Set(XOFF, ip)
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, req)
+
+					assert.Equal(t, http.StatusOK, rec.Code)
+				}
+
+				req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+				req.Header.Set(XOFF, ip)
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+			})
+		}
+	}
+}
+
+func TestLimitMethod(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	l := New(RpsWithBurst(1, 3),
+		LimitMethod("post", New(RpsWithBurst(1, 1))),
+		LimitPath("/login", New(RpsWithBurst(1, 2), LimitMethod(http.MethodPost, New(RpsWithBurst(1, 1))))),
+	)
+	defer l.Stop()
+
+	router := gin.New()
+	router.Use(GinLimit(l))
+	router.NoRoute(func(c *gin.Context) {
+		c.String(http.StatusOK, "OK")
+	})
+
+	handlers := map[string]http.Handler{
+		"http": Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})),
+		"gin": router,
+	}
+
+	for name, h := range handlers {
+		for _, path := range []string{"/api", "/login"} {
+			t.Run(name+path, func(t *testing.T) {
+				ip := name + path
+
+				do := func(method string) int {
+					req := httptest.NewRequest(method, path, nil)
+					req.Header.Set(XOFF, ip)
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, req)
+
+					return rec.Code
+				}
+
+				assert.Equal(t, http.StatusOK, do(http.MethodPost))
+				assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost))
+				assert.Equal(t, http.StatusOK, do(http.MethodGet))
+				assert.Equal(t, http.StatusOK, do(http.MethodGet))
+			})
+		}
+	}
+}
+
+func TestLimitMethodStop(t *testing.T) {
+	l := New(LimitMethod(http.MethodPost, New()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(t, l.StopContext(ctx))
+
+	select {
+	case <-l.(*limiter).opts.methods[http.MethodPost].(*limiter).done:
+	default:
+		t.Fatal("method cleanup routine is still running")
+	}
+}
+
+func TestPathScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			l := New(RpsWithBurst(1, 2), WithPathScope(), RecordTTL(time.Minute))
+			defer l.Stop()
+
+			h := handler(l)
+			do := func(path string) int {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				req.Header.Set(XOFF, "1.1.1.1")
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				return rec.Code
+			}
+
+			assert.Equal(t, http.StatusOK, do("/a"))
+			assert.Equal(t, http.StatusOK, do("/a"))
+			assert.Equal(t, http.StatusTooManyRequests, do("/a"))
+			assert.Equal(t, http.StatusOK, do("/b"))
+
+			s := l.(*limiter).store.(*memoryStore)
+			assert.Equal(t, 2, s.Len())
+
+			s.get("1.1.1.1 /a").touch(time.Now().Add(-time.Hour))
+			s.Cleanup()
+			assert.Equal(t, 1, s.Len())
+			assert.Equal(t, http.StatusOK, do("/a"))
+		})
+	}
+}
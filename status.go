@@ -0,0 +1,105 @@
+package limiter
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"slices"
+)
+
+// statusRecorder remembers status of response written by handler, for PenalizeStatuses.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// PenalizeStatuses takes cost more tokens from key every time its request is answered with one of codes, for example 401 to slow
+// down credential stuffing. Tokens are taken after handler runs, even if key has none left, so the next requests wait longer.
+// Applied by in-memory store only. Used by Limit, GinLimit, echolimit.Limit and fiberlimit.Limit.
+func PenalizeStatuses(cost int, codes ...int) option {
+	var errs []error
+
+	if cost < 0 {
+		errs = append(errs, invalidOption("negative status penalty %d", cost))
+		cost = 0
+	}
+
+	for _, code := range codes {
+		if !validStatus(code) {
+			errs = append(errs, invalidOption("invalid penalized status %d", code))
+		}
+	}
+
+	return func(opts *limiterOptions) {
+		opts.errs = append(opts.errs, errs...)
+		opts.statusPenalty = cost
+		opts.penalizedStatuses = append(opts.penalizedStatuses, codes...)
+	}
+}
+
+// penalizesStatuses reports whether responses have to be checked for PenalizeStatuses.
+func (lim *limiter) penalizesStatuses() bool {
+	return lim.opts.statusPenalty > 0 && len(lim.opts.penalizedStatuses) > 0
+}
+
+// penalizeStatus takes PenalizeStatuses cost from key that requested path if status is one of penalized codes.
+func (lim *limiter) penalizeStatus(key, path string, status int) {
+	if !lim.penalizesStatuses() || key == "" || !slices.Contains(lim.opts.penalizedStatuses, status) {
+		return
+	}
+
+	if p, ok := lim.store.(penalizer); ok {
+		limit, burst := lim.rateFor(key)
+		p.Penalize(lim.storeKey(key, path), limit, burst, lim.opts.statusPenalty)
+	}
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends buffered response to client if wrapped writer can, so streaming handlers keep working under PenalizeStatuses.
+func (w *statusRecorder) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands connection over to handler if wrapped writer supports it, for example to upgrade to websocket.
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap returns wrapped writer, so http.ResponseController can reach it.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// code returns written status, 200 if handler wrote nothing.
+func (w *statusRecorder) code() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
@@ -0,0 +1,120 @@
+package limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPenalizeStatuses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// handlers answer requests with status from X-Status header.
+	handlers := middlewares(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Status") == "401" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		_, _ = w.Write([]byte("done"))
+	})
+
+	tests := []struct {
+		name    string
+		opts    []option
+		status  string
+		allowed int
+	}{
+		{name: "penalized", opts: []option{PenalizeStatuses(2, http.StatusUnauthorized)}, status: "401", allowed: 2},
+		{name: "other status", opts: []option{PenalizeStatuses(2, http.StatusUnauthorized)}, status: "200", allowed: 5},
+		{name: "disabled", status: "401", allowed: 5},
+	}
+
+	for name, handler := range handlers {
+		for _, tt := range tests {
+			t.Run(name+"_"+tt.name, func(t *testing.T) {
+				l := New(append([]option{RpsWithBurst(1, 5), WithClock(&manualClock{now: time.Unix(0, 0)})}, tt.opts...)...)
+				defer l.Stop()
+
+				h := handler(l)
+				allowed := 0
+				for i := 0; i < 10; i++ {
+					req := httptest.NewRequest(http.MethodGet, "/login", nil)
+					req.Header.Set(XOFF, "1.1.1.1")
+					req.Header.Set("X-Status", tt.status)
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, req)
+
+					if rec.Code != http.StatusTooManyRequests {
+						allowed++
+					}
+				}
+
+				assert.Equal(t, tt.allowed, allowed)
+			})
+		}
+	}
+}
+
+func TestPenalizeStatusesInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  option
+	}{
+		{name: "negative cost", opt: PenalizeStatuses(-1, http.StatusUnauthorized)},
+		{name: "invalid status", opt: PenalizeStatuses(1, 42)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWithError(tt.opt)
+			assert.ErrorIs(t, err, ErrInvalidOption)
+		})
+	}
+}
+
+func TestPenalizeStatusesFlushAndHijack(t *testing.T) {
+	l := New(RpsWithBurst(1, 10), PenalizeStatuses(2, http.StatusUnauthorized))
+	defer l.Stop()
+
+	t.Run("flush", func(t *testing.T) {
+		h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			f, ok := w.(http.Flusher)
+			if !assert.True(t, ok) {
+				return
+			}
+			_, _ = w.Write([]byte("chunk"))
+			f.Flush()
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(XOFF, "1.1.1.1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		assert.True(t, rec.Flushed)
+		assert.Equal(t, "chunk", rec.Body.String())
+	})
+
+	t.Run("hijack", func(t *testing.T) {
+		srv := httptest.NewServer(Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer conn.Close()
+
+			_, _ = buf.WriteString("HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n")
+			_ = buf.Flush()
+		})))
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL)
+		if assert.NoError(t, err) {
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		}
+	})
+}
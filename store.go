@@ -0,0 +1,443 @@
+package limiter
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func newMemoryStore(ttl time.Duration, algo Algo) *memoryStore {
+	return newShardedStore(ttl, algo, defaultShards)
+}
+
+// newShardedStore returns memory store that splits visitors between n shards.
+func newShardedStore(ttl time.Duration, algo Algo, n int) *memoryStore {
+	s := &memoryStore{
+		shards: make([]*shard, n),
+		ttl:    ttl,
+		algo:   algo,
+		clock:  realClock{},
+		pool:   &sync.Pool{},
+	}
+
+	for i := range s.shards {
+		s.shards[i] = &shard{storage: make(map[string]*record)}
+	}
+
+	return s
+}
+
+// newBoundedStore returns memory store keeping at most about n visitors. Small stores use fewer shards, so eviction is closer to exact LRU.
+func newBoundedStore(ttl time.Duration, algo Algo, n int) *memoryStore {
+	shards := min(defaultShards, max(1, n/minShardVisitors))
+
+	s := newShardedStore(ttl, algo, shards)
+	s.shardMax = (n + shards - 1) / shards
+
+	return s
+}
+
+// shard returns shard ip belongs to, picked by fnv-1a hash of ip.
+func (s *memoryStore) shard(ip string) *shard {
+	h := uint32(2166136261)
+	for i := 0; i < len(ip); i++ {
+		h ^= uint32(ip[i])
+		h *= 16777619
+	}
+
+	return s.shards[h%uint32(len(s.shards))]
+}
+
+// get returns record of ip, or nil if ip is not tracked. Record may be reused by another visitor once cleanup removes it, so only
+// tests use it, store reads what it needs under the shard lock.
+func (s *memoryStore) get(ip string) *record {
+	sh := s.shard(ip)
+	sh.RLock()
+	defer sh.RUnlock()
+
+	return sh.storage[ip]
+}
+
+// lookup returns bucket of ip, or nil if ip is not tracked.
+func (s *memoryStore) lookup(ip string) bucket {
+	sh := s.shard(ip)
+	sh.RLock()
+	defer sh.RUnlock()
+
+	if v := sh.storage[ip]; v != nil {
+		return v.bucket
+	}
+
+	return nil
+}
+
+// Allow takes token from visitor's bucket, creating one with provided limit and burst if ip is seen for the first time.
+func (s *memoryStore) Allow(ip string, limit rate.Limit, burst int) (bool, error) {
+	return s.AllowN(ip, limit, burst, 1)
+}
+
+// AllowN takes n tokens from visitor's bucket at once, like Allow.
+func (s *memoryStore) AllowN(ip string, limit rate.Limit, burst, n int) (bool, error) {
+	now := s.clock.Now()
+
+	ok := s.visitor(ip, limit, burst).allow(now, n)
+	if ok && s.refreshAllowed {
+		s.touch(ip, now)
+	}
+
+	return ok, nil
+}
+
+// touch sets time ip was last seen, if it is still tracked.
+func (s *memoryStore) touch(ip string, now time.Time) {
+	sh := s.shard(ip)
+	sh.RLock()
+	if v := sh.storage[ip]; v != nil {
+		v.touch(now)
+	}
+	sh.RUnlock()
+}
+
+// Penalize takes n tokens from ip even if it has none left.
+func (s *memoryStore) Penalize(ip string, limit rate.Limit, burst, n int) {
+	s.visitor(ip, limit, burst).penalize(s.clock.Now(), n)
+}
+
+// Refund gives back n tokens taken from ip.
+func (s *memoryStore) Refund(ip string, limit rate.Limit, burst, n int) {
+	s.visitor(ip, limit, burst).refund(s.clock.Now(), n)
+}
+
+// Delay returns how long ip has to wait for the next request. Reports false if ip can never be allowed.
+func (s *memoryStore) Delay(ip string, limit rate.Limit, burst int) (time.Duration, bool) {
+	b := s.lookup(ip)
+	if b == nil {
+		return 0, burst > 0
+	}
+
+	return b.delay(s.clock.Now())
+}
+
+// visitor lloks up entry in storage and returns its bucket, updating lastSeen field, unless refreshAllowed is set, and bucket limits if they differ from provided. Doesnt check if string is empty, so will return same updated limiter for all empty ip visitors, limiter lets them here only with SharedBucket policy of WhenNoKey.
+func (s *memoryStore) visitor(ip string, limit rate.Limit, burst int) bucket {
+	sh := s.shard(ip)
+
+	// records removed by cleanup are reused, so bucket is read under lock and record is not used after it is released.
+	sh.RLock()
+	v, e := sh.storage[ip]
+	var b bucket
+	changed := false
+	if v != nil {
+		if !s.refreshAllowed {
+			v.touch(s.clock.Now())
+		}
+		b, changed = v.bucket, v.limit != limit || v.burst != burst
+	}
+	sh.RUnlock()
+
+	if !e {
+		sh.Lock()
+		defer sh.Unlock()
+
+		// another request could add ip between read and write lock, its bucket already counts requests and must be kept.
+		if v, e := sh.storage[ip]; e && v != nil {
+			if !s.refreshAllowed {
+				v.touch(s.clock.Now())
+			}
+			return v.bucket
+		}
+
+		if s.shardMax > 0 && len(sh.storage) >= s.shardMax && sh.evictOldest() {
+			s.size.Add(-1)
+		}
+
+		now := s.clock.Now()
+		r := s.newRecord(limit, burst)
+		r.touch(now)
+		if n := warmUpTokens(limit, burst, s.warmUp); n > 0 {
+			r.bucket.penalize(now, n)
+		}
+		sh.add(ip, r)
+
+		if n := s.size.Add(1); s.onGrow != nil {
+			if next := s.nextGrow.Load(); n > next && s.nextGrow.CompareAndSwap(next, n+max(1, n/growStep)) {
+				s.onGrow()
+			}
+		}
+
+		return r.bucket
+	}
+
+	if changed {
+		sh.Lock()
+		if sh.storage[ip] == v {
+			v.limit, v.burst = limit, burst
+		}
+		sh.Unlock()
+
+		b.setLimit(limit, burst)
+	}
+
+	return b
+}
+
+// newRecord returns record with a new bucket, reusing one removed by cleanup if pool has it. Buckets are never reused, requests
+// that looked them up right before removal may still charge them.
+func (s *memoryStore) newRecord(limit rate.Limit, burst int) *record {
+	var r *record
+	if s.pool != nil {
+		r, _ = s.pool.Get().(*record)
+	}
+	if r == nil {
+		r = &record{}
+	}
+
+	r.bucket, r.limit, r.burst = newTieredBucket(newBucket(s.algo, limit, burst), s.tiers), limit, burst
+
+	return r
+}
+
+// warmUpTokens returns how many of burst tokens new visitor lacks to have limit refill them in d. One token is always left,
+// so the first request of visitor is allowed.
+func warmUpTokens(limit rate.Limit, burst int, d time.Duration) int {
+	if d <= 0 || limit <= 0 || limit == rate.Inf || burst <= 1 {
+		return 0
+	}
+
+	return int(min(float64(burst-1), float64(limit)*d.Seconds()))
+}
+
+// touch sets time record was last seen.
+func (r *record) touch(now time.Time) {
+	r.lastSeen.Store(now.UnixNano())
+}
+
+// seen returns time record was last seen.
+func (r *record) seen() time.Time {
+	return time.Unix(0, r.lastSeen.Load())
+}
+
+// SetLimit changes limit and burst of every visitor's bucket.
+func (s *memoryStore) SetLimit(limit rate.Limit, burst int) {
+	for _, sh := range s.shards {
+		sh.Lock()
+		for _, v := range sh.storage {
+			if v != nil {
+				v.limit, v.burst = limit, burst
+				v.bucket.setLimit(limit, burst)
+			}
+		}
+		sh.Unlock()
+	}
+}
+
+// Reset deletes record of ip.
+func (s *memoryStore) Reset(ip string) {
+	sh := s.shard(ip)
+	sh.Lock()
+	if v, ok := sh.storage[ip]; ok {
+		i := slices.Index(sh.keys, ip)
+		if v != nil {
+			i = v.idx
+		}
+
+		sh.remove(i)
+		s.size.Add(-1)
+	}
+	sh.Unlock()
+}
+
+// ResetAll deletes every record.
+func (s *memoryStore) ResetAll() {
+	for _, sh := range s.shards {
+		sh.Lock()
+		s.size.Add(-int64(len(sh.storage)))
+		clear(sh.storage)
+		sh.keys = sh.keys[:0]
+		sh.Unlock()
+	}
+
+	s.rearmGrow()
+}
+
+// rearmGrow sets size onGrow is called above to a growStep fraction over current size, but not below growLimit.
+func (s *memoryStore) rearmGrow() {
+	n := s.size.Load()
+	s.nextGrow.Store(max(s.growLimit, n+n/growStep))
+}
+
+// Len returns number of tracked visitors.
+func (s *memoryStore) Len() int {
+	n := 0
+	for _, sh := range s.shards {
+		sh.RLock()
+		n += len(sh.storage)
+		sh.RUnlock()
+	}
+
+	return n
+}
+
+// Stats returns number of tracked visitors and the oldest time one of them was seen.
+func (s *memoryStore) Stats() Stats {
+	var st Stats
+	for _, sh := range s.shards {
+		sh.RLock()
+		st.Visitors += len(sh.storage)
+		for _, v := range sh.storage {
+			if v == nil {
+				continue
+			}
+
+			if seen := v.seen(); st.OldestLastSeen.IsZero() || seen.Before(st.OldestLastSeen) {
+				st.OldestLastSeen = seen
+			}
+		}
+		sh.RUnlock()
+	}
+
+	return st
+}
+
+// Tokens returns tokens ip has left, reports false if ip is not tracked.
+func (s *memoryStore) Tokens(ip string) (float64, bool) {
+	b := s.lookup(ip)
+	if b == nil {
+		return 0, false
+	}
+
+	return b.tokens(s.clock.Now()), true
+}
+
+// Range calls f for every visitor with time it was last seen and tokens it has left, until f returns false. Visitors of every shard
+// are described under read lock and f is called after it is released, so f can use the store. Visitors added or removed meanwhile may be missed.
+func (s *memoryStore) Range(f func(key string, lastSeen time.Time, tokens float64) bool) {
+	type visitor struct {
+		key      string
+		lastSeen time.Time
+		tokens   float64
+	}
+
+	var vs []visitor
+	for _, sh := range s.shards {
+		vs = vs[:0]
+
+		sh.RLock()
+		now := s.clock.Now()
+		for k, v := range sh.storage {
+			if v != nil {
+				vs = append(vs, visitor{k, v.seen(), v.bucket.tokens(now)})
+			}
+		}
+		sh.RUnlock()
+
+		for _, v := range vs {
+			if !f(v.key, v.lastSeen, v.tokens) {
+				return
+			}
+		}
+	}
+}
+
+// Cleanup removes records that were not seen for longer than ttl of store.
+func (s *memoryStore) Cleanup() {
+	s.DeleteExpired(s.ttl, s.clock.Now())
+}
+
+// DeleteExpired removes records that were not seen for longer than ttl at now, locking one shard at a time, and returns how many
+// were removed. Removed records are put into pool.
+func (s *memoryStore) DeleteExpired(ttl time.Duration, now time.Time) int {
+	removed := 0
+	for _, sh := range s.shards {
+		n := sh.cleanup(now, ttl, s.pool)
+		s.size.Add(-int64(n))
+		removed += n
+	}
+
+	s.rearmGrow()
+
+	return removed
+}
+
+// evictOldest deletes the least recently seen record, must be called with write lock held. Reports whether record was deleted.
+func (sh *shard) evictOldest() bool {
+	oldest := -1
+	var seen int64
+
+	for i, k := range sh.keys {
+		v := sh.storage[k]
+		if v == nil {
+			sh.remove(i)
+			return true
+		}
+
+		if ls := v.lastSeen.Load(); oldest < 0 || ls < seen {
+			oldest, seen = i, ls
+		}
+	}
+
+	if oldest >= 0 {
+		sh.remove(oldest)
+	}
+
+	return oldest >= 0
+}
+
+// add stores record of ip, replacing nil one, must be called with write lock held.
+func (sh *shard) add(ip string, r *record) {
+	if _, ok := sh.storage[ip]; ok {
+		sh.remove(slices.Index(sh.keys, ip))
+	}
+
+	r.idx = len(sh.keys)
+	sh.keys = append(sh.keys, ip)
+	sh.storage[ip] = r
+}
+
+// remove deletes record with key at index i of keys, moving the last key in its place. Must be called with write lock held.
+func (sh *shard) remove(i int) {
+	k, last := sh.keys[i], len(sh.keys)-1
+
+	sh.keys[i] = sh.keys[last]
+	if v := sh.storage[sh.keys[i]]; v != nil {
+		v.idx = i
+	}
+
+	sh.keys = sh.keys[:last]
+	delete(sh.storage, k)
+}
+
+// cleanup removes expired records of shard, putting them into pool unless it is nil, and returns how many were removed. Keys are checked
+// from the end in chunks of cleanupChunk, releasing lock between them, so visitors never wait for cleanup of the whole shard. Removing
+// a key moves the last one, which is already checked or was added during cleanup, in its place, so every key present for the whole cleanup is checked.
+func (sh *shard) cleanup(now time.Time, ttl time.Duration, pool *sync.Pool) int {
+	sh.RLock()
+	i := len(sh.keys) - 1
+	sh.RUnlock()
+
+	n := 0
+	for i >= 0 {
+		sh.Lock()
+		i = min(i, len(sh.keys)-1)
+		for end := i - cleanupChunk; i > end && i >= 0; i-- {
+			v := sh.storage[sh.keys[i]]
+			if v != nil && now.Sub(v.seen()) < ttl {
+				continue
+			}
+
+			sh.remove(i)
+			n++
+
+			// record is no longer in storage and requests read it only under lock, so it can be handed to a new visitor.
+			if v != nil && pool != nil {
+				v.bucket = nil
+				pool.Put(v)
+			}
+		}
+		sh.Unlock()
+	}
+
+	return n
+}
@@ -0,0 +1,872 @@
+package limiter
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+type stubStore struct {
+	allowed bool
+	calls   map[string]int
+}
+
+func (s *stubStore) Allow(ip string, limit rate.Limit, burst int) (bool, error) {
+	s.calls[ip]++
+	return s.allowed, nil
+}
+
+func (s *stubStore) Cleanup() {}
+
+func TestWithStore(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowed        bool
+		expectedStatus int
+	}{
+		{
+			name:           "store_allows",
+			allowed:        true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "store_rejects",
+			allowed:        false,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &stubStore{allowed: tt.allowed, calls: make(map[string]int)}
+			l := New(WithStore(s))
+			defer l.Stop()
+
+			h := Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set(XOFF, "1.1.1.1")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			assert.Equal(t, 1, s.calls["1.1.1.1"])
+		})
+	}
+}
+
+func TestMemoryStoreAllow(t *testing.T) {
+	s := newMemoryStore(defaultTTL, AlgoTokenBucket)
+
+	for i := 0; i < 3; i++ {
+		ok, err := s.Allow("1.1.1.1", 1, 3)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	ok, _ := s.Allow("1.1.1.1", 1, 3)
+	assert.False(t, ok)
+
+	ok, _ = s.Allow("2.2.2.2", 1, 3)
+	assert.True(t, ok)
+	assert.Equal(t, 2, s.Len())
+}
+
+func TestMemoryStoreConcurrentNewVisitor(t *testing.T) {
+	s := newMemoryStore(defaultTTL, AlgoTokenBucket)
+
+	for i := 0; i < 20; i++ {
+		ip := fmt.Sprintf("1.1.1.%d", i)
+
+		var (
+			wg      sync.WaitGroup
+			allowed atomic.Int64
+			start   = make(chan struct{})
+		)
+
+		for j := 0; j < 50; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+
+				if ok, _ := s.Allow(ip, rate.Every(time.Hour), 5); ok {
+					allowed.Add(1)
+				}
+			}()
+		}
+
+		close(start)
+		wg.Wait()
+
+		assert.Equal(t, int64(5), allowed.Load(), ip)
+	}
+}
+
+func TestMemoryStoreCleanup(t *testing.T) {
+	s := newMemoryStore(time.Minute, AlgoTokenBucket)
+
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"} {
+		_, _ = s.Allow(ip, 1, 1)
+	}
+
+	s.get("2.2.2.2").touch(time.Now().Add(-time.Hour))
+	s.get("4.4.4.4").touch(time.Now().Add(-time.Minute))
+	sh := s.shard("5.5.5.5")
+	sh.storage["5.5.5.5"] = nil
+	sh.keys = append(sh.keys, "5.5.5.5")
+
+	s.Cleanup()
+
+	keys := make([]string, 0, s.Len())
+	for _, sh := range s.shards {
+		for k := range sh.storage {
+			keys = append(keys, k)
+		}
+	}
+
+	assert.ElementsMatch(t, []string{"1.1.1.1", "3.3.3.3"}, keys)
+}
+
+func TestMemoryStoreCleanupConcurrent(t *testing.T) {
+	s := newShardedStore(time.Minute, AlgoTokenBucket, 1)
+	sh := s.shards[0]
+
+	for i := 0; i < 10*cleanupChunk; i++ {
+		_, _ = s.Allow(fmt.Sprintf("10.0.%d.%d", i/256, i%256), rate.Inf, 1)
+		if i%2 == 0 {
+			s.get(fmt.Sprintf("10.0.%d.%d", i/256, i%256)).touch(time.Now().Add(-time.Hour))
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < cleanupChunk; i++ {
+			_, _ = s.Allow(fmt.Sprintf("11.0.%d.%d", i/256, i%256), rate.Inf, 1)
+			s.Reset(fmt.Sprintf("10.0.%d.%d", i/256, i%256+1))
+		}
+	}()
+
+	s.Cleanup()
+	wg.Wait()
+
+	assert.Len(t, sh.storage, 5*cleanupChunk-cleanupChunk/2+cleanupChunk)
+	assert.Len(t, sh.keys, len(sh.storage))
+	for i, k := range sh.keys {
+		assert.Equal(t, i, sh.storage[k].idx, k)
+		assert.Less(t, time.Since(sh.storage[k].seen()), time.Minute, k)
+	}
+}
+
+func TestMemoryStoreTTL(t *testing.T) {
+	s := newMemoryStore(50*time.Millisecond, AlgoTokenBucket)
+
+	_, _ = s.Allow("1.1.1.1", 1, 1)
+	_, _ = s.Allow("2.2.2.2", 1, 1)
+
+	time.Sleep(30 * time.Millisecond)
+	_, _ = s.Allow("2.2.2.2", 1, 1)
+	time.Sleep(30 * time.Millisecond)
+
+	s.Cleanup()
+
+	assert.Nil(t, s.get("1.1.1.1"))
+	assert.NotNil(t, s.get("2.2.2.2"), "seen visitor is kept")
+}
+
+func TestStats(t *testing.T) {
+	l := New(RpsWithBurst(1, 1), AllowedIPs("9.9.9.9"))
+	defer l.Stop()
+
+	assert.Equal(t, Stats{}, l.Stats())
+
+	for _, ip := range []string{"1.1.1.1", "1.1.1.1", "2.2.2.2", "3.3.3.3", "9.9.9.9"} {
+		if !l.whiteListed(ip) {
+			l.allow(ip)
+		}
+	}
+
+	oldest := time.Now().Add(-time.Minute)
+	l.(*limiter).store.(*memoryStore).get("2.2.2.2").touch(oldest)
+
+	st := l.Stats()
+	assert.Equal(t, 3, st.Visitors)
+	assert.True(t, oldest.Equal(st.OldestLastSeen))
+	assert.Equal(t, uint64(3), st.Allowed)
+	assert.Equal(t, uint64(1), st.Rejected)
+}
+
+func TestRange(t *testing.T) {
+	c := &manualClock{now: time.Unix(1000, 0)}
+	l := New(RpsWithBurst(1, 5), WithClock(c))
+	defer l.Stop()
+
+	for i, ip := range []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"} {
+		c.now = c.now.Add(time.Millisecond)
+		for j := 0; j <= i; j++ {
+			l.allow(ip)
+		}
+	}
+
+	type visitor struct {
+		lastSeen time.Time
+		tokens   float64
+	}
+
+	got := map[string]visitor{}
+	l.Range(func(key string, lastSeen time.Time, tokens float64) bool {
+		got[key] = visitor{lastSeen, math.Round(tokens)}
+		return true
+	})
+
+	assert.Equal(t, map[string]visitor{
+		"1.1.1.1": {time.Unix(1000, int64(time.Millisecond)), 4},
+		"2.2.2.2": {time.Unix(1000, int64(2*time.Millisecond)), 3},
+		"3.3.3.3": {time.Unix(1000, int64(3*time.Millisecond)), 2},
+	}, got)
+
+	n := 0
+	l.Range(func(string, time.Time, float64) bool {
+		n++
+		return false
+	})
+	assert.Equal(t, 1, n)
+}
+
+func TestRangeReentrant(t *testing.T) {
+	l := New()
+	defer l.Stop()
+
+	for i := 0; i < 100; i++ {
+		l.allow(fmt.Sprintf("10.0.0.%d", i))
+	}
+
+	// f can use limiter, shard locks are not held while it runs.
+	n := 0
+	l.Range(func(key string, _ time.Time, _ float64) bool {
+		l.Reset(key)
+		l.allow(key)
+		n++
+		return true
+	})
+
+	assert.Equal(t, 100, n)
+}
+
+func TestRecordPool(t *testing.T) {
+	c := &manualClock{now: time.Unix(0, 0)}
+	s := newMemoryStore(time.Minute, AlgoTokenBucket)
+	s.clock = c
+
+	ok, _ := s.Allow("1.1.1.1", 1, 2)
+	assert.True(t, ok)
+	old := s.get("1.1.1.1")
+	// request that looked bucket up right before cleanup may still charge it.
+	stale := s.lookup("1.1.1.1")
+
+	c.now = c.now.Add(time.Hour)
+	s.Cleanup()
+	assert.Nil(t, s.get("1.1.1.1"))
+	assert.Equal(t, 0, s.Len())
+
+	ok, _ = s.Allow("2.2.2.2", 1, 2)
+	assert.True(t, ok)
+
+	r := s.get("2.2.2.2")
+	if r != old {
+		t.Skip("pool dropped removed record, it does so at random with race detector")
+	}
+
+	// reused record gets a new bucket of its visitor's limit, so the stale one charges nobody.
+	assert.NotSame(t, stale.(tokenBucket).Limiter, r.bucket.(tokenBucket).Limiter)
+	assert.True(t, stale.allow(c.now, 2))
+	assert.Equal(t, 2, r.burst)
+	assert.InDelta(t, 1, r.bucket.tokens(c.now), 0.001)
+}
+
+// atomicClock is manualClock that can be moved while other goroutines read it.
+type atomicClock struct {
+	now atomic.Int64
+}
+
+func (c *atomicClock) Now() time.Time {
+	return time.Unix(0, c.now.Load())
+}
+
+func (c *atomicClock) NewTicker(d time.Duration) Ticker {
+	return realClock{}.NewTicker(d)
+}
+
+func TestCleanupConcurrent(t *testing.T) {
+	for _, algo := range []Algo{AlgoTokenBucket, AlgoSlidingWindow, AlgoFixedWindow, AlgoGCRA, AlgoSlidingWindowCounter} {
+		t.Run(fmt.Sprint(algo), func(t *testing.T) {
+			c := &atomicClock{}
+			l := New(RpsWithBurst(1, 1), Algorithm(algo), WithClock(c), RecordTTL(time.Second), WithoutAutoCleanup())
+			defer l.Stop()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 500; j++ {
+						l.Allow(fmt.Sprintf("10.0.%d.%d", i, j%8))
+						runtime.Gosched()
+					}
+				}()
+			}
+
+			// back to back cleanups remove records requests may still hold, -race reports if their memory is reused meanwhile.
+			for i := 0; i < 500; i++ {
+				c.now.Add(int64(time.Second))
+				l.Cleanup()
+				runtime.Gosched()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestStatsConcurrent(t *testing.T) {
+	l := New()
+	defer l.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				l.allow(fmt.Sprintf("10.0.%d.%d", i, j))
+				_ = l.Stats()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 800, l.Stats().Visitors)
+}
+
+func TestSetLimit(t *testing.T) {
+	for _, algo := range []Algo{AlgoTokenBucket, AlgoSlidingWindow, AlgoFixedWindow, AlgoGCRA} {
+		t.Run(fmt.Sprint(algo), func(t *testing.T) {
+			l := New(RpsWithBurst(100, 10), Algorithm(algo))
+			defer l.Stop()
+
+			for i := 0; i < 3; i++ {
+				assert.True(t, l.allow("1.1.1.1"))
+			}
+
+			l.SetLimit(1, 1)
+
+			allowed := 0
+			for i := 0; i < 5; i++ {
+				if l.allow("1.1.1.1") {
+					allowed++
+				}
+			}
+			assert.LessOrEqual(t, allowed, 1)
+
+			assert.True(t, l.allow("2.2.2.2"))
+			assert.False(t, l.allow("2.2.2.2"))
+
+			l.SetLimit(-1, 5)
+			limit, burst := l.(*limiter).rate()
+			assert.Equal(t, rate.Limit(1), limit)
+			assert.Equal(t, 5, burst)
+		})
+	}
+}
+
+func TestSetLimitConcurrent(t *testing.T) {
+	algos := []Algo{AlgoTokenBucket, AlgoSlidingWindow, AlgoFixedWindow, AlgoGCRA, AlgoSlidingWindowCounter}
+	for _, algo := range algos {
+		t.Run(fmt.Sprint(algo), func(t *testing.T) {
+			l := New(RpsWithBurst(100, 10), Algorithm(algo))
+			defer l.Stop()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 1000; j++ {
+						l.Allow("k")
+						l.RetryAfter("k")
+						runtime.Gosched()
+					}
+				}()
+			}
+
+			// limits of live buckets change while requests are checked, -race reports unlocked reads of them.
+			s := l.(*limiter).store.(reconfigurer)
+			for i := 0; i < 1000; i++ {
+				if i%2 == 0 {
+					s.SetLimit(rate.Inf, 10)
+				} else {
+					s.SetLimit(10, 5)
+				}
+				runtime.Gosched()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestOverride(t *testing.T) {
+	l := New(RpsWithBurst(1, 2))
+	defer l.Stop()
+
+	l.Override("partner", 100, 10)
+
+	count := func(key string, n int) int {
+		allowed := 0
+		for i := 0; i < n; i++ {
+			if l.allow(key) {
+				allowed++
+			}
+		}
+
+		return allowed
+	}
+
+	assert.Equal(t, 10, count("partner", 12))
+	assert.Equal(t, 2, count("1.1.1.1", 12))
+
+	l.(*limiter).store.Cleanup()
+	l.(*limiter).store.(*memoryStore).get("partner").touch(time.Now().Add(-time.Hour))
+	l.(*limiter).store.Cleanup()
+
+	assert.Equal(t, 10, count("partner", 12), "override survives cleanup")
+
+	l.RemoveOverride("partner")
+	l.(*limiter).store.(*memoryStore).get("partner").touch(time.Now().Add(-time.Hour))
+	l.(*limiter).store.Cleanup()
+
+	assert.Equal(t, 2, count("partner", 12))
+}
+
+func TestOverrideExistingVisitor(t *testing.T) {
+	l := New(RpsWithBurst(1, 1))
+	defer l.Stop()
+
+	assert.True(t, l.allow("partner"))
+	assert.False(t, l.allow("partner"))
+
+	l.Override("partner", 1000, 5)
+	l.allow("partner")
+	time.Sleep(5 * time.Millisecond)
+
+	assert.True(t, l.allow("partner"))
+	assert.True(t, l.allow("partner"))
+
+	limit, burst := l.(*limiter).rateFor("partner")
+	assert.Equal(t, rate.Limit(1000), limit)
+	assert.Equal(t, 5, burst)
+
+	b := l.(*limiter).store.(*memoryStore).get("partner").bucket.(tokenBucket)
+	assert.Equal(t, rate.Limit(1000), b.Limit())
+	assert.Equal(t, 5, b.Burst())
+}
+
+func BenchmarkMemoryStoreAllow(b *testing.B) {
+	ips := make([]string, 1024)
+	for i := range ips {
+		ips[i] = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+	}
+
+	for _, n := range []int{1, defaultShards} {
+		b.Run(fmt.Sprintf("shards_%d", n), func(b *testing.B) {
+			s := newShardedStore(defaultTTL, AlgoTokenBucket, n)
+
+			var next atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(next.Add(97))
+				for pb.Next() {
+					_, _ = s.Allow(ips[i%len(ips)], rate.Inf, 1)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkMemoryStoreAllowSameIP(b *testing.B) {
+	s := newMemoryStore(defaultTTL, AlgoTokenBucket)
+	_, _ = s.Allow("1.1.1.1", rate.Inf, 1)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = s.Allow("1.1.1.1", rate.Inf, 1)
+		}
+	})
+}
+
+// BenchmarkMemoryStoreChurn measures allocations of visitors that are seen once and expire, with records reused from pool and without it.
+func BenchmarkMemoryStoreChurn(b *testing.B) {
+	ips := make([]string, 1024)
+	for i := range ips {
+		ips[i] = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+	}
+
+	for name, algo := range map[string]Algo{"token_bucket": AlgoTokenBucket, "sliding_window": AlgoSlidingWindow} {
+		for _, pooled := range []bool{false, true} {
+			b.Run(fmt.Sprintf("%s_pool_%t", name, pooled), func(b *testing.B) {
+				c := &manualClock{now: time.Unix(0, 0)}
+				s := newMemoryStore(time.Minute, algo)
+				s.clock = c
+				if !pooled {
+					s.pool = nil
+				}
+
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					_, _ = s.Allow(ips[i%len(ips)], 1, 1)
+
+					if i%len(ips) == len(ips)-1 {
+						c.now = c.now.Add(time.Hour)
+						s.Cleanup()
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkMemoryStoreCleanupWriterLatency measures how long adding a visitor can wait for cleanup of a large shard.
+func BenchmarkMemoryStoreCleanupWriterLatency(b *testing.B) {
+	const visitors = 200000
+
+	var worst time.Duration
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		s := newShardedStore(time.Minute, AlgoTokenBucket, 1)
+		for j := 0; j < visitors; j++ {
+			_, _ = s.Allow(fmt.Sprintf("10.%d.%d.%d", j>>16, j>>8&0xff, j&0xff), rate.Inf, 1)
+		}
+
+		old := time.Now().Add(-time.Hour)
+		for k, v := range s.shards[0].storage {
+			if k[len(k)-1]%2 == 0 {
+				v.touch(old)
+			}
+		}
+		b.StartTimer()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.Cleanup()
+		}()
+
+		for j := 0; ; j++ {
+			select {
+			case <-done:
+			default:
+				start := time.Now()
+				_, _ = s.Allow(fmt.Sprintf("11.0.%d.%d", j>>8&0xff, j&0xff), rate.Inf, 1)
+				worst = max(worst, time.Since(start))
+				runtime.Gosched()
+
+				continue
+			}
+
+			break
+		}
+	}
+
+	b.ReportMetric(float64(worst.Microseconds()), "max-writer-us")
+}
+
+func TestReset(t *testing.T) {
+	l := New(RpsWithBurst(1, 2), LimitPath("/a", New(RpsWithBurst(1, 1))))
+	defer l.Stop()
+
+	exhaust := func(l Limiter, key string) {
+		for l.allow(key) {
+		}
+	}
+
+	exhaust(l, "1.1.1.1")
+	exhaust(l, "2.2.2.2")
+
+	l.Reset("1.1.1.1")
+	assert.True(t, l.allow("1.1.1.1"))
+	assert.True(t, l.allow("1.1.1.1"))
+	assert.False(t, l.allow("1.1.1.1"))
+	assert.False(t, l.allow("2.2.2.2"))
+
+	route := l.route(http.MethodGet, "/a")
+	exhaust(route, "1.1.1.1")
+
+	l.ResetAll()
+	assert.True(t, l.allow("1.1.1.1"))
+	assert.True(t, l.allow("2.2.2.2"))
+	assert.True(t, route.allow("1.1.1.1"))
+}
+
+func TestMaxVisitors(t *testing.T) {
+	l := New(MaxVisitors(5))
+	defer l.Stop()
+
+	s := l.(*limiter).store.(*memoryStore)
+	start := time.Now().Add(-time.Hour)
+
+	for i := 0; i < 5; i++ {
+		ip := fmt.Sprintf("1.1.1.%d", i)
+		l.allow(ip)
+		s.get(ip).touch(start.Add(time.Duration(i) * time.Minute))
+	}
+
+	l.allow("1.1.1.0")
+
+	for i := 5; i < 7; i++ {
+		l.allow(fmt.Sprintf("1.1.1.%d", i))
+	}
+
+	assert.Equal(t, 5, s.Len())
+	assert.Nil(t, s.get("1.1.1.1"), "oldest is evicted")
+	assert.Nil(t, s.get("1.1.1.2"))
+	assert.NotNil(t, s.get("1.1.1.0"), "recently seen is kept")
+	assert.NotNil(t, s.get("1.1.1.6"))
+}
+
+func TestMaxVisitorsBounded(t *testing.T) {
+	s := newBoundedStore(defaultTTL, AlgoTokenBucket, 1000)
+
+	for i := 0; i < 5000; i++ {
+		_, _ = s.Allow(fmt.Sprintf("10.0.%d.%d", i/256, i%256), 1, 1)
+	}
+
+	assert.LessOrEqual(t, s.Len(), len(s.shards)*s.shardMax)
+	assert.LessOrEqual(t, s.Len(), 1000+len(s.shards))
+	assert.Greater(t, s.Len(), 900)
+}
+
+func TestCleanupWhenLargerThan(t *testing.T) {
+	l := New(CleanupWhenLargerThan(10), RecordTTL(10*time.Millisecond), CleanupFrequency(time.Hour))
+	defer l.Stop()
+
+	s := l.(*limiter).store.(*memoryStore)
+
+	for i := 0; i < 10; i++ {
+		l.allow(fmt.Sprintf("1.1.1.%d", i))
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 10, s.Len(), "no cleanup until size is exceeded")
+
+	l.allow("2.2.2.2")
+
+	assert.Eventually(t, func() bool { return s.Len() == 1 && s.size.Load() == 1 }, time.Second, time.Millisecond)
+	assert.NotNil(t, s.get("2.2.2.2"))
+}
+
+func TestCleanupWhenLargerThanHysteresis(t *testing.T) {
+	s := newMemoryStore(time.Hour, AlgoTokenBucket)
+	requests := 0
+	s.growLimit, s.onGrow = 10, func() { requests++ }
+	s.rearmGrow()
+
+	for i := 0; i < 100; i++ {
+		_, _ = s.Allow(fmt.Sprintf("1.1.%d.%d", i/256, i%256), 1, 1)
+	}
+
+	assert.Equal(t, 10, requests, "requested each time size grows by a quarter, not for every visitor")
+
+	// cleanup of fresh visitors removes nothing, next request waits for another quarter.
+	s.Cleanup()
+	requests = 0
+	for i := 100; i < 125; i++ {
+		_, _ = s.Allow(fmt.Sprintf("1.1.%d.%d", i/256, i%256), 1, 1)
+	}
+	assert.Zero(t, requests)
+
+	_, _ = s.Allow("2.2.2.2", 1, 1)
+	assert.Equal(t, 1, requests)
+
+	// after store is emptied growLimit applies again.
+	s.ResetAll()
+	requests = 0
+	for i := 0; i < 11; i++ {
+		_, _ = s.Allow(fmt.Sprintf("3.3.3.%d", i), 1, 1)
+	}
+	assert.Equal(t, 1, requests)
+}
+
+// manualClock is Clock that moves only when its time is set.
+type manualClock struct {
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	return c.now
+}
+
+func (c *manualClock) NewTicker(d time.Duration) Ticker {
+	return realClock{}.NewTicker(d)
+}
+
+func TestRefreshOnlyWhenAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		refresh bool
+		kept    bool
+	}{
+		{
+			name: "every_request",
+			kept: true,
+		},
+		{
+			name:    "allowed_requests",
+			refresh: true,
+			kept:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			c := &manualClock{now: start}
+
+			s := newMemoryStore(time.Minute, AlgoTokenBucket)
+			s.clock, s.refreshAllowed = c, tt.refresh
+
+			ok, _ := s.Allow("1.1.1.1", rate.Every(time.Hour), 1)
+			assert.True(t, ok)
+
+			c.now = start.Add(50 * time.Second)
+			ok, _ = s.Allow("1.1.1.1", rate.Every(time.Hour), 1)
+			assert.False(t, ok)
+
+			c.now = start.Add(70 * time.Second)
+			s.Cleanup()
+			assert.Equal(t, tt.kept, s.get("1.1.1.1") != nil)
+		})
+	}
+}
+
+func TestRefreshOnlyWhenAllowedOption(t *testing.T) {
+	l := New(RefreshOnlyWhenAllowed())
+	defer l.Stop()
+
+	assert.True(t, l.(*limiter).store.(*memoryStore).refreshAllowed)
+}
+
+func TestHashKeys(t *testing.T) {
+	l := New(RpsWithBurst(1, 2), HashKeys("pepper"), AllowedIPs("9.9.9.9"))
+	defer l.Stop()
+
+	assert.True(t, l.Allow("1.1.1.1"))
+	assert.True(t, l.Allow("1.1.1.1"))
+	assert.False(t, l.Allow("1.1.1.1"), "hashed key keeps its bucket")
+	assert.True(t, l.Allow("2.2.2.2"))
+
+	for i := 0; i < 5; i++ {
+		assert.True(t, l.Allow("9.9.9.9"), "whitelist matches raw ip")
+	}
+
+	var keys []string
+	l.Range(func(key string, _ time.Time, _ float64) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	assert.Len(t, keys, 2)
+	for _, k := range keys {
+		assert.Regexp(t, "^[0-9a-f]{32}$", k)
+		assert.NotContains(t, k, "1.1.1.1")
+	}
+
+	other := New(HashKeys("salt"))
+	defer other.Stop()
+	assert.NotEqual(t, l.(*limiter).storeKey("1.1.1.1", ""), other.(*limiter).storeKey("1.1.1.1", ""), "salt changes hashes")
+
+	l.Reset("1.1.1.1")
+	assert.True(t, l.Allow("1.1.1.1"), "reset finds hashed key")
+}
+
+func TestWarmUp(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []option
+		allowed int
+		later   int
+	}{
+		{name: "without warm up", allowed: 10, later: 5},
+		{name: "warm up", opts: []option{WarmUp(5 * time.Second)}, allowed: 5, later: 5},
+		{name: "long warm up", opts: []option{WarmUp(time.Hour)}, allowed: 1, later: 5},
+	}
+
+	// count returns how many requests of ip are allowed at once.
+	count := func(l Limiter, ip string) int {
+		n := 0
+		for l.Allow(ip) {
+			n++
+		}
+
+		return n
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &manualClock{now: time.Unix(0, 0)}
+			l := New(append([]option{RpsWithBurst(1, 10), WithClock(c)}, tt.opts...)...)
+			defer l.Stop()
+
+			assert.Equal(t, tt.allowed, count(l, "1.1.1.1"))
+
+			c.now = c.now.Add(5 * time.Second)
+			assert.Equal(t, tt.later, count(l, "1.1.1.1"))
+		})
+	}
+}
+
+func TestWarmUpFillsBurst(t *testing.T) {
+	c := &manualClock{now: time.Unix(0, 0)}
+	l := New(RpsWithBurst(1, 10), WarmUp(5*time.Second), WithClock(c))
+	defer l.Stop()
+
+	assert.True(t, l.Allow("1.1.1.1"))
+
+	c.now = c.now.Add(time.Minute)
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.Allow("1.1.1.1"))
+	}
+	assert.False(t, l.Allow("1.1.1.1"))
+}
+
+func TestDeleteExpired(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := &manualClock{now: start}
+	s := newMemoryStore(time.Minute, AlgoTokenBucket)
+	s.clock = c
+
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"} {
+		_, _ = s.Allow(ip, 1, 1)
+	}
+
+	c.now = start.Add(30 * time.Second)
+	_, _ = s.Allow("3.3.3.3", 1, 1)
+
+	assert.Equal(t, 0, s.DeleteExpired(time.Minute, start.Add(59*time.Second)))
+	assert.Equal(t, 2, s.DeleteExpired(time.Minute, start.Add(time.Minute)))
+	assert.Equal(t, 1, s.Len())
+	assert.Equal(t, 0, s.DeleteExpired(time.Minute, start.Add(time.Minute)))
+	assert.Equal(t, 1, s.DeleteExpired(time.Second, start.Add(time.Minute)))
+	assert.Equal(t, 0, s.Len())
+}
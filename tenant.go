@@ -0,0 +1,87 @@
+package limiter
+
+import (
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// TenantHeader keys requests by value of header, for example X-Tenant-ID, so every tenant has one budget whichever ips its traffic
+// comes from. Requests without the header are limited by ip. Tenants get own limits with TenantLimits or Override and are whitelisted
+// with AllowedTenants. It sets KeyFunc, which fiberlimit.Limit doesnt use.
+func TenantHeader(name string) option {
+	var errs []error
+
+	if name == "" {
+		errs = append(errs, invalidOption("empty tenant header"))
+		return func(opts *limiterOptions) {
+			opts.errs = append(opts.errs, errs...)
+		}
+	}
+
+	keyFunc := KeyFunc(func(r *http.Request) string {
+		return r.Header.Get(name)
+	})
+
+	return func(opts *limiterOptions) {
+		opts.tenantHeader = name
+		keyFunc(opts)
+	}
+}
+
+// TenantLimits sets rps and burst of tenants keyed by TenantHeader, other tenants are limited by defaults. They are overrides,
+// so they can be changed later with Override and RemoveOverride.
+func TenantLimits(limits map[string]RpsBurst) option {
+	var errs []error
+
+	for tenant, l := range limits {
+		if l.Rps < 0 || l.Burst < 0 {
+			errs = append(errs, invalidOption("negative limits %v of tenant %q", l, tenant))
+		}
+	}
+
+	return func(opts *limiterOptions) {
+		if len(errs) > 0 {
+			opts.errs = append(opts.errs, errs...)
+			return
+		}
+
+		if opts.tenantLimits == nil {
+			opts.tenantLimits = make(map[string]rateLimit, len(limits))
+		}
+
+		for tenant, l := range limits {
+			opts.tenantLimits[tenant] = rateLimit{rate.Limit(l.Rps), l.Burst}
+		}
+	}
+}
+
+// AllowedTenants takes tenants keyed by TenantHeader that are not limited. They are matched against the header only,
+// so a tenant named like an ip does not whitelist that ip.
+func AllowedTenants(tenants ...string) option {
+	return func(opts *limiterOptions) {
+		if opts.allowedTenants == nil {
+			opts.allowedTenants = make(map[string]struct{}, len(tenants))
+		}
+
+		for _, t := range tenants {
+			opts.allowedTenants[t] = struct{}{}
+		}
+	}
+}
+
+// tenantAllowed reports whether request has TenantHeader of tenant set by AllowedTenants.
+func (lim *limiter) tenantAllowed(r *http.Request) bool {
+	if lim.opts.tenantHeader == "" || len(lim.opts.allowedTenants) == 0 {
+		return false
+	}
+
+	tenant := r.Header.Get(lim.opts.tenantHeader)
+	if tenant == "" {
+		return false
+	}
+
+	_, ok := lim.opts.allowedTenants[tenant]
+
+	return ok
+}
@@ -0,0 +1,73 @@
+package limiter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			l := New(RpsWithBurst(1, 2), TenantHeader("X-Tenant-ID"),
+				TenantLimits(map[string]RpsBurst{"big": {Rps: 1, Burst: 4}}), AllowedTenants("internal", "3.3.3.3"))
+			defer l.Stop()
+
+			h := handler(l)
+			do := func(tenant, ip string) int {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set(XOFF, ip)
+				if tenant != "" {
+					req.Header.Set("X-Tenant-ID", tenant)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				return rec.Code
+			}
+
+			// allowed counts requests of tenant from a new ip each until one is rejected.
+			allowed := func(tenant string) int {
+				for i := 0; i < 10; i++ {
+					if do(tenant, fmt.Sprintf("10.0.0.%d", i)) == http.StatusTooManyRequests {
+						return i
+					}
+				}
+
+				return 10
+			}
+
+			assert.Equal(t, 2, allowed("acme"))
+			assert.Equal(t, 2, allowed("globex"))
+			assert.Equal(t, 4, allowed("big"))
+			assert.Equal(t, 10, allowed("internal"))
+
+			// requests without tenant are limited by ip.
+			assert.Equal(t, http.StatusOK, do("", "1.1.1.1"))
+			assert.Equal(t, http.StatusOK, do("", "1.1.1.1"))
+			assert.Equal(t, http.StatusTooManyRequests, do("", "1.1.1.1"))
+			assert.Equal(t, http.StatusOK, do("", "2.2.2.2"))
+
+			// allowed tenants dont whitelist ips of the same name.
+			assert.Equal(t, http.StatusOK, do("", "3.3.3.3"))
+			assert.Equal(t, http.StatusOK, do("", "3.3.3.3"))
+			assert.Equal(t, http.StatusTooManyRequests, do("", "3.3.3.3"))
+		})
+	}
+}
+
+func TestTenantOptionsInvalid(t *testing.T) {
+	_, err := NewWithError(TenantHeader(""))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+
+	_, err = NewWithError(TenantLimits(map[string]RpsBurst{"acme": {Rps: -1}}))
+	assert.ErrorIs(t, err, ErrInvalidOption)
+}
@@ -0,0 +1,140 @@
+package limiter
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type (
+	// TierConfig is one of limits enforced together by WithTiers. Rps can be fractional, so 1000 requests per minute is 1000.0 / 60.
+	TierConfig struct {
+		Rps   float64
+		Burst int
+	}
+
+	// tieredBucket allows request only if main bucket and every extra tier allow it. Tokens are taken from none of them otherwise.
+	tieredBucket struct {
+		main   bucket
+		extras []*rate.Limiter
+	}
+)
+
+// WithTiers enforces several limits together, request is allowed only when all of them allow it, for example short burst limit and long sustained one.
+// First tier replaces rps and burst and is the one changed by SetLimit and overrides, other tiers are token buckets fixed for every visitor.
+// Extra tiers are applied by in-memory store only, they have no effect when store is set with WithStore.
+func WithTiers(tiers ...TierConfig) option {
+	var errs []error
+
+	if len(tiers) == 0 {
+		errs = append(errs, invalidOption("no tiers"))
+	}
+
+	for _, t := range tiers {
+		if t.Rps < 0 || t.Burst < 0 {
+			errs = append(errs, invalidOption("negative tier %v", t))
+		}
+	}
+
+	return func(opts *limiterOptions) {
+		if len(errs) > 0 {
+			opts.errs = append(opts.errs, errs...)
+			return
+		}
+
+		opts.tiers = tiers
+	}
+}
+
+// newTieredBucket returns main bucket alone if there are no extra tiers.
+func newTieredBucket(main bucket, tiers []rateLimit) bucket {
+	if len(tiers) == 0 {
+		return main
+	}
+
+	b := &tieredBucket{main: main, extras: make([]*rate.Limiter, len(tiers))}
+	for i, t := range tiers {
+		b.extras[i] = rate.NewLimiter(t.limit, t.burst)
+	}
+
+	return b
+}
+
+// allow reserves tokens of extra tiers first and gives them back if any of them, or main bucket, rejects request.
+func (b *tieredBucket) allow(now time.Time, n int) bool {
+	reserved := make([]*rate.Reservation, 0, len(b.extras))
+	cancel := func() {
+		for _, r := range reserved {
+			r.CancelAt(now)
+		}
+	}
+
+	for _, l := range b.extras {
+		r := l.ReserveN(now, n)
+		if !r.OK() {
+			cancel()
+			return false
+		}
+
+		reserved = append(reserved, r)
+
+		if r.DelayFrom(now) > 0 {
+			cancel()
+			return false
+		}
+	}
+
+	if !b.main.allow(now, n) {
+		cancel()
+		return false
+	}
+
+	return true
+}
+
+// penalize takes tokens from main bucket only, extra tiers are fixed.
+func (b *tieredBucket) penalize(now time.Time, n int) {
+	b.main.penalize(now, n)
+}
+
+// refund gives tokens back to every tier, as allow took them from all of them.
+func (b *tieredBucket) refund(now time.Time, n int) {
+	b.main.refund(now, n)
+	for _, l := range b.extras {
+		tokenBucket{l}.refund(now, n)
+	}
+}
+
+// delay returns the longest delay of all tiers.
+func (b *tieredBucket) delay(now time.Time) (time.Duration, bool) {
+	d, ok := b.main.delay(now)
+	if !ok {
+		return 0, false
+	}
+
+	for _, l := range b.extras {
+		ed, eok := tokenBucket{l}.delay(now)
+		if !eok {
+			return 0, false
+		}
+
+		d = max(d, ed)
+	}
+
+	return d, true
+}
+
+// tokens returns the smallest number of tokens of all tiers.
+func (b *tieredBucket) tokens(now time.Time) float64 {
+	t := b.main.tokens(now)
+	for _, l := range b.extras {
+		t = min(t, l.TokensAt(now))
+	}
+
+	return t
+}
+
+// setLimit changes main bucket only, extra tiers are fixed.
+func (b *tieredBucket) setLimit(limit rate.Limit, burst int) {
+	b.main.setLimit(limit, burst)
+}
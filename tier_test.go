@@ -0,0 +1,62 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTiers(t *testing.T) {
+	l := New(WithTiers(TierConfig{Rps: 1000, Burst: 5}, TierConfig{Rps: 1.0 / 60, Burst: 8}))
+	defer l.Stop()
+
+	count := func() int {
+		allowed := 0
+		for i := 0; i < 10; i++ {
+			if l.allow("1.1.1.1") {
+				allowed++
+			}
+		}
+
+		return allowed
+	}
+
+	assert.Equal(t, 5, count(), "short tier caps burst")
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 3, count(), "long tier caps sustained traffic")
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, count())
+}
+
+func TestTieredBucket(t *testing.T) {
+	now := time.Now()
+	b := newTieredBucket(newBucket(AlgoTokenBucket, 10, 2), []rateLimit{{limit: 1, burst: 3}})
+
+	assert.True(t, b.allow(now, 2))
+	assert.False(t, b.allow(now, 1), "main bucket is empty")
+
+	d, ok := b.delay(now)
+	assert.True(t, ok)
+	assert.Equal(t, 100*time.Millisecond, d)
+
+	now = now.Add(200 * time.Millisecond)
+	assert.False(t, b.allow(now, 2), "extra tier has one token left")
+	assert.True(t, b.allow(now, 1), "rejected request took no tokens from main bucket")
+
+	d, ok = b.delay(now)
+	assert.True(t, ok)
+	assert.InDelta(t, float64(800*time.Millisecond), float64(d), float64(time.Millisecond), "extra tier delay is the longest")
+
+	b.setLimit(100, 10)
+	assert.Equal(t, 10, b.(*tieredBucket).main.(tokenBucket).Burst())
+}
+
+func TestInvalidTiers(t *testing.T) {
+	for _, opt := range []option{WithTiers(), WithTiers(TierConfig{Rps: -1, Burst: 1})} {
+		_, err := NewWithError(opt)
+		assert.ErrorIs(t, err, ErrInvalidOption)
+	}
+}
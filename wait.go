@@ -0,0 +1,55 @@
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// Wait blocks until request identified by key is allowed or ctx is done, in which case its error is returned. It is Allow
+// for callers that can wait instead of being rejected, for example job processors. Whitelisted keys return right away,
+// overrides are applied like in Allow. With GlobalLimit it then waits for the shared bucket too, in real time. Blocked keys get ErrBlocked, keys that will never be allowed, because of zero limit or burst, get ErrRateLimited.
+// Rejected attempts are not counted in Stats and metrics.
+func (lim *limiter) Wait(ctx context.Context, key string) error {
+	if lim.blocked(key) {
+		return ErrBlocked
+	}
+
+	if lim.whiteListed(key) {
+		return nil
+	}
+
+	// empty key is decided right away by its policy, unless it shares a bucket.
+	if key == "" && (lim.opts.rejectMissingKey || lim.opts.noKey != SharedBucket) {
+		if lim.allowN(key, "", 1) {
+			return nil
+		}
+
+		return ErrRateLimited
+	}
+
+	limit, burst := lim.rateFor(key)
+	for {
+		if ok, _ := lim.store.Allow(lim.storeKey(key, ""), limit, burst); ok {
+			lim.metrics.observe(true)
+			lim.allowedTotal.Add(1)
+
+			if lim.global != nil {
+				return lim.global.Wait(ctx)
+			}
+
+			return nil
+		}
+
+		if limit <= 0 || burst <= 0 {
+			return ErrRateLimited
+		}
+
+		t := time.NewTimer(max(time.Millisecond, lim.retryAfter(key, "")))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWait(t *testing.T) {
+	l := New(RpsWithBurst(10, 1))
+	defer l.Stop()
+
+	ctx := context.Background()
+	assert.NoError(t, l.Wait(ctx, "job"))
+
+	start := time.Now()
+	assert.NoError(t, l.Wait(ctx, "job"))
+	assert.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond, "waits for token")
+	assert.Equal(t, uint64(2), l.Stats().Allowed)
+	assert.Zero(t, l.Stats().Rejected)
+}
+
+func TestWaitContext(t *testing.T) {
+	l := New(RpsWithBurst(1, 1))
+	defer l.Stop()
+
+	assert.NoError(t, l.Wait(context.Background(), "job"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	assert.ErrorIs(t, l.Wait(ctx, "job"), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestWaitOptions(t *testing.T) {
+	l := New(RpsWithBurst(1, 1), AllowedIPs("trusted"), BlockedIPs("banned"))
+	defer l.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, l.Wait(ctx, "trusted"), "whitelisted key returns right away")
+	}
+
+	assert.ErrorIs(t, l.Wait(ctx, "banned"), ErrBlocked)
+
+	l.Override("partner", 1, 3)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, l.Wait(ctx, "partner"), "override burst")
+	}
+
+	l.Override("never", 0, 0)
+	assert.ErrorIs(t, l.Wait(ctx, "never"), ErrRateLimited)
+}
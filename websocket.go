@@ -0,0 +1,39 @@
+package limiter
+
+import "strings"
+
+// WebSocketPolicy sets limiter used for WebSocket upgrade requests, detected by "Upgrade: websocket" header, instead of the one being created,
+// so handshakes have connection rate budget of their own and do not use up budget of ordinary requests.
+// With nil l upgrade requests are not limited at all, blocked ips are still rejected. By default upgrades are limited like any other request.
+// Policy applies to requests the limiter is responsible for, set it on limiters set by LimitPath to cover their routes.
+// Limiter set by WebSocketPolicy is stopped together with the default one.
+func WebSocketPolicy(l Limiter) option {
+	return func(opts *limiterOptions) {
+		opts.webSocket = l
+		opts.webSocketSet = true
+	}
+}
+
+// webSocket returns limiter responsible for request with header and whether request is limited at all.
+func (lim *limiter) webSocket(header func(string) string) (Limiter, bool) {
+	if !lim.opts.webSocketSet || !isWebSocket(header) {
+		return lim, true
+	}
+
+	if lim.opts.webSocket == nil {
+		return lim, false
+	}
+
+	return lim.opts.webSocket, true
+}
+
+// isWebSocket reports whether Upgrade header lists websocket protocol.
+func isWebSocket(header func(string) string) bool {
+	for _, p := range strings.Split(header("Upgrade"), ",") {
+		if strings.EqualFold(strings.TrimSpace(p), "websocket") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,87 @@
+package limiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebSocketPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := middlewares(nil)
+
+	tests := []struct {
+		name     string
+		opts     func() []option
+		upgrades []int
+		requests []int
+	}{
+		{
+			name:     "default",
+			opts:     func() []option { return nil },
+			upgrades: []int{http.StatusOK},
+			requests: []int{http.StatusTooManyRequests},
+		},
+		{
+			name:     "skip",
+			opts:     func() []option { return []option{WebSocketPolicy(nil)} },
+			upgrades: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+			requests: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:     "separate",
+			opts:     func() []option { return []option{WebSocketPolicy(New(RpsWithBurst(1, 2)))} },
+			upgrades: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+			requests: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+	}
+
+	for name, handler := range handlers {
+		for _, tt := range tests {
+			t.Run(name+"_"+tt.name, func(t *testing.T) {
+				l := New(append([]option{RpsWithBurst(1, 1)}, tt.opts()...)...)
+				defer l.Stop()
+
+				h := handler(l)
+				do := func(upgrade bool) int {
+					req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+					req.Header.Set(XOFF, "1.1.1.1")
+					if upgrade {
+						req.Header.Set("Connection", "Upgrade")
+						req.Header.Set("Upgrade", "WebSocket")
+					}
+
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, req)
+
+					return rec.Code
+				}
+
+				for i, code := range tt.upgrades {
+					assert.Equal(t, code, do(true), "upgrade %d", i)
+				}
+
+				for i, code := range tt.requests {
+					assert.Equal(t, code, do(false), "request %d", i)
+				}
+			})
+		}
+	}
+}
+
+func TestWebSocketPolicyBlocked(t *testing.T) {
+	l := New(WebSocketPolicy(nil), BlockedIPs("1.1.1.1"))
+	defer l.Stop()
+
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set(XOFF, "1.1.1.1")
+	req.Header.Set("Upgrade", "websocket")
+
+	rec := httptest.NewRecorder()
+	Limit(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}